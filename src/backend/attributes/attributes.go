@@ -0,0 +1,215 @@
+// Package attributes validates the free-form profile fields stored on users
+// (phone, locale, job title, deployment-specific fields...) against a JSON
+// Schema that admins manage at runtime.
+package attributes
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Eduardo-Barreto/web-ponderada/backend/models"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/repository"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// Name the schema is compiled under; never fetched
+const schemaURL = "mem:///user-attributes.json"
+
+// Keeps a single user's attributes from growing without bound
+const maxAttributesBytes = 16 << 10
+
+// DefaultSchema is enforced until an admin sets one. It describes the
+// common profile fields; deployments add their own by replacing it.
+var DefaultSchema = json.RawMessage(`{
+  "$schema": "https://json-schema.org/draft/2020-12/schema",
+  "type": "object",
+  "properties": {
+    "phone": {"type": "string", "pattern": "^\\+?[0-9 ()-]{6,20}$"},
+    "locale": {"type": "string", "pattern": "^[a-z]{2,3}(-[A-Z]{2})?$"},
+    "timezone": {"type": "string", "format": "timezone"},
+    "job_title": {"type": "string", "maxLength": 100}
+  },
+  "additionalProperties": false
+}`)
+
+// Error lists every problem found in a set of attributes, keyed by the
+// location of the offending value (e.g. "/phone")
+type Error struct {
+	Fields map[string][]string
+}
+
+func (e *Error) Error() string {
+	locations := make([]string, 0, len(e.Fields))
+	for location, messages := range e.Fields {
+		locations = append(locations, location+": "+strings.Join(messages, ", "))
+	}
+	sort.Strings(locations)
+	return strings.Join(locations, "; ")
+}
+
+// Service validates attributes against the current schema. The compiled
+// schema is cached until another version is saved.
+type Service struct {
+	SchemaRepo repository.AttributeSchemaRepository
+
+	mu       sync.Mutex
+	cachedID int
+	cached   *jsonschema.Schema
+}
+
+func NewService(schemaRepo repository.AttributeSchemaRepository) *Service {
+	return &Service{SchemaRepo: schemaRepo, cachedID: -1}
+}
+
+// Compile parses a schema, rejecting it unless it describes a JSON object
+func Compile(schema json.RawMessage) (*jsonschema.Schema, error) {
+	var root map[string]interface{}
+	if err := json.Unmarshal(schema, &root); err != nil {
+		return nil, errors.New("schema must be a JSON object")
+	}
+	if root["type"] != "object" {
+		return nil, errors.New(`schema must have "type": "object"`)
+	}
+
+	compiler := jsonschema.NewCompiler()
+	compiler.AssertFormat = true
+	compiler.Formats["timezone"] = isTimezone
+	// Schemas come from admins; never let one make the server read files or URLs
+	compiler.LoadURL = func(s string) (io.ReadCloser, error) {
+		return nil, fmt.Errorf("external references are not allowed: %s", s)
+	}
+	if err := compiler.AddResource(schemaURL, bytes.NewReader(schema)); err != nil {
+		return nil, err
+	}
+	return compiler.Compile(schemaURL)
+}
+
+// isTimezone accepts IANA time zone names such as "America/Sao_Paulo"
+func isTimezone(v interface{}) bool {
+	name, ok := v.(string)
+	if !ok {
+		return true // Formats only constrain strings
+	}
+	_, err := time.LoadLocation(name)
+	return err == nil && name != "" && name != "Local"
+}
+
+// CurrentSchema returns the schema in force, falling back to DefaultSchema
+func (s *Service) CurrentSchema(ctx context.Context) (*models.AttributeSchema, error) {
+	schema, err := s.SchemaRepo.GetCurrentSchema(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if schema == nil {
+		return &models.AttributeSchema{Schema: DefaultSchema}, nil
+	}
+	return schema, nil
+}
+
+// SetSchema saves a new version of the schema once it compiles. Stored
+// attributes are not rechecked; each user's are validated on their next change.
+func (s *Service) SetSchema(ctx context.Context, schema json.RawMessage, adminID int) (*models.AttributeSchema, error) {
+	if _, err := Compile(schema); err != nil {
+		return nil, &Error{Fields: map[string][]string{"schema": {err.Error()}}}
+	}
+
+	// Stored compact so equal schemas look equal
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, schema); err != nil {
+		return nil, err
+	}
+	version := &models.AttributeSchema{Schema: compact.Bytes(), CreatedBy: &adminID}
+	if _, err := s.SchemaRepo.CreateSchema(ctx, version); err != nil {
+		return nil, err
+	}
+	return version, nil
+}
+
+// Merge applies a partial update to a user's attributes: keys in the patch
+// replace stored ones and a null value removes the key. The repository
+// performs the same merge when saving.
+func Merge(current, patch map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(current)+len(patch))
+	for key, value := range current {
+		merged[key] = value
+	}
+	for key, value := range patch {
+		if value == nil {
+			delete(merged, key)
+		} else {
+			merged[key] = value
+		}
+	}
+	return merged
+}
+
+// Validate checks a complete set of attributes against the current schema.
+// Problems with the attributes are returned as *Error.
+func (s *Service) Validate(ctx context.Context, attrs map[string]interface{}) error {
+	data, err := json.Marshal(attrs)
+	if err != nil {
+		return fmt.Errorf("failed to encode attributes: %w", err)
+	}
+	if len(data) > maxAttributesBytes {
+		return &Error{Fields: map[string][]string{"": {fmt.Sprintf("attributes must not exceed %d bytes", maxAttributesBytes)}}}
+	}
+
+	schema, err := s.compiledSchema(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Round-trip so the validator only sees plain JSON types
+	var instance interface{}
+	if err := json.Unmarshal(data, &instance); err != nil {
+		return fmt.Errorf("failed to decode attributes: %w", err)
+	}
+	err = schema.Validate(instance)
+	if err == nil {
+		return nil
+	}
+	var validationErr *jsonschema.ValidationError
+	if !errors.As(err, &validationErr) {
+		return fmt.Errorf("failed to validate attributes: %w", err)
+	}
+
+	fields := map[string][]string{}
+	for _, cause := range validationErr.BasicOutput().Errors {
+		// Skip the summary entries that only wrap the specific failures below them
+		if strings.HasPrefix(cause.Error, "doesn't validate with") {
+			continue
+		}
+		fields[cause.InstanceLocation] = append(fields[cause.InstanceLocation], cause.Error)
+	}
+	if len(fields) == 0 {
+		fields[validationErr.InstanceLocation] = []string{validationErr.Message}
+	}
+	return &Error{Fields: fields}
+}
+
+func (s *Service) compiledSchema(ctx context.Context) (*jsonschema.Schema, error) {
+	current, err := s.CurrentSchema(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cached != nil && s.cachedID == current.ID {
+		return s.cached, nil
+	}
+	schema, err := Compile(current.Schema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile attribute schema %d: %w", current.ID, err)
+	}
+	s.cached, s.cachedID = schema, current.ID
+	return schema, nil
+}
@@ -0,0 +1,149 @@
+package attributes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Eduardo-Barreto/web-ponderada/backend/models"
+)
+
+// fakeSchemaRepo keeps schema versions in memory
+type fakeSchemaRepo struct {
+	versions []models.AttributeSchema
+}
+
+func (r *fakeSchemaRepo) GetCurrentSchema(ctx context.Context) (*models.AttributeSchema, error) {
+	if len(r.versions) == 0 {
+		return nil, nil
+	}
+	current := r.versions[len(r.versions)-1]
+	return &current, nil
+}
+
+func (r *fakeSchemaRepo) CreateSchema(ctx context.Context, schema *models.AttributeSchema) (int, error) {
+	schema.ID = len(r.versions) + 1
+	r.versions = append(r.versions, *schema)
+	return schema.ID, nil
+}
+
+func TestMerge(t *testing.T) {
+	tests := []struct {
+		name    string
+		current map[string]interface{}
+		patch   map[string]interface{}
+		want    map[string]interface{}
+	}{
+		{"add to empty", nil, map[string]interface{}{"locale": "pt-BR"}, map[string]interface{}{"locale": "pt-BR"}},
+		{"replace", map[string]interface{}{"locale": "en"}, map[string]interface{}{"locale": "pt-BR"}, map[string]interface{}{"locale": "pt-BR"}},
+		{"keep unpatched keys", map[string]interface{}{"locale": "en", "job_title": "Dev"}, map[string]interface{}{"locale": "pt-BR"}, map[string]interface{}{"locale": "pt-BR", "job_title": "Dev"}},
+		{"null removes", map[string]interface{}{"locale": "en", "job_title": "Dev"}, map[string]interface{}{"job_title": nil}, map[string]interface{}{"locale": "en"}},
+		{"null for a missing key", map[string]interface{}{"locale": "en"}, map[string]interface{}{"phone": nil}, map[string]interface{}{"locale": "en"}},
+		{"empty patch", map[string]interface{}{"locale": "en"}, nil, map[string]interface{}{"locale": "en"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Merge(tt.current, tt.patch); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Merge = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	current := map[string]interface{}{"locale": "en"}
+	Merge(current, map[string]interface{}{"locale": nil})
+	if current["locale"] != "en" {
+		t.Error("Merge modified the current attributes")
+	}
+}
+
+func TestValidateDefaultSchema(t *testing.T) {
+	service := NewService(&fakeSchemaRepo{})
+
+	tests := []struct {
+		name          string
+		attrs         map[string]interface{}
+		wantLocations []string // nil when valid
+	}{
+		{"empty", map[string]interface{}{}, nil},
+		{"common fields", map[string]interface{}{"phone": "+55 (11) 91234-5678", "locale": "pt-BR", "timezone": "America/Sao_Paulo", "job_title": "Engineer"}, nil},
+		{"bad phone", map[string]interface{}{"phone": "call me"}, []string{"/phone"}},
+		{"bad locale", map[string]interface{}{"locale": "portuguese"}, []string{"/locale"}},
+		{"unknown timezone", map[string]interface{}{"timezone": "Mars/Olympus_Mons"}, []string{"/timezone"}},
+		{"Local is not a timezone", map[string]interface{}{"timezone": "Local"}, []string{"/timezone"}},
+		{"wrong type", map[string]interface{}{"job_title": 42}, []string{"/job_title"}},
+		{"job title too long", map[string]interface{}{"job_title": strings.Repeat("a", 101)}, []string{"/job_title"}},
+		{"unknown field", map[string]interface{}{"shoe_size": "42"}, []string{""}},
+		{"several problems", map[string]interface{}{"phone": "x", "locale": "y"}, []string{"/locale", "/phone"}},
+		{"too large", map[string]interface{}{"job_title": strings.Repeat("a", maxAttributesBytes)}, []string{""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := service.Validate(context.Background(), tt.attrs)
+			if tt.wantLocations == nil {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			var attrErr *Error
+			if !errors.As(err, &attrErr) {
+				t.Fatalf("got error %v, want *Error", err)
+			}
+			var locations []string
+			for location := range attrErr.Fields {
+				locations = append(locations, location)
+			}
+			slices.Sort(locations)
+			if !slices.Equal(locations, tt.wantLocations) {
+				t.Errorf("got problems at %v, want %v: %v", locations, tt.wantLocations, attrErr)
+			}
+		})
+	}
+}
+
+func TestValidateUsesNewSchema(t *testing.T) {
+	service := NewService(&fakeSchemaRepo{})
+	attrs := map[string]interface{}{"shoe_size": 42}
+	if err := service.Validate(context.Background(), attrs); err == nil {
+		t.Fatal("the default schema accepted an unknown field")
+	}
+
+	schema := json.RawMessage(`{"type": "object", "properties": {"shoe_size": {"type": "integer"}}}`)
+	if _, err := service.SetSchema(context.Background(), schema, 1); err != nil {
+		t.Fatalf("SetSchema: %v", err)
+	}
+	if err := service.Validate(context.Background(), attrs); err != nil {
+		t.Errorf("the cached default schema is still used: %v", err)
+	}
+}
+
+func TestCompileRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+	}{
+		{"not JSON", `{"type": `},
+		{"array", `[{"type": "object"}]`},
+		{"no type", `{"properties": {}}`},
+		{"not an object type", `{"type": "string"}`},
+		{"external reference", `{"type": "object", "properties": {"a": {"$ref": "https://example.com/a.json"}}}`},
+		{"file reference", `{"type": "object", "properties": {"a": {"$ref": "file:///etc/passwd"}}}`},
+		{"invalid keyword value", `{"type": "object", "properties": {"a": {"maxLength": "ten"}}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Compile(json.RawMessage(tt.schema)); err == nil {
+				t.Errorf("Compile accepted %s", tt.schema)
+			}
+		})
+	}
+
+	if _, err := Compile(DefaultSchema); err != nil {
+		t.Errorf("the default schema does not compile: %v", err)
+	}
+}
@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/Eduardo-Barreto/web-ponderada/backend/models"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/repository"
+)
+
+// APIKeyPrefix starts every API key, which lets AuthMiddleware tell them
+// apart from JWTs and makes leaked keys easy to spot
+const APIKeyPrefix = "wp_"
+
+const (
+	apiKeyPrefixAlphabet = "abcdefghijklmnopqrstuvwxyz0123456789"
+	apiKeyPrefixLength   = 8
+
+	// Writing last_used_at on every request would be wasteful; once per
+	// interval is precise enough to spot unused keys
+	apiKeyTouchInterval = time.Minute
+)
+
+var (
+	ErrInvalidAPIKey = errors.New("invalid api key")
+	ErrAPIKeyRevoked = errors.New("api key has been revoked")
+	ErrAPIKeyExpired = errors.New("api key has expired")
+)
+
+var apiKeyRepo repository.APIKeyRepository
+
+// SetAPIKeyRepository makes ValidateAPIKey look keys up in the given repository.
+// Passing nil disables API key authentication.
+func SetAPIKeyRepository(repo repository.APIKeyRepository) {
+	apiKeyRepo = repo
+}
+
+// IsAPIKey reports whether a bearer credential looks like an API key
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, APIKeyPrefix)
+}
+
+// GenerateAPIKey returns a new key of the form wp_<prefix>_<secret> along
+// with its visible prefix (wp_<prefix>)
+func GenerateAPIKey() (key string, prefix string, err error) {
+	b := make([]byte, apiKeyPrefixLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	for i := range b {
+		b[i] = apiKeyPrefixAlphabet[int(b[i])%len(apiKeyPrefixAlphabet)]
+	}
+	prefix = APIKeyPrefix + string(b)
+
+	secret, err := GenerateOpaqueToken()
+	if err != nil {
+		return "", "", err
+	}
+	return prefix + "_" + secret, prefix, nil
+}
+
+// ValidateAPIKey looks up an API key, checks it is still usable and records
+// that it was used
+func ValidateAPIKey(ctx context.Context, key string) (*models.APIKey, error) {
+	if apiKeyRepo == nil {
+		return nil, ErrInvalidAPIKey
+	}
+
+	stored, err := apiKeyRepo.GetAPIKeyByHash(ctx, HashToken(key))
+	if err != nil {
+		if err.Error() == "api key not found" {
+			return nil, ErrInvalidAPIKey
+		}
+		log.Printf("Error looking up api key: %v", err)
+		return nil, errors.New("unable to verify api key")
+	}
+
+	now := time.Now()
+	if stored.RevokedAt != nil {
+		return nil, ErrAPIKeyRevoked
+	}
+	if stored.ExpiresAt != nil && now.After(*stored.ExpiresAt) {
+		return nil, ErrAPIKeyExpired
+	}
+
+	if stored.LastUsedAt == nil || now.Sub(*stored.LastUsedAt) > apiKeyTouchInterval {
+		if err := apiKeyRepo.TouchAPIKey(ctx, stored.ID, now); err != nil {
+			log.Printf("Warning: Failed to record use of api key %d: %v", stored.ID, err)
+		}
+		stored.LastUsedAt = &now
+	}
+	return stored, nil
+}
@@ -2,75 +2,98 @@
 package auth
 
 import (
+	"context"
 	"errors"
 	"time"
 	"log"
 
 	"github.com/golang-jwt/jwt/v5"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/google/uuid"
 	"github.com/Eduardo-Barreto/web-ponderada/backend/config"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/models"
 )
 
 var jwtKey []byte
 
 func InitializeAuth() {
 	jwtKey = []byte(config.AppConfig.JWTSecret)
+
+	if err := loadPasswordPolicy(); err != nil {
+		log.Fatalf("Invalid password policy: %v", err)
+	}
+	if breachedPasswords != nil {
+		log.Printf("Rejecting %s", breachedPasswords.describe())
+	}
+
+	// Asymmetric keys replace the shared secret, so other services can verify
+	// tokens through the JWKS endpoint without being able to mint them
+	if dir := config.AppConfig.JWTKeysDir; dir != "" {
+		ring, err := loadKeyring(dir, config.AppConfig.JWTSigningKeyID)
+		if err != nil {
+			log.Fatalf("Failed to load JWT keys: %v", err)
+		}
+		keyring = ring
+		log.Printf("Signing tokens with key %q (%s), %d key(s) accepted for verification", ring.signing.kid, ring.signing.method.Alg(), len(ring.keys))
+		return
+	}
+
 	if config.AppConfig.JWTSecret == "a-very-secret-key" {
 		log.Println("WARNING: Using default JWT secret. Set JWT_SECRET environment variable in production!")
 	}
 }
 
-// HashPassword generates a bcrypt hash of the password
-func HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost) // Use default cost
-	return string(bytes), err
-}
-
-// CheckPasswordHash compares a plain password with a hash
-func CheckPasswordHash(password, hash string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-	return err == nil
-}
+// Subject of access tokens. Other signed tokens (e.g. email verification)
+// use their own subject so they can never be accepted as access tokens.
+const accessTokenSubject = "user_auth"
 
 // Claims defines the structure of the JWT claims
 type Claims struct {
-	UserID int    `json:"user_id"`
-	Email  string `json:"email"`
+	UserID       int    `json:"user_id"`
+	Email        string `json:"email"`
+	Role         string `json:"role"`
+	TokenVersion int    `json:"ver"` // Must match users.token_version for the token to be accepted
+	MFA          bool   `json:"mfa,omitempty"` // The user has 2FA enabled, so the login included a second factor
+	SessionID    string `json:"sid,omitempty"` // Session the token belongs to, see RevocationStore.RevokeSession
+	ActorID      int    `json:"act,omitempty"` // Admin acting as UserID; set only on impersonation tokens
 	jwt.RegisteredClaims
 }
 
-// GenerateToken creates a new JWT token for a user
-func GenerateToken(userID int, email string) (string, error) {
-	// Set expiration time (e.g., 24 hours)
-	expirationTime := time.Now().Add(24 * time.Hour)
+// GenerateToken creates a new JWT token for a user's session
+func GenerateToken(user *models.User, sessionID string) (string, error) {
+	// Access tokens are short-lived; clients renew them with a refresh token
+	expirationTime := time.Now().Add(config.AppConfig.AccessTokenTTL)
 
 	claims := &Claims{
-		UserID: userID,
-		Email:  email,
+		UserID:       user.ID,
+		Email:        user.Email,
+		Role:         user.Role,
+		TokenVersion: user.TokenVersion,
+		// Users with 2FA can only obtain tokens through the second factor, and
+		// enabling it revokes older sessions, so this reflects how they logged in
+		MFA: user.TOTPEnabledAt != nil,
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.New().String(), // jti, lets a single token be revoked
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			Subject:   "user_auth",
+			Subject:   accessTokenSubject,
+			Issuer:    config.AppConfig.JWTIssuer,
+			Audience:  jwt.ClaimStrings{config.AppConfig.JWTAudience},
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(jwtKey)
-
-	return tokenString, err
+	return signToken(claims)
 }
 
-// ValidateToken parses and validates a JWT token string
+// ValidateToken parses and validates a JWT token string. Besides the subject,
+// the issuer and audience must match, as any other verifier should check.
 func ValidateToken(tokenStr string) (*Claims, error) {
 	claims := &Claims{}
 
-	token, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
-		// Validate the alg is what you expect:
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, errors.New("unexpected signing method")
-		}
-		return jwtKey, nil
-	})
+	token, err := jwt.ParseWithClaims(tokenStr, claims, keyFunc,
+		jwt.WithSubject(accessTokenSubject),
+		jwt.WithIssuer(config.AppConfig.JWTIssuer),
+		jwt.WithAudience(config.AppConfig.JWTAudience))
 
 	if err != nil {
 		if errors.Is(err, jwt.ErrTokenExpired) {
@@ -83,5 +106,17 @@ func ValidateToken(tokenStr string) (*Claims, error) {
 		return nil, errors.New("invalid token")
 	}
 
+	// Consult the denylist and the user's token version, if configured
+	if revocationStore != nil {
+		revoked, err := revocationStore.IsRevoked(context.Background(), claims)
+		if err != nil {
+			log.Printf("Error checking token revocation: %v", err)
+			return nil, errors.New("unable to verify token")
+		}
+		if revoked {
+			return nil, errors.New("token has been revoked")
+		}
+	}
+
 	return claims, nil
 }
@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Eduardo-Barreto/web-ponderada/backend/config"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/models"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestValidateTokenChecksIssuerAndAudience(t *testing.T) {
+	config.AppConfig = &config.Config{
+		JWTSecret:            "test-secret",
+		JWTIssuer:            "http://localhost:8000",
+		JWTAudience:          "web-ponderada-api",
+		AccessTokenTTL:       time.Minute,
+		ImpersonationTTL:     time.Minute,
+		MFAPendingTTL:        time.Minute,
+		EmailVerificationTTL: time.Minute,
+	}
+	InitializeAuth()
+	user := &models.User{ID: 2, Email: "maria@example.com", Role: models.RoleUser}
+
+	// sign is GenerateToken with the issuer and audience replaced
+	sign := func(issuer string, audience ...string) string {
+		t.Helper()
+		now := time.Now()
+		token, err := signToken(&Claims{
+			UserID: user.ID,
+			Email:  user.Email,
+			Role:   user.Role,
+			RegisteredClaims: jwt.RegisteredClaims{
+				ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+				IssuedAt:  jwt.NewNumericDate(now),
+				Subject:   accessTokenSubject,
+				Issuer:    issuer,
+				Audience:  audience,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	must := func(token string, err error) string {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	impersonation, _, err := GenerateImpersonationToken(user, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		token     string
+		wantValid bool
+	}{
+		{"access token", must(GenerateToken(user, "")), true},
+		{"impersonation token", impersonation, true},
+		{"audience among others", sign("http://localhost:8000", "other-api", "web-ponderada-api"), true},
+		{"other issuer", sign("https://elsewhere.example.com", "web-ponderada-api"), false},
+		{"other audience", sign("http://localhost:8000", "other-api"), false},
+		{"no issuer or audience", sign(""), false},
+		{"mfa challenge", must(GenerateMFAPendingToken(user)), false},
+		{"email verification link", must(GenerateEmailVerificationToken(user)), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ValidateToken(tt.token)
+			if valid := err == nil; valid != tt.wantValid {
+				t.Errorf("valid = %v, want %v (err: %v)", valid, tt.wantValid, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,23 @@
+package auth
+
+import "crypto/subtle"
+
+// Names used by the cookie session mode (see SESSION_COOKIES). The access
+// and refresh tokens travel in HttpOnly cookies that scripts cannot read;
+// the CSRF cookie is readable and must be echoed in CSRFHeader on unsafe
+// requests, which a cross-site form or fetch cannot do (double submit).
+const (
+	AccessTokenCookie  = "access_token"
+	RefreshTokenCookie = "refresh_token"
+	CSRFCookie         = "csrf_token"
+
+	CSRFHeader = "X-CSRF-Token"
+
+	// Sent with "cookie" by clients that want their tokens as cookies
+	AuthModeHeader = "X-Auth-Mode"
+)
+
+// CSRFTokenMatches reports whether the header echoes the CSRF cookie
+func CSRFTokenMatches(cookie, header string) bool {
+	return cookie != "" && subtle.ConstantTimeCompare([]byte(cookie), []byte(header)) == 1
+}
@@ -0,0 +1,23 @@
+package auth
+
+import (
+	"errors"
+
+	"github.com/Eduardo-Barreto/web-ponderada/backend/config"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/models"
+)
+
+const emailVerificationSubject = "email_verify"
+
+// GenerateEmailVerificationToken signs a token proving control of user.Email.
+// It is stateless: nothing is stored, the signature and expiry are enough.
+func GenerateEmailVerificationToken(user *models.User) (string, error) {
+	return generatePurposeToken(emailVerificationSubject, user, config.AppConfig.EmailVerificationTTL)
+}
+
+// ValidateEmailVerificationToken parses a token from a verification link
+func ValidateEmailVerificationToken(tokenStr string) (*PurposeClaims, error) {
+	return parsePurposeToken(tokenStr, emailVerificationSubject,
+		errors.New("verification link has expired"),
+		errors.New("invalid verification link"))
+}
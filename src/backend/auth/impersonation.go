@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Eduardo-Barreto/web-ponderada/backend/config"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/models"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/repository"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+var ErrAuditLogUnavailable = errors.New("impersonation audit log not configured")
+
+var impersonationAuditRepo repository.ImpersonationAuditRepository
+
+// SetImpersonationAuditRepository sets where requests made with
+// impersonation tokens are recorded. Without it such requests are refused.
+func SetImpersonationAuditRepository(repo repository.ImpersonationAuditRepository) {
+	impersonationAuditRepo = repo
+}
+
+// IsImpersonation reports whether the token was issued to an admin acting as the user
+func (c *Claims) IsImpersonation() bool {
+	return c.ActorID != 0
+}
+
+// GenerateImpersonationToken creates a short-lived access token that lets an
+// admin act as the given user. It has no session or refresh token, so it
+// cannot be renewed, and carries the admin's ID in the "act" claim.
+func GenerateImpersonationToken(subject *models.User, actorID int) (string, *Claims, error) {
+	now := time.Now()
+	claims := &Claims{
+		UserID:       subject.ID,
+		Email:        subject.Email,
+		Role:         subject.Role,
+		TokenVersion: subject.TokenVersion,
+		MFA:          subject.TOTPEnabledAt != nil,
+		ActorID:      actorID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.New().String(),
+			ExpiresAt: jwt.NewNumericDate(now.Add(config.AppConfig.ImpersonationTTL)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			Subject:   accessTokenSubject,
+			Issuer:    config.AppConfig.JWTIssuer,
+			Audience:  jwt.ClaimStrings{config.AppConfig.JWTAudience},
+		},
+	}
+
+	token, err := signToken(claims)
+	if err != nil {
+		return "", nil, err
+	}
+	return token, claims, nil
+}
+
+// RecordImpersonation adds an entry to the impersonation audit log
+func RecordImpersonation(ctx context.Context, entry *models.ImpersonationAuditEntry) error {
+	if impersonationAuditRepo == nil {
+		return ErrAuditLogUnavailable
+	}
+	_, err := impersonationAuditRepo.CreateAuditEntry(ctx, entry)
+	return err
+}
+
+// CompleteImpersonation records the response status of an audited request
+func CompleteImpersonation(ctx context.Context, entry *models.ImpersonationAuditEntry, statusCode int) error {
+	if impersonationAuditRepo == nil {
+		return ErrAuditLogUnavailable
+	}
+	return impersonationAuditRepo.SetAuditEntryStatus(ctx, entry.ID, statusCode)
+}
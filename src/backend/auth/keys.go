@@ -0,0 +1,200 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// jwtKeyPair is one key of the keyring. Retired keys may only have the
+// public half, which is enough to verify tokens signed before a rotation.
+type jwtKeyPair struct {
+	kid     string
+	method  jwt.SigningMethod
+	private crypto.Signer // nil for verification-only keys
+	public  crypto.PublicKey
+}
+
+// keyring is set when tokens are signed with asymmetric keys; when nil,
+// tokens are signed and verified with the shared HMAC secret (jwtKey)
+var keyring *jwtKeyring
+
+type jwtKeyring struct {
+	signing *jwtKeyPair
+	keys    map[string]*jwtKeyPair // kid -> key, every key accepted for verification
+}
+
+// loadKeyring reads every *.pem file in dir; each file name (without the
+// extension) becomes the key's kid. RSA keys sign with RS256, Ed25519 keys
+// with EdDSA. signingKID picks the signing key, and may be empty when the
+// directory holds a single private key.
+func loadKeyring(dir, signingKID string) (*jwtKeyring, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list keys in %s: %w", dir, err)
+	}
+	sort.Strings(paths)
+
+	ring := &jwtKeyring{keys: make(map[string]*jwtKeyPair)}
+	var privateKIDs []string
+	for _, path := range paths {
+		kid := strings.TrimSuffix(filepath.Base(path), ".pem")
+		key, err := loadKeyFile(path, kid)
+		if err != nil {
+			return nil, err
+		}
+		ring.keys[kid] = key
+		if key.private != nil {
+			privateKIDs = append(privateKIDs, kid)
+		}
+	}
+	if len(ring.keys) == 0 {
+		return nil, fmt.Errorf("no *.pem keys found in %s", dir)
+	}
+
+	if signingKID == "" {
+		if len(privateKIDs) != 1 {
+			return nil, fmt.Errorf("found %d private keys in %s, set JWT_SIGNING_KEY_ID to choose one", len(privateKIDs), dir)
+		}
+		signingKID = privateKIDs[0]
+	}
+	signing, ok := ring.keys[signingKID]
+	if !ok || signing.private == nil {
+		return nil, fmt.Errorf("signing key %q not found as a private key in %s", signingKID, dir)
+	}
+	ring.signing = signing
+
+	return ring, nil
+}
+
+func loadKeyFile(path, kid string) (*jwtKeyPair, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key %s: %w", path, err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("key %s is not PEM encoded", path)
+	}
+
+	var parsed interface{}
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("key %s has unsupported PEM type %q", path, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse key %s: %w", path, err)
+	}
+
+	key := &jwtKeyPair{kid: kid}
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.method, key.private, key.public = jwt.SigningMethodRS256, k, &k.PublicKey
+	case *rsa.PublicKey:
+		key.method, key.public = jwt.SigningMethodRS256, k
+	case ed25519.PrivateKey:
+		key.method, key.private, key.public = jwt.SigningMethodEdDSA, k, k.Public()
+	case ed25519.PublicKey:
+		key.method, key.public = jwt.SigningMethodEdDSA, k
+	default:
+		return nil, fmt.Errorf("key %s must be RSA or Ed25519, got %T", path, parsed)
+	}
+	return key, nil
+}
+
+// signToken signs claims with the current signing key, or the HMAC secret
+// when no keyring is configured
+func signToken(claims jwt.Claims) (string, error) {
+	if keyring == nil {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(jwtKey)
+	}
+
+	token := jwt.NewWithClaims(keyring.signing.method, claims)
+	token.Header["kid"] = keyring.signing.kid
+	return token.SignedString(keyring.signing.private)
+}
+
+// keyFunc returns the key used to verify a token's signature
+func keyFunc(token *jwt.Token) (interface{}, error) {
+	if keyring == nil {
+		// Validate the alg is what you expect:
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("unexpected signing method")
+		}
+		return jwtKey, nil
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	key, ok := keyring.keys[kid]
+	if !ok {
+		return nil, errors.New("unknown signing key")
+	}
+	// The alg header must match the key, or an RSA public key could be
+	// abused as an HMAC secret
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, errors.New("unexpected signing method")
+	}
+	return key.public, nil
+}
+
+// JWK is a public key in JSON Web Key format (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`   // RSA modulus
+	E   string `json:"e,omitempty"`   // RSA exponent
+	Crv string `json:"crv,omitempty"` // OKP curve
+	X   string `json:"x,omitempty"`   // OKP public key
+}
+
+// JWKS returns every verification key, for other services to verify our
+// tokens. Empty when tokens are signed with the shared HMAC secret.
+func JWKS() []JWK {
+	jwks := []JWK{}
+	if keyring == nil {
+		return jwks
+	}
+
+	kids := make([]string, 0, len(keyring.keys))
+	for kid := range keyring.keys {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+
+	for _, kid := range kids {
+		key := keyring.keys[kid]
+		jwk := JWK{Kid: kid, Use: "sig", Alg: key.method.Alg()}
+		switch pub := key.public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		}
+		jwks = append(jwks, jwk)
+	}
+	return jwks
+}
@@ -0,0 +1,55 @@
+package auth
+
+import (
+	"crypto/rand"
+	"errors"
+	"strings"
+
+	"github.com/Eduardo-Barreto/web-ponderada/backend/config"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/models"
+)
+
+const mfaPendingSubject = "mfa_pending"
+
+const recoveryCodeCount = 10
+
+// GenerateMFAPendingToken signs the short-lived token returned by Login when
+// the password was correct but a second factor is still required
+func GenerateMFAPendingToken(user *models.User) (string, error) {
+	return generatePurposeToken(mfaPendingSubject, user, config.AppConfig.MFAPendingTTL)
+}
+
+// ValidateMFAPendingToken parses an MFA pending token
+func ValidateMFAPendingToken(tokenStr string) (*PurposeClaims, error) {
+	return parsePurposeToken(tokenStr, mfaPendingSubject,
+		errors.New("mfa session has expired, please login again"),
+		errors.New("invalid mfa token"))
+}
+
+// GenerateRecoveryCodes returns a fresh set of one-time recovery codes
+// formatted like "abcde-fghij". Only their hashes (HashToken) are stored.
+func GenerateRecoveryCodes() ([]string, error) {
+	// 32 symbols (no l/o/0/1) so every random byte maps without bias
+	const alphabet = "abcdefghijkmnpqrstuvwxyz23456789"
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		for j := range b {
+			b[j] = alphabet[int(b[j])%len(alphabet)]
+		}
+		codes[i] = string(b[:5]) + "-" + string(b[5:])
+	}
+	return codes, nil
+}
+
+// NormalizeRecoveryCode makes user input comparable with a generated code
+func NormalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), " ", ""))
+	if len(code) == 10 && !strings.Contains(code, "-") {
+		code = code[:5] + "-" + code[5:]
+	}
+	return code
+}
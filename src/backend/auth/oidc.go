@@ -0,0 +1,204 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Eduardo-Barreto/web-ponderada/backend/config"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+)
+
+const oidcStateSubject = "oidc_state"
+
+// OIDCStateTTL bounds how long the user can take at the identity provider
+const OIDCStateTTL = 10 * time.Minute
+
+var (
+	ErrUnknownOIDCProvider = errors.New("unknown identity provider")
+	ErrInvalidOIDCState    = errors.New("invalid or expired login session, please try again")
+)
+
+// OIDCIdentity is what a verified ID token tells us about the user
+type OIDCIdentity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// oidcStateClaims are kept in a signed cookie between the redirect to the
+// provider and the callback, so no server-side session is needed
+type oidcStateClaims struct {
+	Provider string `json:"provider"`
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"` // PKCE code verifier
+	jwt.RegisteredClaims
+}
+
+type oidcProvider struct {
+	oauth2   oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+// OIDCClient runs the authorization code + PKCE flow against the configured
+// providers. Discovery happens on first use, so the API starts even when a
+// provider is unreachable.
+type OIDCClient struct {
+	configs map[string]config.OIDCProvider
+
+	mu        sync.Mutex
+	providers map[string]*oidcProvider
+}
+
+func NewOIDCClient(configs map[string]config.OIDCProvider) *OIDCClient {
+	return &OIDCClient{configs: configs, providers: make(map[string]*oidcProvider)}
+}
+
+// ProviderNames lists the configured providers in a stable order
+func (o *OIDCClient) ProviderNames() []string {
+	names := make([]string, 0, len(o.configs))
+	for name := range o.configs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// BeginLogin returns the provider URL to send the browser to, and the signed
+// state the caller must keep in a cookie until the callback
+func (o *OIDCClient) BeginLogin(ctx context.Context, name string) (authURL string, stateToken string, err error) {
+	provider, err := o.provider(name)
+	if err != nil {
+		return "", "", err
+	}
+
+	state, err := GenerateOpaqueToken()
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := GenerateOpaqueToken()
+	if err != nil {
+		return "", "", err
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	claims := &oidcStateClaims{
+		Provider: name,
+		State:    state,
+		Nonce:    nonce,
+		Verifier: verifier,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(OIDCStateTTL)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			Subject:   oidcStateSubject,
+		},
+	}
+	stateToken, err = signToken(claims)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to sign oidc state: %w", err)
+	}
+
+	authURL = provider.oauth2.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier))
+	return authURL, stateToken, nil
+}
+
+// CompleteLogin checks the callback against the state cookie, redeems the
+// authorization code and verifies the returned ID token
+func (o *OIDCClient) CompleteLogin(ctx context.Context, name, stateToken, state, code string) (*OIDCIdentity, error) {
+	claims := &oidcStateClaims{}
+	token, err := jwt.ParseWithClaims(stateToken, claims, keyFunc, jwt.WithSubject(oidcStateSubject))
+	if err != nil || !token.Valid {
+		return nil, ErrInvalidOIDCState
+	}
+	if claims.Provider != name || subtle.ConstantTimeCompare([]byte(claims.State), []byte(state)) != 1 {
+		return nil, ErrInvalidOIDCState
+	}
+
+	provider, err := o.provider(name)
+	if err != nil {
+		return nil, err
+	}
+
+	oauthToken, err := provider.oauth2.Exchange(ctx, code, oauth2.VerifierOption(claims.Verifier))
+	if err != nil {
+		return nil, fmt.Errorf("failed to exchange authorization code: %w", err)
+	}
+	rawIDToken, ok := oauthToken.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("provider did not return an id_token")
+	}
+
+	idToken, err := provider.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("invalid id_token: %w", err)
+	}
+	if subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(claims.Nonce)) != 1 {
+		return nil, errors.New("id_token nonce mismatch")
+	}
+
+	var idClaims struct {
+		Email         string      `json:"email"`
+		EmailVerified interface{} `json:"email_verified"` // Some providers send "true" as a string
+		Name          string      `json:"name"`
+	}
+	if err := idToken.Claims(&idClaims); err != nil {
+		return nil, fmt.Errorf("failed to parse id_token claims: %w", err)
+	}
+
+	return &OIDCIdentity{
+		Provider:      name,
+		Subject:       idToken.Subject,
+		Email:         idClaims.Email,
+		EmailVerified: idClaims.EmailVerified == true || idClaims.EmailVerified == "true",
+		Name:          idClaims.Name,
+	}, nil
+}
+
+// provider returns the discovered provider, running discovery on first use
+func (o *OIDCClient) provider(name string) (*oidcProvider, error) {
+	cfg, ok := o.configs[name]
+	if !ok {
+		return nil, ErrUnknownOIDCProvider
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if p, ok := o.providers[name]; ok {
+		return p, nil
+	}
+
+	// The provider keeps this context for refreshing its signing keys later,
+	// so it must not be tied to the current request
+	discovered, err := oidc.NewProvider(context.Background(), cfg.IssuerURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover oidc provider %q: %w", name, err)
+	}
+
+	scopes := cfg.Scopes
+	if !contains(scopes, oidc.ScopeOpenID) {
+		scopes = append([]string{oidc.ScopeOpenID}, scopes...)
+	}
+
+	p := &oidcProvider{
+		oauth2: oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			RedirectURL:  cfg.RedirectURL,
+			Endpoint:     discovered.Endpoint(),
+			Scopes:       scopes,
+		},
+		verifier: discovered.Verifier(&oidc.Config{ClientID: cfg.ClientID}),
+	}
+	o.providers[name] = p
+	return p, nil
+}
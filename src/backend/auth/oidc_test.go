@@ -0,0 +1,162 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Eduardo-Barreto/web-ponderada/backend/auth/oidctest"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/config"
+)
+
+func newTestOIDCClient(t *testing.T) (*OIDCClient, *oidctest.Provider) {
+	t.Helper()
+	config.AppConfig = &config.Config{JWTSecret: "test-secret"}
+	InitializeAuth()
+
+	provider := oidctest.NewProvider(t)
+	settings := config.OIDCProvider{
+		IssuerURL:    provider.URL,
+		ClientID:     provider.ClientID,
+		ClientSecret: provider.ClientSecret,
+		RedirectURL:  "http://localhost:8080/api/v1/auth/oidc/test/callback",
+	}
+	client := NewOIDCClient(map[string]config.OIDCProvider{"test": settings, "other": settings})
+	return client, provider
+}
+
+func TestOIDCCompleteLogin(t *testing.T) {
+	client, provider := newTestOIDCClient(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name              string
+		claims            map[string]interface{}
+		wantEmailVerified bool
+	}{
+		{"verified", map[string]interface{}{"email_verified": true}, true},
+		{"verified as a string", map[string]interface{}{"email_verified": "true"}, true},
+		{"unverified", map[string]interface{}{"email_verified": false}, false},
+		{"not stated", map[string]interface{}{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authURL, stateToken, err := client.BeginLogin(ctx, "test")
+			if err != nil {
+				t.Fatalf("BeginLogin: %v", err)
+			}
+			claims := map[string]interface{}{"sub": "user-123", "email": "maria@example.com", "name": "Maria"}
+			for name, value := range tt.claims {
+				claims[name] = value
+			}
+			state, code := provider.Authorize(t, authURL, claims)
+
+			identity, err := client.CompleteLogin(ctx, "test", stateToken, state, code)
+			if err != nil {
+				t.Fatalf("CompleteLogin: %v", err)
+			}
+			want := OIDCIdentity{Provider: "test", Subject: "user-123", Email: "maria@example.com", EmailVerified: tt.wantEmailVerified, Name: "Maria"}
+			if *identity != want {
+				t.Errorf("got identity %+v, want %+v", *identity, want)
+			}
+		})
+	}
+}
+
+func TestOIDCCompleteLoginRejects(t *testing.T) {
+	client, provider := newTestOIDCClient(t)
+	ctx := context.Background()
+	claims := map[string]interface{}{"sub": "user-123", "email": "maria@example.com", "email_verified": true}
+
+	begin := func() (authURL, stateToken string) {
+		authURL, stateToken, err := client.BeginLogin(ctx, "test")
+		if err != nil {
+			t.Fatalf("BeginLogin: %v", err)
+		}
+		return authURL, stateToken
+	}
+
+	tests := []struct {
+		name string
+		// run completes a login that must fail
+		run     func() error
+		wantErr error // nil for any error other than ErrInvalidOIDCState
+	}{
+		{"state mismatch", func() error {
+			authURL, stateToken := begin()
+			_, code := provider.Authorize(t, authURL, claims)
+			_, err := client.CompleteLogin(ctx, "test", stateToken, "forged-state", code)
+			return err
+		}, ErrInvalidOIDCState},
+		{"no state cookie", func() error {
+			authURL, _ := begin()
+			state, code := provider.Authorize(t, authURL, claims)
+			_, err := client.CompleteLogin(ctx, "test", "", state, code)
+			return err
+		}, ErrInvalidOIDCState},
+		{"state cookie signed by someone else", func() error {
+			authURL, stateToken := begin()
+			state, code := provider.Authorize(t, authURL, claims)
+			parts := strings.Split(stateToken, ".")
+			_, err := client.CompleteLogin(ctx, "test", parts[0]+"."+parts[1]+".c2lnbmF0dXJl", state, code)
+			return err
+		}, ErrInvalidOIDCState},
+		{"callback for another provider", func() error {
+			authURL, stateToken := begin()
+			state, code := provider.Authorize(t, authURL, claims)
+			_, err := client.CompleteLogin(ctx, "other", stateToken, state, code)
+			return err
+		}, ErrInvalidOIDCState},
+		{"PKCE verifier of another login", func() error {
+			// A code obtained for one login, injected into the victim's callback
+			authURL, _ := begin()
+			_, code := provider.Authorize(t, authURL, claims)
+			victimURL, victimToken := begin()
+			victimState, _ := provider.Authorize(t, victimURL, claims)
+			_, err := client.CompleteLogin(ctx, "test", victimToken, victimState, code)
+			return err
+		}, nil},
+		{"code redeemed twice", func() error {
+			authURL, stateToken := begin()
+			state, code := provider.Authorize(t, authURL, claims)
+			if _, err := client.CompleteLogin(ctx, "test", stateToken, state, code); err != nil {
+				t.Fatalf("first CompleteLogin: %v", err)
+			}
+			_, err := client.CompleteLogin(ctx, "test", stateToken, state, code)
+			return err
+		}, nil},
+		{"nonce mismatch", func() error {
+			authURL, stateToken := begin()
+			state, code := provider.Authorize(t, authURL, map[string]interface{}{"sub": "user-123", "nonce": "replayed-nonce"})
+			_, err := client.CompleteLogin(ctx, "test", stateToken, state, code)
+			return err
+		}, nil},
+		{"expired id_token", func() error {
+			authURL, stateToken := begin()
+			state, code := provider.Authorize(t, authURL, map[string]interface{}{"sub": "user-123", "exp": time.Now().Add(-time.Hour).Unix()})
+			_, err := client.CompleteLogin(ctx, "test", stateToken, state, code)
+			return err
+		}, nil},
+		{"id_token for another client", func() error {
+			authURL, stateToken := begin()
+			state, code := provider.Authorize(t, authURL, map[string]interface{}{"sub": "user-123", "aud": "another-client"})
+			_, err := client.CompleteLogin(ctx, "test", stateToken, state, code)
+			return err
+		}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.run()
+			switch {
+			case err == nil:
+				t.Error("CompleteLogin succeeded")
+			case tt.wantErr != nil && !errors.Is(err, tt.wantErr):
+				t.Errorf("got error %v, want %v", err, tt.wantErr)
+			case tt.wantErr == nil && errors.Is(err, ErrInvalidOIDCState):
+				t.Errorf("got error %v from the state check, want the provider's answer to be rejected", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+// Package oidctest runs a mock OpenID Connect provider for tests: discovery,
+// a JWKS with one RSA key and a token endpoint that enforces PKCE. There is
+// no login page; Authorize plays the user consenting at the provider.
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const keyID = "oidctest"
+
+// Provider is a running mock issuer. Its URL is the issuer URL.
+type Provider struct {
+	*httptest.Server
+	ClientID     string
+	ClientSecret string
+
+	key *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]authorization
+}
+
+// authorization is what the provider remembers about an issued code
+type authorization struct {
+	challenge string
+	claims    jwt.MapClaims
+}
+
+// NewProvider starts a provider that is closed when the test ends
+func NewProvider(t testing.TB) *Provider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &Provider{
+		ClientID:     "web-ponderada",
+		ClientSecret: "client-secret",
+		key:          key,
+		codes:        make(map[string]authorization),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("/jwks", p.jwks)
+	mux.HandleFunc("/token", p.token)
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+// Authorize answers the authorization request in authURL as if the user
+// signed in, returning the state to pass back and the authorization code.
+// The ID token will carry the claims, which may override the standard ones.
+func (p *Provider) Authorize(t testing.TB, authURL string, claims map[string]interface{}) (state, code string) {
+	t.Helper()
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := parsed.Query()
+	if query.Get("client_id") != p.ClientID || query.Get("code_challenge_method") != "S256" {
+		t.Fatalf("unexpected authorization request %s", authURL)
+	}
+
+	idClaims := jwt.MapClaims{
+		"iss":   p.URL,
+		"aud":   p.ClientID,
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Minute).Unix(),
+		"nonce": query.Get("nonce"),
+	}
+	for name, value := range claims {
+		idClaims[name] = value
+	}
+
+	code = randomString()
+	p.mu.Lock()
+	p.codes[code] = authorization{challenge: query.Get("code_challenge"), claims: idClaims}
+	p.mu.Unlock()
+	return query.Get("state"), code
+}
+
+func (p *Provider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                p.URL,
+		"authorization_endpoint":                p.URL + "/authorize",
+		"token_endpoint":                        p.URL + "/token",
+		"jwks_uri":                              p.URL + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+	})
+}
+
+func (p *Provider) jwks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": keyID,
+			"alg": "RS256",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(p.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+		}},
+	})
+}
+
+func (p *Provider) token(w http.ResponseWriter, r *http.Request) {
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok {
+		clientID, clientSecret = r.PostFormValue("client_id"), r.PostFormValue("client_secret")
+	}
+	if clientID != p.ClientID || clientSecret != p.ClientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	// Codes are single use, whether or not the exchange succeeds
+	p.mu.Lock()
+	auth, ok := p.codes[r.PostFormValue("code")]
+	delete(p.codes, r.PostFormValue("code"))
+	p.mu.Unlock()
+
+	verifierHash := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if r.PostFormValue("grant_type") != "authorization_code" || !ok ||
+		base64.RawURLEncoding.EncodeToString(verifierHash[:]) != auth.challenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, auth.claims)
+	idToken.Header["kid"] = keyID
+	signed, err := idToken.SignedString(p.key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     signed,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func randomString() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
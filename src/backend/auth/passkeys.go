@@ -0,0 +1,301 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Eduardo-Barreto/web-ponderada/backend/config"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/models"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/repository"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+var (
+	ErrInvalidPasskeyCeremony = errors.New("passkey request is invalid or has expired, please try again")
+	ErrPasskeyRejected        = errors.New("passkey could not be verified")
+)
+
+// passkeyUser adapts a user and their stored credentials to webauthn.User
+type passkeyUser struct {
+	user        *models.User
+	credentials []models.WebAuthnCredential
+}
+
+// The user handle is the user's ID. It is opaque to authenticators, carries
+// no personal data and never changes, unlike the email.
+func passkeyUserHandle(userID int) []byte {
+	return []byte(strconv.Itoa(userID))
+}
+
+func (u *passkeyUser) WebAuthnID() []byte          { return passkeyUserHandle(u.user.ID) }
+func (u *passkeyUser) WebAuthnName() string        { return u.user.Email }
+func (u *passkeyUser) WebAuthnDisplayName() string { return u.user.Name }
+
+func (u *passkeyUser) WebAuthnCredentials() []webauthn.Credential {
+	credentials := make([]webauthn.Credential, len(u.credentials))
+	for i, stored := range u.credentials {
+		transports := make([]protocol.AuthenticatorTransport, len(stored.Transports))
+		for j, transport := range stored.Transports {
+			transports[j] = protocol.AuthenticatorTransport(transport)
+		}
+		credentials[i] = webauthn.Credential{
+			ID:              stored.CredentialID,
+			PublicKey:       stored.PublicKey,
+			AttestationType: stored.AttestationType,
+			Transport:       transports,
+			Flags: webauthn.CredentialFlags{
+				BackupEligible: stored.BackupEligible,
+				BackupState:    stored.BackupState,
+			},
+			Authenticator: webauthn.Authenticator{
+				AAGUID:    stored.AAGUID,
+				SignCount: stored.SignCount,
+			},
+		}
+	}
+	return credentials
+}
+
+// credential returns the stored credential with the given credential ID
+func (u *passkeyUser) credential(id []byte) *models.WebAuthnCredential {
+	for i := range u.credentials {
+		if bytes.Equal(u.credentials[i].CredentialID, id) {
+			return &u.credentials[i]
+		}
+	}
+	return nil
+}
+
+// PasskeyService runs WebAuthn registration and login ceremonies. Between
+// the begin and finish calls the challenge is kept in the database under a
+// random token handed to the client, so any instance can finish a ceremony
+// and each challenge is answered at most once.
+//
+// Finish methods take the browser's PublicKeyCredential as raw JSON, so they
+// can be driven by a software authenticator as well as a real browser.
+type PasskeyService struct {
+	UserRepo     repository.UserRepository
+	WebAuthnRepo repository.WebAuthnRepository
+	webAuthn     *webauthn.WebAuthn
+}
+
+// NewPasskeyService configures the relying party from config.AppConfig
+func NewPasskeyService(userRepo repository.UserRepository, webAuthnRepo repository.WebAuthnRepository) (*PasskeyService, error) {
+	timeout := webauthn.TimeoutConfig{Enforce: true, Timeout: config.AppConfig.WebAuthnCeremonyTTL}
+	w, err := webauthn.New(&webauthn.Config{
+		RPID:          config.AppConfig.WebAuthnRPID,
+		RPDisplayName: config.AppConfig.WebAuthnRPName,
+		RPOrigins:     config.AppConfig.WebAuthnOrigins,
+		// Passkeys stand in for the password, so the authenticator must
+		// verify the user (PIN or biometrics) and be usable without a username
+		AuthenticatorSelection: protocol.AuthenticatorSelection{
+			ResidentKey:      protocol.ResidentKeyRequirementRequired,
+			UserVerification: protocol.VerificationRequired,
+		},
+		AttestationPreference: protocol.PreferNoAttestation,
+		Timeouts:              webauthn.TimeoutsConfig{Login: timeout, Registration: timeout},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid webauthn configuration: %w", err)
+	}
+	return &PasskeyService{UserRepo: userRepo, WebAuthnRepo: webAuthnRepo, webAuthn: w}, nil
+}
+
+// BeginRegistration returns the options for navigator.credentials.create()
+// and the ceremony token to send back with the new credential
+func (s *PasskeyService) BeginRegistration(ctx context.Context, user *models.User) (*protocol.CredentialCreation, string, error) {
+	owner, err := s.loadUser(ctx, user)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Don't let the same authenticator register twice
+	exclusions := make([]protocol.CredentialDescriptor, 0, len(owner.credentials))
+	for _, credential := range owner.WebAuthnCredentials() {
+		exclusions = append(exclusions, credential.Descriptor())
+	}
+
+	options, session, err := s.webAuthn.BeginRegistration(owner, webauthn.WithExclusions(exclusions))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to begin passkey registration: %w", err)
+	}
+
+	token, err := s.saveCeremony(ctx, models.WebAuthnCeremonyRegistration, &user.ID, session)
+	if err != nil {
+		return nil, "", err
+	}
+	return options, token, nil
+}
+
+// FinishRegistration verifies the response to a registration challenge and
+// stores the new passkey under the given name
+func (s *PasskeyService) FinishRegistration(ctx context.Context, user *models.User, ceremonyToken, name string, response []byte) (*models.WebAuthnCredential, error) {
+	ceremony, session, err := s.consumeCeremony(ctx, models.WebAuthnCeremonyRegistration, ceremonyToken)
+	if err != nil {
+		return nil, err
+	}
+	if ceremony.UserID == nil || *ceremony.UserID != user.ID {
+		return nil, ErrInvalidPasskeyCeremony
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBytes(response)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrPasskeyRejected, err)
+	}
+
+	owner, err := s.loadUser(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	created, err := s.webAuthn.CreateCredential(owner, *session, parsed)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrPasskeyRejected, err)
+	}
+
+	transports := make([]string, len(created.Transport))
+	for i, transport := range created.Transport {
+		transports[i] = string(transport)
+	}
+	credential := &models.WebAuthnCredential{
+		UserID:          user.ID,
+		Name:            name,
+		CredentialID:    created.ID,
+		PublicKey:       created.PublicKey,
+		AttestationType: created.AttestationType,
+		AAGUID:          created.Authenticator.AAGUID,
+		SignCount:       created.Authenticator.SignCount,
+		Transports:      transports,
+		BackupEligible:  created.Flags.BackupEligible,
+		BackupState:     created.Flags.BackupState,
+	}
+	if _, err := s.WebAuthnRepo.CreateCredential(ctx, credential); err != nil {
+		return nil, err
+	}
+	return credential, nil
+}
+
+// BeginLogin returns the options for navigator.credentials.get(). No user is
+// named: the browser offers whichever passkeys it has for this site.
+func (s *PasskeyService) BeginLogin(ctx context.Context) (*protocol.CredentialAssertion, string, error) {
+	options, session, err := s.webAuthn.BeginDiscoverableLogin()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to begin passkey login: %w", err)
+	}
+
+	token, err := s.saveCeremony(ctx, models.WebAuthnCeremonyLogin, nil, session)
+	if err != nil {
+		return nil, "", err
+	}
+	return options, token, nil
+}
+
+// FinishLogin verifies the response to a login challenge and returns the
+// user the passkey belongs to
+func (s *PasskeyService) FinishLogin(ctx context.Context, ceremonyToken string, response []byte) (*models.User, error) {
+	_, session, err := s.consumeCeremony(ctx, models.WebAuthnCeremonyLogin, ceremonyToken)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(response)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrPasskeyRejected, err)
+	}
+
+	var owner *passkeyUser
+	var lookupErr error // Database failures, as opposed to unknown users
+	findOwner := func(rawID, userHandle []byte) (webauthn.User, error) {
+		userID, err := strconv.Atoi(string(userHandle))
+		if err != nil {
+			return nil, errors.New("unknown user handle")
+		}
+		user, err := s.UserRepo.GetUserByID(ctx, userID)
+		if err != nil {
+			if err.Error() != "user not found" {
+				lookupErr = err
+			}
+			return nil, err
+		}
+		if owner, err = s.loadUser(ctx, user); err != nil {
+			lookupErr = err
+			return nil, err
+		}
+		return owner, nil
+	}
+
+	verified, err := s.webAuthn.ValidateDiscoverableLogin(findOwner, *session, parsed)
+	if lookupErr != nil {
+		return nil, lookupErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrPasskeyRejected, err)
+	}
+
+	stored := owner.credential(verified.ID)
+	if stored == nil {
+		return nil, ErrPasskeyRejected
+	}
+	// A counter that did not increase means the key may have been copied
+	if verified.Authenticator.CloneWarning {
+		return nil, fmt.Errorf("%w: signature counter went backwards for credential %d", ErrPasskeyRejected, stored.ID)
+	}
+	if err := s.WebAuthnRepo.UpdateCredentialUsage(ctx, stored.ID, verified.Authenticator.SignCount, verified.Flags.BackupState, time.Now()); err != nil {
+		return nil, err
+	}
+
+	return owner.user, nil
+}
+
+func (s *PasskeyService) loadUser(ctx context.Context, user *models.User) (*passkeyUser, error) {
+	credentials, err := s.WebAuthnRepo.GetCredentialsByUser(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &passkeyUser{user: user, credentials: credentials}, nil
+}
+
+func (s *PasskeyService) saveCeremony(ctx context.Context, kind string, userID *int, session *webauthn.SessionData) (string, error) {
+	sessionData, err := json.Marshal(session)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode webauthn session: %w", err)
+	}
+
+	token, err := GenerateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = s.WebAuthnRepo.CreateCeremony(ctx, &models.WebAuthnCeremony{
+		TokenHash:   HashToken(token),
+		UserID:      userID,
+		Kind:        kind,
+		SessionData: sessionData,
+		ExpiresAt:   time.Now().Add(config.AppConfig.WebAuthnCeremonyTTL),
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func (s *PasskeyService) consumeCeremony(ctx context.Context, kind, token string) (*models.WebAuthnCeremony, *webauthn.SessionData, error) {
+	ceremony, err := s.WebAuthnRepo.ConsumeCeremony(ctx, kind, HashToken(token))
+	if err != nil {
+		if err.Error() == "ceremony invalid or expired" {
+			return nil, nil, ErrInvalidPasskeyCeremony
+		}
+		return nil, nil, err
+	}
+
+	session := &webauthn.SessionData{}
+	if err := json.Unmarshal(ceremony.SessionData, session); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode webauthn session: %w", err)
+	}
+	return ceremony, session, nil
+}
@@ -0,0 +1,365 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Eduardo-Barreto/web-ponderada/backend/config"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/models"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/repository"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+)
+
+const testOrigin = "http://localhost:3000"
+
+// fakeUsers returns users by ID; other methods panic
+type fakeUsers struct {
+	repository.UserRepository
+	users map[int]*models.User
+}
+
+func (r fakeUsers) GetUserByID(ctx context.Context, id int) (*models.User, error) {
+	user, ok := r.users[id]
+	if !ok {
+		return nil, errors.New("user not found")
+	}
+	return user, nil
+}
+
+// fakeWebAuthnRepo keeps credentials and ceremonies in memory
+type fakeWebAuthnRepo struct {
+	credentials []models.WebAuthnCredential
+	ceremonies  map[string]models.WebAuthnCeremony
+}
+
+func (r *fakeWebAuthnRepo) CreateCredential(ctx context.Context, credential *models.WebAuthnCredential) (int, error) {
+	credential.ID = len(r.credentials) + 1
+	r.credentials = append(r.credentials, *credential)
+	return credential.ID, nil
+}
+
+func (r *fakeWebAuthnRepo) GetCredentialsByUser(ctx context.Context, userID int) ([]models.WebAuthnCredential, error) {
+	var found []models.WebAuthnCredential
+	for _, credential := range r.credentials {
+		if credential.UserID == userID {
+			found = append(found, credential)
+		}
+	}
+	return found, nil
+}
+
+func (r *fakeWebAuthnRepo) UpdateCredentialUsage(ctx context.Context, id int, signCount uint32, backupState bool, usedAt time.Time) error {
+	credential := &r.credentials[id-1]
+	credential.SignCount, credential.BackupState, credential.LastUsedAt = signCount, backupState, &usedAt
+	return nil
+}
+
+func (r *fakeWebAuthnRepo) DeleteCredential(ctx context.Context, userID, id int) error {
+	panic("not used")
+}
+
+func (r *fakeWebAuthnRepo) CreateCeremony(ctx context.Context, ceremony *models.WebAuthnCeremony) error {
+	if r.ceremonies == nil {
+		r.ceremonies = map[string]models.WebAuthnCeremony{}
+	}
+	r.ceremonies[ceremony.TokenHash] = *ceremony
+	return nil
+}
+
+func (r *fakeWebAuthnRepo) ConsumeCeremony(ctx context.Context, kind, tokenHash string) (*models.WebAuthnCeremony, error) {
+	ceremony, ok := r.ceremonies[tokenHash]
+	delete(r.ceremonies, tokenHash)
+	if !ok || ceremony.Kind != kind || time.Now().After(ceremony.ExpiresAt) {
+		return nil, errors.New("ceremony invalid or expired")
+	}
+	return &ceremony, nil
+}
+
+// virtualAuthenticator is a software passkey: one P-256 key that answers
+// registration and login challenges like a browser would, with "none"
+// attestation and the user present and verified
+type virtualAuthenticator struct {
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	userHandle   []byte // Remembered from registration, as for a discoverable credential
+	signCount    uint32
+}
+
+func newVirtualAuthenticator(t *testing.T) *virtualAuthenticator {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credentialID := make([]byte, 32)
+	rand.Read(credentialID)
+	return &virtualAuthenticator{key: key, credentialID: credentialID}
+}
+
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttested     = 0x40
+)
+
+func (a *virtualAuthenticator) authData(flags byte, attested []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(config.AppConfig.WebAuthnRPID))
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+	return append(data, attested...)
+}
+
+func clientData(t *testing.T, ceremonyType string, challenge protocol.URLEncodedBase64) []byte {
+	t.Helper()
+	data, err := json.Marshal(map[string]string{
+		"type":      ceremonyType,
+		"challenge": challenge.String(),
+		"origin":    testOrigin,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// create answers navigator.credentials.create()
+func (a *virtualAuthenticator) create(t *testing.T, options *protocol.CredentialCreation) []byte {
+	t.Helper()
+	a.userHandle = options.Response.User.ID.(protocol.URLEncodedBase64)
+
+	coseKey, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{
+			KeyType:   int64(webauthncose.EllipticKey),
+			Algorithm: int64(webauthncose.AlgES256),
+		},
+		Curve:  1, // P-256
+		XCoord: a.key.X.FillBytes(make([]byte, 32)),
+		YCoord: a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	attested := make([]byte, 16) // Zero AAGUID
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(a.credentialID)))
+	attested = append(append(attested, a.credentialID...), coseKey...)
+
+	attestation, err := webauthncbor.Marshal(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": a.authData(flagUserPresent|flagUserVerified|flagAttested, attested),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a.response(t, map[string]string{
+		"clientDataJSON":    b64(clientData(t, "webauthn.create", options.Response.Challenge)),
+		"attestationObject": b64(attestation),
+	})
+}
+
+// get answers navigator.credentials.get() with the given signature counter
+func (a *virtualAuthenticator) get(t *testing.T, options *protocol.CredentialAssertion, signCount uint32) []byte {
+	t.Helper()
+	a.signCount = signCount
+	authData := a.authData(flagUserPresent|flagUserVerified, nil)
+	client := clientData(t, "webauthn.get", options.Response.Challenge)
+
+	clientHash := sha256.Sum256(client)
+	digest := sha256.Sum256(append(authData, clientHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a.response(t, map[string]string{
+		"clientDataJSON":    b64(client),
+		"authenticatorData": b64(authData),
+		"signature":         b64(signature),
+		"userHandle":        b64(a.userHandle),
+	})
+}
+
+func (a *virtualAuthenticator) response(t *testing.T, response map[string]string) []byte {
+	t.Helper()
+	data, err := json.Marshal(map[string]interface{}{
+		"id":       b64(a.credentialID),
+		"rawId":    b64(a.credentialID),
+		"type":     "public-key",
+		"response": response,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func newTestPasskeyService(t *testing.T, users ...*models.User) (*PasskeyService, *fakeWebAuthnRepo) {
+	t.Helper()
+	config.AppConfig = &config.Config{
+		WebAuthnRPID:        "localhost",
+		WebAuthnRPName:      "Web Ponderada",
+		WebAuthnOrigins:     []string{testOrigin},
+		WebAuthnCeremonyTTL: time.Minute,
+	}
+	byID := map[int]*models.User{}
+	for _, user := range users {
+		byID[user.ID] = user
+	}
+	repo := &fakeWebAuthnRepo{}
+	service, err := NewPasskeyService(fakeUsers{users: byID}, repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return service, repo
+}
+
+// register runs a whole registration ceremony for the user
+func register(t *testing.T, service *PasskeyService, user *models.User, authenticator *virtualAuthenticator) *models.WebAuthnCredential {
+	t.Helper()
+	ctx := context.Background()
+	options, token, err := service.BeginRegistration(ctx, user)
+	if err != nil {
+		t.Fatalf("BeginRegistration: %v", err)
+	}
+	credential, err := service.FinishRegistration(ctx, user, token, "Laptop", authenticator.create(t, options))
+	if err != nil {
+		t.Fatalf("FinishRegistration: %v", err)
+	}
+	return credential
+}
+
+// login runs a whole login ceremony, answered with the given signature counter
+func login(t *testing.T, service *PasskeyService, authenticator *virtualAuthenticator, signCount uint32) (*models.User, error) {
+	t.Helper()
+	ctx := context.Background()
+	options, token, err := service.BeginLogin(ctx)
+	if err != nil {
+		t.Fatalf("BeginLogin: %v", err)
+	}
+	return service.FinishLogin(ctx, token, authenticator.get(t, options, signCount))
+}
+
+func TestPasskeyRegistrationAndLogin(t *testing.T) {
+	maria := &models.User{ID: 2, Name: "Maria", Email: "maria@example.com"}
+	service, repo := newTestPasskeyService(t, maria)
+	authenticator := newVirtualAuthenticator(t)
+
+	credential := register(t, service, maria, authenticator)
+	if credential.UserID != maria.ID || credential.Name != "Laptop" || credential.AttestationType != "none" {
+		t.Errorf("stored credential %+v", credential)
+	}
+	if string(authenticator.userHandle) != "2" {
+		t.Errorf("user handle = %q, want the user ID", authenticator.userHandle)
+	}
+
+	user, err := login(t, service, authenticator, 1)
+	if err != nil {
+		t.Fatalf("FinishLogin: %v", err)
+	}
+	if user.ID != maria.ID {
+		t.Errorf("logged in as user %d, want %d", user.ID, maria.ID)
+	}
+	if stored := repo.credentials[0]; stored.SignCount != 1 || stored.LastUsedAt == nil {
+		t.Errorf("usage not recorded: sign count %d, last used %v", stored.SignCount, stored.LastUsedAt)
+	}
+
+	// The stored counter moved on, so the next login needs a higher one
+	if _, err := login(t, service, authenticator, 2); err != nil {
+		t.Errorf("login with an increased counter: %v", err)
+	}
+}
+
+func TestPasskeyLoginRejectsSignCountRegression(t *testing.T) {
+	maria := &models.User{ID: 2, Name: "Maria", Email: "maria@example.com"}
+	service, repo := newTestPasskeyService(t, maria)
+	authenticator := newVirtualAuthenticator(t)
+	register(t, service, maria, authenticator)
+
+	if _, err := login(t, service, authenticator, 5); err != nil {
+		t.Fatalf("FinishLogin: %v", err)
+	}
+	for _, signCount := range []uint32{5, 3} {
+		if _, err := login(t, service, authenticator, signCount); !errors.Is(err, ErrPasskeyRejected) {
+			t.Errorf("counter %d after 5: got error %v, want ErrPasskeyRejected", signCount, err)
+		}
+	}
+	if stored := repo.credentials[0]; stored.SignCount != 5 {
+		t.Errorf("sign count = %d after rejected logins, want 5", stored.SignCount)
+	}
+}
+
+func TestPasskeyLoginRejectsOtherUsersCredential(t *testing.T) {
+	maria := &models.User{ID: 2, Name: "Maria", Email: "maria@example.com"}
+	joao := &models.User{ID: 3, Name: "João", Email: "joao@example.com"}
+	service, _ := newTestPasskeyService(t, maria, joao)
+	marias := newVirtualAuthenticator(t)
+	register(t, service, maria, marias)
+	register(t, service, joao, newVirtualAuthenticator(t))
+
+	// Maria's credential presented under João's user handle
+	marias.userHandle = passkeyUserHandle(joao.ID)
+	if user, err := login(t, service, marias, 1); !errors.Is(err, ErrPasskeyRejected) {
+		t.Errorf("got user %v and error %v, want ErrPasskeyRejected", user, err)
+	}
+
+	// And under a user that does not exist
+	marias.userHandle = passkeyUserHandle(99)
+	if _, err := login(t, service, marias, 1); !errors.Is(err, ErrPasskeyRejected) {
+		t.Errorf("unknown user handle: got error %v, want ErrPasskeyRejected", err)
+	}
+}
+
+func TestPasskeyCeremonies(t *testing.T) {
+	maria := &models.User{ID: 2, Name: "Maria", Email: "maria@example.com"}
+	joao := &models.User{ID: 3, Name: "João", Email: "joao@example.com"}
+	service, repo := newTestPasskeyService(t, maria, joao)
+	ctx := context.Background()
+	authenticator := newVirtualAuthenticator(t)
+
+	options, token, err := service.BeginRegistration(ctx, maria)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := authenticator.create(t, options)
+	if _, err := service.FinishRegistration(ctx, joao, token, "Laptop", response); !errors.Is(err, ErrInvalidPasskeyCeremony) {
+		t.Errorf("finished by another user: got error %v, want ErrInvalidPasskeyCeremony", err)
+	}
+	if _, err := service.FinishRegistration(ctx, maria, token, "Laptop", response); !errors.Is(err, ErrInvalidPasskeyCeremony) {
+		t.Errorf("ceremony reused: got error %v, want ErrInvalidPasskeyCeremony", err)
+	}
+	if len(repo.credentials) != 0 {
+		t.Errorf("stored %d credentials, want none", len(repo.credentials))
+	}
+
+	// Answering a different challenge than the one issued
+	_, token, err = service.BeginRegistration(ctx, maria)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.FinishRegistration(ctx, maria, token, "Laptop", response); !errors.Is(err, ErrPasskeyRejected) {
+		t.Errorf("stale challenge: got error %v, want ErrPasskeyRejected", err)
+	}
+
+	// A login ceremony cannot finish a registration
+	_, token, err = service.BeginLogin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.FinishRegistration(ctx, maria, token, "Laptop", response); !errors.Is(err, ErrInvalidPasskeyCeremony) {
+		t.Errorf("login ceremony: got error %v, want ErrInvalidPasskeyCeremony", err)
+	}
+}
@@ -0,0 +1,111 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Eduardo-Barreto/web-ponderada/backend/config"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Password hashes are stored in the PHC string format, which records the
+// algorithm, its version and its parameters next to the salt and hash:
+//
+//	$argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>
+//
+// Hashes created before argon2id was introduced are plain bcrypt ($2a$...).
+// Both verify; NeedsRehash tells Login when to upgrade one.
+const argon2idPrefix = "$argon2id$"
+
+const (
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+var errInvalidArgon2Hash = errors.New("invalid argon2id hash")
+
+type argon2Params struct {
+	memory      uint32 // KiB
+	iterations  uint32
+	parallelism uint8
+	keyLength   uint32
+}
+
+func currentArgon2Params() argon2Params {
+	return argon2Params{
+		memory:      uint32(config.AppConfig.Argon2Memory),
+		iterations:  uint32(config.AppConfig.Argon2Iterations),
+		parallelism: uint8(config.AppConfig.Argon2Parallelism),
+		keyLength:   argon2KeyLength,
+	}
+}
+
+// HashPassword generates an argon2id hash of the password with the configured parameters
+func HashPassword(password string) (string, error) {
+	params := currentArgon2Params()
+
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, params.iterations, params.memory, params.parallelism, params.keyLength)
+
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2idPrefix, argon2.Version,
+		params.memory, params.iterations, params.parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// CheckPasswordHash compares a plain password with an argon2id or bcrypt hash
+func CheckPasswordHash(password, hash string) bool {
+	if !strings.HasPrefix(hash, argon2idPrefix) {
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	}
+
+	params, salt, key, err := decodeArgon2Hash(hash)
+	if err != nil {
+		return false
+	}
+	candidate := argon2.IDKey([]byte(password), salt, params.iterations, params.memory, params.parallelism, params.keyLength)
+	return subtle.ConstantTimeCompare(key, candidate) == 1
+}
+
+// NeedsRehash reports whether a stored hash uses bcrypt or argon2id
+// parameters other than the configured ones
+func NeedsRehash(hash string) bool {
+	if !strings.HasPrefix(hash, argon2idPrefix) {
+		return true
+	}
+	params, _, _, err := decodeArgon2Hash(hash)
+	return err != nil || params != currentArgon2Params()
+}
+
+func decodeArgon2Hash(hash string) (params argon2Params, salt, key []byte, err error) {
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", salt, hash
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return params, nil, nil, errInvalidArgon2Hash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, errInvalidArgon2Hash
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.iterations, &params.parallelism); err != nil {
+		return params, nil, nil, errInvalidArgon2Hash
+	}
+
+	if salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return params, nil, nil, errInvalidArgon2Hash
+	}
+	if key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return params, nil, nil, errInvalidArgon2Hash
+	}
+	params.keyLength = uint32(len(key))
+
+	return params, salt, key, nil
+}
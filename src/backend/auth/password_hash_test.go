@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Eduardo-Barreto/web-ponderada/backend/config"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Small parameters keep argon2id fast in tests
+func setArgon2Config(memory, iterations, parallelism int) {
+	config.AppConfig = &config.Config{
+		Argon2Memory:      memory,
+		Argon2Iterations:  iterations,
+		Argon2Parallelism: parallelism,
+	}
+}
+
+func TestHashPasswordRoundTrip(t *testing.T) {
+	setArgon2Config(64, 1, 1)
+	hash, err := HashPassword("Blue-Kettle-42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Errorf("hash %q does not record the configured parameters", hash)
+	}
+	if !CheckPasswordHash("Blue-Kettle-42", hash) {
+		t.Error("the hashed password does not verify")
+	}
+	if CheckPasswordHash("Blue-Kettle-43", hash) {
+		t.Error("a different password verifies")
+	}
+	if NeedsRehash(hash) {
+		t.Error("a fresh hash needs a rehash")
+	}
+}
+
+func TestCheckPasswordHashBcrypt(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("Blue-Kettle-42"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !CheckPasswordHash("Blue-Kettle-42", string(hash)) {
+		t.Error("a legacy bcrypt hash does not verify")
+	}
+	if CheckPasswordHash("Blue-Kettle-43", string(hash)) {
+		t.Error("a different password verifies against bcrypt")
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	setArgon2Config(64, 1, 1)
+	// Salt and key are 16 and 32 zero bytes
+	const salt = "AAAAAAAAAAAAAAAAAAAAAA"
+	const key = "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"
+
+	tests := []struct {
+		name string
+		hash string
+		want bool
+	}{
+		{"current parameters", "$argon2id$v=19$m=64,t=1,p=1$" + salt + "$" + key, false},
+		{"bcrypt", "$2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy", true},
+		{"more memory configured", "$argon2id$v=19$m=32,t=1,p=1$" + salt + "$" + key, true},
+		{"more iterations configured", "$argon2id$v=19$m=64,t=0,p=1$" + salt + "$" + key, true},
+		{"other parallelism", "$argon2id$v=19$m=64,t=1,p=4$" + salt + "$" + key, true},
+		{"shorter key", "$argon2id$v=19$m=64,t=1,p=1$" + salt + "$AAAAAAAAAAAAAAAAAAAAAA", true},
+		{"older argon2 version", "$argon2id$v=16$m=64,t=1,p=1$" + salt + "$" + key, true},
+		{"missing field", "$argon2id$v=19$m=64,t=1,p=1$" + key, true},
+		{"garbled parameters", "$argon2id$v=19$m=64;t=1;p=1$" + salt + "$" + key, true},
+		{"salt not base64", "$argon2id$v=19$m=64,t=1,p=1$!!!$" + key, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NeedsRehash(tt.hash); got != tt.want {
+				t.Errorf("NeedsRehash(%q) = %v, want %v", tt.hash, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckPasswordHashRejectsMalformedArgon2(t *testing.T) {
+	for _, hash := range []string{
+		"$argon2id$",
+		"$argon2id$v=19$m=64,t=1,p=1$AAAA",
+		"$argon2id$v=19$m=64,t=1,p=1$AAAA$not base64",
+	} {
+		if CheckPasswordHash("", hash) {
+			t.Errorf("CheckPasswordHash accepted %q", hash)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/Eduardo-Barreto/web-ponderada/backend/config"
+)
+
+// Generous, but keeps hashing cost bounded
+const maxPasswordBytes = 1024
+
+// Parts of the user's name or email shorter than this are too common to reject
+const minPersonalInfoLength = 3
+
+// Character classes that PASSWORD_REQUIRED_CLASSES can ask for
+var passwordClasses = map[string]struct {
+	description string
+	matches     func(rune) bool
+}{
+	"lower":  {"a lowercase letter", unicode.IsLower},
+	"upper":  {"an uppercase letter", unicode.IsUpper},
+	"digit":  {"a digit", unicode.IsDigit},
+	"symbol": {"a symbol", func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.IsSpace(r) }},
+}
+
+// Violation codes returned in PasswordViolation.Code
+const (
+	PasswordTooShort     = "too_short"
+	PasswordTooLong      = "too_long"
+	PasswordMissingClass = "missing_class"
+	PasswordBreached     = "breached"
+	PasswordPersonalInfo = "personal_info"
+)
+
+// PasswordViolation is one rule a password failed
+type PasswordViolation struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// PasswordPolicyError lists every rule a password failed, so clients can
+// show them all at once
+type PasswordPolicyError struct {
+	Violations []PasswordViolation
+}
+
+func (e *PasswordPolicyError) Error() string {
+	messages := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		messages[i] = v.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Digests in the Pwned Passwords range layout are split after this many hex digits
+const breachedRangePrefixLength = 5
+
+// breachedPasswordList tells whether the SHA-1 digest of a password is known
+// to have appeared in a data breach
+type breachedPasswordList interface {
+	contains(sum [sha1.Size]byte) (bool, error)
+	describe() string
+}
+
+// Loaded from PASSWORD_BREACHED_LIST_FILE; nil when not configured
+var breachedPasswords breachedPasswordList
+
+// breachedPasswordSet is a list small enough to keep in memory
+type breachedPasswordSet map[[sha1.Size]byte]struct{}
+
+func (s breachedPasswordSet) contains(sum [sha1.Size]byte) (bool, error) {
+	_, found := s[sum]
+	return found, nil
+}
+
+func (s breachedPasswordSet) describe() string {
+	return fmt.Sprintf("%d known breached passwords", len(s))
+}
+
+// breachedPasswordRanges is a directory in the layout of the Pwned Passwords
+// range API: one file per 5-digit hex prefix, named "ABCDE" or "ABCDE.txt",
+// with a "SUFFIX:count" line for each digest starting with it. Only the file
+// a password falls in is read, so the full corpus never needs to fit in memory.
+type breachedPasswordRanges string
+
+func (dir breachedPasswordRanges) contains(sum [sha1.Size]byte) (bool, error) {
+	digest := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := digest[:breachedRangePrefixLength], digest[breachedRangePrefixLength:]
+
+	file, err := os.Open(filepath.Join(string(dir), prefix))
+	if os.IsNotExist(err) {
+		file, err = os.Open(filepath.Join(string(dir), prefix+".txt"))
+	}
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil // No breached password has this prefix
+		}
+		return false, fmt.Errorf("failed to open breached password range %s: %w", prefix, err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lineSuffix, _, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if strings.EqualFold(lineSuffix, suffix) {
+			return true, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return false, fmt.Errorf("failed to read breached password range %s: %w", prefix, err)
+	}
+	return false, nil
+}
+
+func (dir breachedPasswordRanges) describe() string {
+	return "breached passwords listed in the range files under " + string(dir)
+}
+
+// loadPasswordPolicy checks the configured classes and opens the breached
+// password list, if any
+func loadPasswordPolicy() error {
+	for _, class := range config.AppConfig.PasswordRequiredClasses {
+		if _, ok := passwordClasses[class]; !ok {
+			return fmt.Errorf("unknown password character class %q (use lower, upper, digit or symbol)", class)
+		}
+	}
+
+	breachedPasswords = nil
+	if path := config.AppConfig.PasswordBreachedListFile; path != "" {
+		list, err := openBreachedPasswords(path)
+		if err != nil {
+			return err
+		}
+		breachedPasswords = list
+	}
+	return nil
+}
+
+// openBreachedPasswords uses a directory as range files (see
+// breachedPasswordRanges) and loads a single file into memory
+func openBreachedPasswords(path string) (breachedPasswordList, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open breached password list: %w", err)
+	}
+	if info.IsDir() {
+		return breachedPasswordRanges(path), nil
+	}
+	return loadBreachedPasswords(path)
+}
+
+// loadBreachedPasswords reads a list of uppercase or lowercase hex SHA-1
+// digests, one per line, optionally followed by ":<count>" as in the
+// Pwned Passwords downloads. Blank lines and lines starting with # are ignored.
+func loadBreachedPasswords(path string) (breachedPasswordSet, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open breached password list: %w", err)
+	}
+	defer file.Close()
+
+	list := make(breachedPasswordSet)
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		digest, _, _ := strings.Cut(line, ":")
+
+		var sum [sha1.Size]byte
+		if len(digest) != hex.EncodedLen(sha1.Size) {
+			return nil, fmt.Errorf("invalid SHA-1 digest on line %d of %s (a directory is needed for range files)", lineNo, path)
+		}
+		if _, err := hex.Decode(sum[:], []byte(digest)); err != nil {
+			return nil, fmt.Errorf("invalid SHA-1 digest on line %d of %s", lineNo, path)
+		}
+		list[sum] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read breached password list: %w", err)
+	}
+	return list, nil
+}
+
+// ValidatePasswordPolicy checks a new password against the configured policy.
+// name and email are the account's, which the password must not contain.
+// Failures are returned as a *PasswordPolicyError.
+func ValidatePasswordPolicy(password, name, email string) error {
+	var violations []PasswordViolation
+
+	if minLength := config.AppConfig.PasswordMinLength; utf8.RuneCountInString(password) < minLength {
+		violations = append(violations, PasswordViolation{PasswordTooShort, fmt.Sprintf("Password must be at least %d characters long", minLength)})
+	}
+	if len(password) > maxPasswordBytes {
+		violations = append(violations, PasswordViolation{PasswordTooLong, fmt.Sprintf("Password must be at most %d bytes long", maxPasswordBytes)})
+	}
+
+	for _, className := range config.AppConfig.PasswordRequiredClasses {
+		class := passwordClasses[className]
+		if !strings.ContainsFunc(password, class.matches) {
+			violations = append(violations, PasswordViolation{PasswordMissingClass, "Password must contain " + class.description})
+		}
+	}
+
+	if containsPersonalInfo(password, name, email) {
+		violations = append(violations, PasswordViolation{PasswordPersonalInfo, "Password must not contain your name or email"})
+	}
+
+	if breachedPasswords != nil {
+		// An unreadable list should not stop everyone from setting a password
+		if breached, err := breachedPasswords.contains(sha1.Sum([]byte(password))); err != nil {
+			log.Printf("Warning: Failed to check password against the breached password list: %v", err)
+		} else if breached {
+			violations = append(violations, PasswordViolation{PasswordBreached, "This password has appeared in a data breach, choose another one"})
+		}
+	}
+
+	if len(violations) > 0 {
+		return &PasswordPolicyError{Violations: violations}
+	}
+	return nil
+}
+
+// containsPersonalInfo reports whether the password contains, ignoring case,
+// any word of the name or the email's local part
+func containsPersonalInfo(password, name, email string) bool {
+	password = strings.ToLower(password)
+
+	parts := strings.Fields(strings.ToLower(name))
+	localPart, _, _ := strings.Cut(strings.ToLower(email), "@")
+	parts = append(parts, localPart)
+	// "john.doe" should also catch "doe"
+	parts = append(parts, strings.FieldsFunc(localPart, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) })...)
+
+	for _, part := range parts {
+		if utf8.RuneCountInString(part) >= minPersonalInfoLength && strings.Contains(password, part) {
+			return true
+		}
+	}
+	return false
+}
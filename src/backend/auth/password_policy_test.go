@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"crypto/sha1"
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/Eduardo-Barreto/web-ponderada/backend/config"
+)
+
+func TestBreachedPasswordLists(t *testing.T) {
+	lists := []string{
+		filepath.Join("testdata", "breached.txt"),    // Full digests, loaded into memory
+		filepath.Join("testdata", "breached-ranges"), // One file per prefix, read per check
+	}
+	tests := []struct {
+		password     string
+		wantBreached bool
+	}{
+		{"Tr0ub4dor&3", true},                   // In 87457.txt, CRLF line endings
+		{"Summer2024!", true},                   // In 7E8B0 (no extension), lowercase suffix
+		{"correct horse battery staple", false}, // ABF7A.txt only holds a near miss
+		{"an unlisted passphrase", false},       // No file for its prefix
+	}
+
+	for _, path := range lists {
+		list, err := openBreachedPasswords(path)
+		if err != nil {
+			t.Fatalf("openBreachedPasswords(%s): %v", path, err)
+		}
+		for _, tt := range tests {
+			breached, err := list.contains(sha1.Sum([]byte(tt.password)))
+			if err != nil {
+				t.Errorf("%s: contains(%q): %v", path, tt.password, err)
+			} else if breached != tt.wantBreached {
+				t.Errorf("%s: contains(%q) = %v, want %v", path, tt.password, breached, tt.wantBreached)
+			}
+		}
+	}
+}
+
+func TestLoadBreachedPasswordsRejectsRangeFile(t *testing.T) {
+	// A single range file holds 35-digit suffixes, which are not full digests
+	if _, err := openBreachedPasswords(filepath.Join("testdata", "breached-ranges", "87457.txt")); err == nil {
+		t.Error("expected an error for a range file given as the whole list")
+	}
+	if _, err := openBreachedPasswords(filepath.Join("testdata", "missing")); err == nil {
+		t.Error("expected an error for a missing list")
+	}
+}
+
+func TestValidatePasswordPolicy(t *testing.T) {
+	config.AppConfig = &config.Config{
+		PasswordMinLength:        10,
+		PasswordRequiredClasses:  []string{"lower", "upper", "digit"},
+		PasswordBreachedListFile: filepath.Join("testdata", "breached-ranges"),
+	}
+	if err := loadPasswordPolicy(); err != nil {
+		t.Fatalf("loadPasswordPolicy: %v", err)
+	}
+	t.Cleanup(func() { breachedPasswords = nil })
+
+	tests := []struct {
+		name      string
+		password  string
+		wantCodes []string
+	}{
+		{"valid", "Blue-Kettle-42", nil},
+		{"too short", "Ab1", []string{PasswordTooShort}},
+		{"too long", "Aa1" + string(make([]byte, maxPasswordBytes)), []string{PasswordTooLong}},
+		{"missing classes", "lowercase only here", []string{PasswordMissingClass, PasswordMissingClass}},
+		{"contains name", "MariaSilva2024", []string{PasswordPersonalInfo}},
+		{"contains email local part", "Xsouza-99-Zeta", []string{PasswordPersonalInfo}},
+		{"breached", "Summer2024!", []string{PasswordBreached}},
+		{"breached, CRLF range file", "Tr0ub4dor&3", []string{PasswordBreached}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidatePasswordPolicy(tt.password, "Maria Silva", "m.souza@example.com")
+
+			var codes []string
+			var policyErr *PasswordPolicyError
+			if errors.As(err, &policyErr) {
+				for _, v := range policyErr.Violations {
+					codes = append(codes, v.Code)
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error type %T: %v", err, err)
+			}
+			if !slices.Equal(codes, tt.wantCodes) {
+				t.Errorf("got violations %v, want %v", codes, tt.wantCodes)
+			}
+		})
+	}
+}
+
+func TestLoadPasswordPolicyRejectsUnknownClass(t *testing.T) {
+	config.AppConfig = &config.Config{PasswordRequiredClasses: []string{"lower", "emoji"}}
+	if err := loadPasswordPolicy(); err == nil {
+		t.Error("expected an error for an unknown character class")
+	}
+}
@@ -0,0 +1,47 @@
+package auth
+
+import "github.com/Eduardo-Barreto/web-ponderada/backend/models"
+
+// Permissions that can be required by routes. API keys are granted a subset
+// of these as scopes.
+const (
+	PermProductsRead  = "products:read"
+	PermProductsWrite = "products:write"
+	PermUsersRead     = "users:read"
+	PermUsersManage   = "users:manage"
+)
+
+// rolePermissions maps each role to the permissions it grants
+var rolePermissions = map[string][]string{
+	models.RoleUser:   {PermProductsRead, PermUsersRead},
+	models.RoleEditor: {PermProductsRead, PermUsersRead, PermProductsWrite},
+	models.RoleAdmin:  {PermProductsRead, PermUsersRead, PermProductsWrite, PermUsersManage},
+}
+
+// apiKeyScopes lists the permissions that may be granted to an API key.
+// Managing users stays with humans.
+var apiKeyScopes = []string{PermProductsRead, PermProductsWrite, PermUsersRead}
+
+// RoleHasPermission reports whether the role grants the permission
+func RoleHasPermission(role, permission string) bool {
+	return contains(rolePermissions[role], permission)
+}
+
+// IsValidAPIKeyScope reports whether the scope can be granted to an API key
+func IsValidAPIKeyScope(scope string) bool {
+	return contains(apiKeyScopes, scope)
+}
+
+// APIKeyHasScope reports whether the key was granted the permission
+func APIKeyHasScope(key *models.APIKey, permission string) bool {
+	return contains(key.Scopes, permission)
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"errors"
+	"time"
+
+	"github.com/Eduardo-Barreto/web-ponderada/backend/models"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// PurposeClaims are carried by short, single-purpose signed tokens such as
+// email verification links or MFA challenges. The purpose is the JWT subject,
+// so one kind of token can never be accepted in place of another.
+type PurposeClaims struct {
+	UserID int    `json:"user_id"`
+	Email  string `json:"email"`
+	jwt.RegisteredClaims
+}
+
+func generatePurposeToken(subject string, user *models.User, ttl time.Duration) (string, error) {
+	claims := &PurposeClaims{
+		UserID: user.ID,
+		Email:  user.Email,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			Subject:   subject,
+			ID:        uuid.New().String(), // Lets single-use tokens be denylisted once used
+		},
+	}
+
+	return signToken(claims)
+}
+
+// parsePurposeToken returns errExpired or errInvalid on failure
+func parsePurposeToken(tokenStr, subject string, errExpired, errInvalid error) (*PurposeClaims, error) {
+	claims := &PurposeClaims{}
+
+	token, err := jwt.ParseWithClaims(tokenStr, claims, keyFunc, jwt.WithSubject(subject))
+	if err != nil {
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			return nil, errExpired
+		}
+		return nil, errInvalid
+	}
+	if !token.Valid {
+		return nil, errInvalid
+	}
+
+	return claims, nil
+}
@@ -0,0 +1,219 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/Eduardo-Barreto/web-ponderada/backend/config"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/models"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/repository"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenExpired = errors.New("refresh token has expired")
+	ErrRefreshTokenReused  = errors.New("refresh token reuse detected")
+)
+
+// TokenPair is what clients receive after a successful login or refresh
+type TokenPair struct {
+	AccessToken  string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"` // Access token lifetime in seconds
+}
+
+// ClientInfo describes the device a session was started from
+type ClientInfo struct {
+	UserAgent string
+	IP        string
+}
+
+// Longer user agents are cut to fit the sessions table
+const maxUserAgentLength = 512
+
+// TokenIssuer issues access/refresh token pairs and rotates refresh tokens
+type TokenIssuer struct {
+	UserRepo    repository.UserRepository
+	RefreshRepo repository.RefreshTokenRepository
+	SessionRepo repository.SessionRepository
+	Revocations *RevocationStore
+}
+
+func NewTokenIssuer(userRepo repository.UserRepository, refreshRepo repository.RefreshTokenRepository, sessionRepo repository.SessionRepository, revocations *RevocationStore) *TokenIssuer {
+	return &TokenIssuer{UserRepo: userRepo, RefreshRepo: refreshRepo, SessionRepo: sessionRepo, Revocations: revocations}
+}
+
+// Issue starts a new session for a user and creates its first token pair.
+// The session ID is also the refresh token family ID. Every kind of login
+// ends here, so this is also where logging in cancels a scheduled closure.
+func (i *TokenIssuer) Issue(ctx context.Context, user *models.User, client ClientInfo) (*TokenPair, error) {
+	if user.DeletionScheduledAt != nil {
+		cancelled, err := i.UserRepo.CancelUserDeletion(ctx, user.ID)
+		if err != nil {
+			return nil, err
+		}
+		if cancelled {
+			log.Printf("Scheduled deletion of user %d cancelled by login", user.ID)
+		}
+		user.DeletionScheduledAt = nil
+	}
+
+	userAgent := client.UserAgent
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+
+	session := &models.Session{
+		ID:        uuid.New().String(),
+		UserID:    user.ID,
+		UserAgent: userAgent,
+		IP:        client.IP,
+	}
+	if err := i.SessionRepo.CreateSession(ctx, session); err != nil {
+		return nil, err
+	}
+	return i.issue(ctx, user, session.ID)
+}
+
+// Rotate exchanges a refresh token for a new token pair in the same family.
+// Presenting a token that was already rotated revokes the whole family,
+// since it means either the client or an attacker holds a stale copy.
+func (i *TokenIssuer) Rotate(ctx context.Context, refreshToken string, client ClientInfo) (*TokenPair, error) {
+	stored, err := i.RefreshRepo.GetRefreshTokenByHash(ctx, HashToken(refreshToken))
+	if err != nil {
+		if err.Error() == "refresh token not found" {
+			return nil, ErrInvalidRefreshToken
+		}
+		return nil, err
+	}
+
+	if stored.RevokedAt != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+	if stored.UsedAt != nil {
+		return nil, i.revokeReusedFamily(ctx, stored)
+	}
+	if time.Now().After(stored.ExpiresAt) {
+		return nil, ErrRefreshTokenExpired
+	}
+
+	claimed, err := i.RefreshRepo.MarkRefreshTokenUsed(ctx, stored.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !claimed {
+		// Lost a race against another rotation of the same token
+		return nil, i.revokeReusedFamily(ctx, stored)
+	}
+
+	user, err := i.UserRepo.GetUserByID(ctx, stored.UserID)
+	if err != nil {
+		if err.Error() == "user not found" {
+			return nil, ErrInvalidRefreshToken
+		}
+		return nil, err
+	}
+
+	if err := i.SessionRepo.TouchSession(ctx, stored.FamilyID, client.IP, time.Now()); err != nil {
+		log.Printf("Warning: Failed to update last seen time of session %s: %v", stored.FamilyID, err)
+	}
+
+	return i.issue(ctx, user, stored.FamilyID)
+}
+
+// RevokeFamily revokes the family a refresh token belongs to (used on logout)
+func (i *TokenIssuer) RevokeFamily(ctx context.Context, refreshToken string) error {
+	stored, err := i.RefreshRepo.GetRefreshTokenByHash(ctx, HashToken(refreshToken))
+	if err != nil {
+		if err.Error() == "refresh token not found" {
+			return ErrInvalidRefreshToken
+		}
+		return err
+	}
+	return i.RefreshRepo.RevokeRefreshTokenFamily(ctx, stored.FamilyID)
+}
+
+// RevokeSession ends one of the user's sessions along with its refresh tokens
+func (i *TokenIssuer) RevokeSession(ctx context.Context, userID int, sessionID string) error {
+	if err := i.Revocations.RevokeSession(ctx, userID, sessionID); err != nil {
+		return err
+	}
+	return i.RefreshRepo.RevokeRefreshTokenFamily(ctx, sessionID)
+}
+
+// RevokeAllForUser invalidates every session, access and refresh token the user holds
+func (i *TokenIssuer) RevokeAllForUser(ctx context.Context, userID int) error {
+	if err := i.Revocations.RevokeAllForUser(ctx, userID); err != nil {
+		return err
+	}
+	if err := i.SessionRepo.RevokeUserSessions(ctx, userID); err != nil {
+		return err
+	}
+	return i.RefreshRepo.RevokeUserRefreshTokens(ctx, userID)
+}
+
+func (i *TokenIssuer) revokeReusedFamily(ctx context.Context, stored *models.RefreshToken) error {
+	log.Printf("Warning: refresh token reuse detected for user %d (family %s), revoking family", stored.UserID, stored.FamilyID)
+	if err := i.RefreshRepo.RevokeRefreshTokenFamily(ctx, stored.FamilyID); err != nil {
+		return err
+	}
+	// The family is a session, so this also ends the access token from the
+	// last rotation, which may be the one the attacker holds
+	if err := i.Revocations.RevokeSession(ctx, stored.UserID, stored.FamilyID); err != nil && err.Error() != "session not found" {
+		return err
+	}
+	return ErrRefreshTokenReused
+}
+
+func (i *TokenIssuer) issue(ctx context.Context, user *models.User, familyID string) (*TokenPair, error) {
+	accessToken, err := GenerateToken(user, familyID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate access token: %w", err)
+	}
+
+	refreshToken, err := GenerateOpaqueToken()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+
+	_, err = i.RefreshRepo.CreateRefreshToken(ctx, &models.RefreshToken{
+		UserID:    user.ID,
+		FamilyID:  familyID,
+		TokenHash: HashToken(refreshToken),
+		ExpiresAt: time.Now().Add(config.AppConfig.RefreshTokenTTL),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(config.AppConfig.AccessTokenTTL.Seconds()),
+	}, nil
+}
+
+// HashToken returns the hex SHA-256 digest used to store opaque tokens
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// GenerateOpaqueToken returns 256 bits of randomness, URL-safe encoded
+func GenerateOpaqueToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,251 @@
+package auth
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Eduardo-Barreto/web-ponderada/backend/config"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/repository"
+)
+
+var revocationStore *RevocationStore
+
+// SetRevocationStore makes ValidateToken consult the given store.
+// Passing nil disables revocation checks.
+func SetRevocationStore(store *RevocationStore) {
+	revocationStore = store
+}
+
+type cachedVersion struct {
+	version   int
+	fetchedAt time.Time
+}
+
+// RevocationStore is a Postgres-backed denylist of token IDs (jti) and
+// sessions (sid) plus the per-user token version, fronted by an in-memory
+// cache so that validating a token does not normally cost a database round trip.
+//
+// Revoked jtis are cached until the token expires. Negative results, session
+// checks and token versions are cached for cacheTTL, which bounds how long a
+// revocation made by another instance can take to be noticed here.
+type RevocationStore struct {
+	revocations repository.TokenRevocationRepository
+	users       repository.UserRepository
+	sessions    repository.SessionRepository
+	cacheTTL    time.Duration
+
+	mu              sync.RWMutex
+	revoked         map[string]time.Time // jti -> token expiry
+	notRevoked      map[string]time.Time // jti -> time checked
+	revokedSessions map[string]time.Time // sid -> when its last access token expires
+	sessionsChecked map[string]time.Time // sid -> time found active
+	versions        map[int]cachedVersion
+}
+
+func NewRevocationStore(revocations repository.TokenRevocationRepository, users repository.UserRepository, sessions repository.SessionRepository, cacheTTL time.Duration) *RevocationStore {
+	return &RevocationStore{
+		revocations:     revocations,
+		users:           users,
+		sessions:        sessions,
+		cacheTTL:        cacheTTL,
+		revoked:         make(map[string]time.Time),
+		notRevoked:      make(map[string]time.Time),
+		revokedSessions: make(map[string]time.Time),
+		sessionsChecked: make(map[string]time.Time),
+		versions:        make(map[int]cachedVersion),
+	}
+}
+
+// IsRevoked reports whether the token was revoked individually, belongs to
+// a revoked session or predates the user's current token version.
+func (s *RevocationStore) IsRevoked(ctx context.Context, claims *Claims) (bool, error) {
+	version, err := s.tokenVersion(ctx, claims.UserID)
+	if err != nil {
+		if err.Error() == "user not found" {
+			return true, nil
+		}
+		return false, err
+	}
+	if claims.TokenVersion != version {
+		return true, nil
+	}
+
+	if claims.SessionID != "" {
+		revoked, err := s.isSessionRevoked(ctx, claims.SessionID)
+		if err != nil || revoked {
+			return revoked, err
+		}
+	}
+
+	if claims.ID == "" {
+		return false, nil
+	}
+	return s.isJTIRevoked(ctx, claims.ID)
+}
+
+// Revoke adds a single token to the denylist until it expires
+func (s *RevocationStore) Revoke(ctx context.Context, claims *Claims) error {
+	return s.RevokeID(ctx, claims.ID, claims.UserID, claims.ExpiresAt.Time)
+}
+
+// RevokeID denylists any token by its jti, e.g. a single-use mfa_token
+func (s *RevocationStore) RevokeID(ctx context.Context, jti string, userID int, expiresAt time.Time) error {
+	if err := s.revocations.RevokeToken(ctx, jti, userID, expiresAt); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.revoked[jti] = expiresAt
+	delete(s.notRevoked, jti)
+	s.mu.Unlock()
+	return nil
+}
+
+// IsIDRevoked reports whether a token ID was denylisted with RevokeID
+func (s *RevocationStore) IsIDRevoked(ctx context.Context, jti string) (bool, error) {
+	return s.isJTIRevoked(ctx, jti)
+}
+
+// RevokeSession ends one session; its access tokens stop working right away
+func (s *RevocationStore) RevokeSession(ctx context.Context, userID int, sessionID string) error {
+	if err := s.sessions.RevokeSession(ctx, userID, sessionID); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.revokedSessions[sessionID] = time.Now().Add(config.AppConfig.AccessTokenTTL)
+	delete(s.sessionsChecked, sessionID)
+	s.mu.Unlock()
+	return nil
+}
+
+// RevokeAllForUser bumps the user's token version, invalidating every
+// access token issued to them so far.
+func (s *RevocationStore) RevokeAllForUser(ctx context.Context, userID int) error {
+	version, err := s.users.IncrementUserTokenVersion(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.versions[userID] = cachedVersion{version: version, fetchedAt: time.Now()}
+	s.mu.Unlock()
+	return nil
+}
+
+// Prune drops expired entries from the cache and the database
+func (s *RevocationStore) Prune(ctx context.Context) (int64, error) {
+	now := time.Now()
+
+	s.mu.Lock()
+	for jti, expiresAt := range s.revoked {
+		if now.After(expiresAt) {
+			delete(s.revoked, jti)
+		}
+	}
+	for jti, checkedAt := range s.notRevoked {
+		if now.Sub(checkedAt) > s.cacheTTL {
+			delete(s.notRevoked, jti)
+		}
+	}
+	for sid, expiresAt := range s.revokedSessions {
+		if now.After(expiresAt) {
+			delete(s.revokedSessions, sid)
+		}
+	}
+	for sid, checkedAt := range s.sessionsChecked {
+		if now.Sub(checkedAt) > s.cacheTTL {
+			delete(s.sessionsChecked, sid)
+		}
+	}
+	for userID, cached := range s.versions {
+		if now.Sub(cached.fetchedAt) > s.cacheTTL {
+			delete(s.versions, userID)
+		}
+	}
+	s.mu.Unlock()
+
+	return s.revocations.DeleteExpiredRevocations(ctx)
+}
+
+func (s *RevocationStore) isJTIRevoked(ctx context.Context, jti string) (bool, error) {
+	now := time.Now()
+
+	s.mu.RLock()
+	_, revoked := s.revoked[jti]
+	checkedAt, checked := s.notRevoked[jti]
+	s.mu.RUnlock()
+
+	if revoked {
+		return true, nil
+	}
+	if checked && now.Sub(checkedAt) < s.cacheTTL {
+		return false, nil
+	}
+
+	revoked, err := s.revocations.IsTokenRevoked(ctx, jti)
+	if err != nil {
+		return false, err
+	}
+
+	s.mu.Lock()
+	if revoked {
+		// Expiry is unknown here; keep it for one cache period and re-check after
+		s.revoked[jti] = now.Add(s.cacheTTL)
+	} else {
+		s.notRevoked[jti] = now
+	}
+	s.mu.Unlock()
+	return revoked, nil
+}
+
+func (s *RevocationStore) isSessionRevoked(ctx context.Context, sid string) (bool, error) {
+	now := time.Now()
+
+	s.mu.RLock()
+	_, revoked := s.revokedSessions[sid]
+	checkedAt, checked := s.sessionsChecked[sid]
+	s.mu.RUnlock()
+
+	if revoked {
+		return true, nil
+	}
+	if checked && now.Sub(checkedAt) < s.cacheTTL {
+		return false, nil
+	}
+
+	revoked, err := s.sessions.IsSessionRevoked(ctx, sid)
+	if err != nil {
+		return false, err
+	}
+
+	s.mu.Lock()
+	if revoked {
+		s.revokedSessions[sid] = now.Add(config.AppConfig.AccessTokenTTL)
+	} else {
+		s.sessionsChecked[sid] = now
+	}
+	s.mu.Unlock()
+	return revoked, nil
+}
+
+func (s *RevocationStore) tokenVersion(ctx context.Context, userID int) (int, error) {
+	s.mu.RLock()
+	cached, ok := s.versions[userID]
+	s.mu.RUnlock()
+
+	if ok && time.Since(cached.fetchedAt) < s.cacheTTL {
+		return cached.version, nil
+	}
+
+	version, err := s.users.GetUserTokenVersion(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	s.versions[userID] = cachedVersion{version: version, fetchedAt: time.Now()}
+	s.mu.Unlock()
+	return version, nil
+}
@@ -0,0 +1,148 @@
+package auth
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/Eduardo-Barreto/web-ponderada/backend/config"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/repository"
+)
+
+// LoginThrottler slows down and eventually locks out repeated failed logins,
+// tracking each account and each client IP separately.
+type LoginThrottler struct {
+	Repo repository.LoginAttemptRepository
+}
+
+func NewLoginThrottler(repo repository.LoginAttemptRepository) *LoginThrottler {
+	return &LoginThrottler{Repo: repo}
+}
+
+func emailKey(email string) string { return "email:" + strings.ToLower(strings.TrimSpace(email)) }
+func ipKey(ip string) string       { return "ip:" + ip }
+func magicLinkKey(email string) string {
+	return "magic:" + strings.ToLower(strings.TrimSpace(email))
+}
+func passwordResetKey(email string) string {
+	return "reset:" + strings.ToLower(strings.TrimSpace(email))
+}
+func verificationKey(email string) string {
+	return "verify:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+// Check returns how long the caller must wait before trying again, or zero
+// if the login may proceed.
+func (t *LoginThrottler) Check(ctx context.Context, email, ip string) (time.Duration, error) {
+	var wait time.Duration
+	for _, key := range []string{emailKey(email), ipKey(ip)} {
+		attempt, err := t.Repo.GetLoginAttempt(ctx, key)
+		if err != nil {
+			return 0, err
+		}
+		if attempt != nil && attempt.LockedUntil != nil {
+			if remaining := time.Until(*attempt.LockedUntil); remaining > wait {
+				wait = remaining
+			}
+		}
+	}
+	return wait, nil
+}
+
+// RecordFailure counts a failed login against both the account and the IP
+func (t *LoginThrottler) RecordFailure(ctx context.Context, email, ip string) error {
+	cfg := config.AppConfig
+	if err := t.recordFailure(ctx, emailKey(email), cfg.LoginMaxFailures); err != nil {
+		return err
+	}
+	return t.recordFailure(ctx, ipKey(ip), cfg.LoginIPMaxFailures)
+}
+
+// RecordSuccess clears the account's failures. The IP counter is left alone,
+// otherwise an attacker could reset it by logging into an account of their own.
+func (t *LoginThrottler) RecordSuccess(ctx context.Context, email string) error {
+	return t.Repo.ResetLoginAttempts(ctx, emailKey(email))
+}
+
+// Unlock clears any failures and lockout on an account
+func (t *LoginThrottler) Unlock(ctx context.Context, email string) error {
+	return t.Repo.ResetLoginAttempts(ctx, emailKey(email))
+}
+
+// AllowMagicLink counts a magic link request for the email and returns how
+// long the caller must wait if MagicLinkMaxRequests were already made within
+// MagicLinkWindow. Unknown emails are counted too, so the limit does not
+// reveal which addresses are registered.
+func (t *LoginThrottler) AllowMagicLink(ctx context.Context, email string) (time.Duration, error) {
+	cfg := config.AppConfig
+	return t.allowRequest(ctx, magicLinkKey(email), cfg.MagicLinkMaxRequests, cfg.MagicLinkWindow)
+}
+
+// AllowPasswordReset is AllowMagicLink for password reset emails, limited to
+// EmailLinkMaxRequests per EmailLinkWindow
+func (t *LoginThrottler) AllowPasswordReset(ctx context.Context, email string) (time.Duration, error) {
+	cfg := config.AppConfig
+	return t.allowRequest(ctx, passwordResetKey(email), cfg.EmailLinkMaxRequests, cfg.EmailLinkWindow)
+}
+
+// AllowVerificationEmail is AllowPasswordReset for resent verification emails
+func (t *LoginThrottler) AllowVerificationEmail(ctx context.Context, email string) (time.Duration, error) {
+	cfg := config.AppConfig
+	return t.allowRequest(ctx, verificationKey(email), cfg.EmailLinkMaxRequests, cfg.EmailLinkWindow)
+}
+
+// allowRequest counts a request under key, locking the key out for window
+// once maxRequests were made within it
+func (t *LoginThrottler) allowRequest(ctx context.Context, key string, maxRequests int, window time.Duration) (time.Duration, error) {
+	attempt, err := t.Repo.GetLoginAttempt(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	if attempt != nil && attempt.LockedUntil != nil {
+		if remaining := time.Until(*attempt.LockedUntil); remaining > 0 {
+			return remaining, nil
+		}
+	}
+
+	attempt, err = t.Repo.RecordLoginFailure(ctx, key, time.Now().Add(-window))
+	if err != nil {
+		return 0, err
+	}
+	if attempt.Failures >= maxRequests {
+		return 0, t.Repo.SetLoginLockout(ctx, key, time.Now().Add(window))
+	}
+	return 0, nil
+}
+
+func (t *LoginThrottler) recordFailure(ctx context.Context, key string, maxFailures int) error {
+	attempt, err := t.Repo.RecordLoginFailure(ctx, key, time.Now().Add(-config.AppConfig.LoginFailureWindow))
+	if err != nil {
+		return err
+	}
+
+	if delay := lockoutDelay(attempt.Failures, maxFailures); delay > 0 {
+		return t.Repo.SetLoginLockout(ctx, key, time.Now().Add(delay))
+	}
+	return nil
+}
+
+// lockoutDelay is zero for the first few failures, then doubles with every
+// failure, and becomes the full lockout once maxFailures is reached.
+func lockoutDelay(failures, maxFailures int) time.Duration {
+	cfg := config.AppConfig
+	if failures >= maxFailures {
+		return cfg.LoginLockoutDuration
+	}
+	if failures <= cfg.LoginFreeAttempts {
+		return 0
+	}
+
+	delay := cfg.LoginBackoffBase
+	for i := cfg.LoginFreeAttempts + 1; i < failures; i++ {
+		delay *= 2
+		if delay >= cfg.LoginLockoutDuration {
+			return cfg.LoginLockoutDuration
+		}
+	}
+	return delay
+}
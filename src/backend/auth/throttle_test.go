@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Eduardo-Barreto/web-ponderada/backend/config"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/models"
+)
+
+func TestLockoutDelay(t *testing.T) {
+	tests := []struct {
+		name        string
+		backoffBase time.Duration
+		lockout     time.Duration
+		failures    int
+		maxFailures int
+		want        time.Duration
+	}{
+		{"no failures", time.Second, 15 * time.Minute, 0, 10, 0},
+		{"last free attempt", time.Second, 15 * time.Minute, 3, 10, 0},
+		{"first delayed attempt", time.Second, 15 * time.Minute, 4, 10, time.Second},
+		{"doubles", time.Second, 15 * time.Minute, 5, 10, 2 * time.Second},
+		{"keeps doubling", time.Second, 15 * time.Minute, 9, 10, 32 * time.Second},
+		{"max failures locks out", time.Second, 15 * time.Minute, 10, 10, 15 * time.Minute},
+		{"past max failures", time.Second, 15 * time.Minute, 25, 10, 15 * time.Minute},
+		{"backoff capped at the lockout", time.Minute, 5 * time.Minute, 7, 20, 5 * time.Minute},
+		{"lower limit for IPs", time.Second, 15 * time.Minute, 4, 4, 15 * time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.AppConfig = &config.Config{
+				LoginFreeAttempts:    3,
+				LoginBackoffBase:     tt.backoffBase,
+				LoginLockoutDuration: tt.lockout,
+			}
+			if got := lockoutDelay(tt.failures, tt.maxFailures); got != tt.want {
+				t.Errorf("lockoutDelay(%d, %d) = %v, want %v", tt.failures, tt.maxFailures, got, tt.want)
+			}
+		})
+	}
+}
+
+// memoryLoginAttempts is an in-memory LoginAttemptRepository
+type memoryLoginAttempts map[string]*models.LoginAttempt
+
+func (m memoryLoginAttempts) GetLoginAttempt(ctx context.Context, key string) (*models.LoginAttempt, error) {
+	return m[key], nil
+}
+
+func (m memoryLoginAttempts) RecordLoginFailure(ctx context.Context, key string, windowStart time.Time) (*models.LoginAttempt, error) {
+	attempt, ok := m[key]
+	if !ok || attempt.LastFailureAt.Before(windowStart) {
+		attempt = &models.LoginAttempt{Key: key}
+		m[key] = attempt
+	}
+	attempt.Failures++
+	attempt.LastFailureAt = time.Now()
+	return attempt, nil
+}
+
+func (m memoryLoginAttempts) SetLoginLockout(ctx context.Context, key string, lockedUntil time.Time) error {
+	m[key].LockedUntil = &lockedUntil
+	return nil
+}
+
+func (m memoryLoginAttempts) ResetLoginAttempts(ctx context.Context, key string) error {
+	delete(m, key)
+	return nil
+}
+
+func TestLoginThrottler(t *testing.T) {
+	config.AppConfig = &config.Config{
+		LoginFreeAttempts:    1,
+		LoginMaxFailures:     3,
+		LoginIPMaxFailures:   5,
+		LoginFailureWindow:   time.Hour,
+		LoginBackoffBase:     time.Minute,
+		LoginLockoutDuration: time.Hour,
+	}
+	ctx := context.Background()
+	throttler := NewLoginThrottler(memoryLoginAttempts{})
+	wait := func(email, ip string) time.Duration {
+		t.Helper()
+		d, err := throttler.Check(ctx, email, ip)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+
+	throttler.RecordFailure(ctx, "Maria@Example.com ", "10.0.0.1")
+	if d := wait("maria@example.com", "10.0.0.2"); d != 0 {
+		t.Errorf("after a free attempt: wait %v, want none", d)
+	}
+
+	throttler.RecordFailure(ctx, "maria@example.com", "10.0.0.1")
+	if d := wait("maria@example.com", "10.0.0.2"); d <= 0 || d > time.Minute {
+		t.Errorf("after backoff starts: wait %v, want up to a minute", d)
+	}
+
+	throttler.RecordFailure(ctx, "maria@example.com", "10.0.0.1")
+	if d := wait("maria@example.com", "10.0.0.2"); d <= time.Minute {
+		t.Errorf("after max failures: wait %v, want the lockout", d)
+	}
+
+	// Success clears the account but not the IP, which took 3 of its 5 failures
+	throttler.RecordSuccess(ctx, "maria@example.com")
+	if d := wait("maria@example.com", "10.0.0.2"); d != 0 {
+		t.Errorf("after success: wait %v, want none", d)
+	}
+	if d := wait("joao@example.com", "10.0.0.1"); d <= 0 {
+		t.Errorf("other account from the same IP: wait %v, want the IP backoff", d)
+	}
+}
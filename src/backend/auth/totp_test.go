@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// The RFC 6238 appendix B secret, "12345678901234567890", in base32
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCodeMatchesRFC6238(t *testing.T) {
+	key, err := totpEncoding.DecodeString(rfcSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Last six digits of the SHA-1 vectors in RFC 6238 appendix B
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		if got := totpCode(key, uint64(tt.unix/30)); got != tt.want {
+			t.Errorf("code at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	now := time.Unix(1111111111, 0) // Step 37037037, code 050471
+	const step = int64(37037037)
+
+	tests := []struct {
+		name     string
+		secret   string
+		code     string
+		at       time.Time
+		wantStep int64
+		wantOK   bool
+	}{
+		{"current step", rfcSecret, "050471", now, step, true},
+		{"surrounding spaces", rfcSecret, " 050471 ", now, step, true},
+		{"lowercase secret", strings.ToLower(rfcSecret), "050471", now, step, true},
+		{"code from the previous step", rfcSecret, "050471", now.Add(30 * time.Second), step, true},
+		{"code from the next step", rfcSecret, "050471", now.Add(-30 * time.Second), step, true},
+		{"two steps late", rfcSecret, "050471", now.Add(60 * time.Second), 0, false},
+		{"two steps early", rfcSecret, "050471", now.Add(-60 * time.Second), 0, false},
+		{"wrong code", rfcSecret, "050472", now, 0, false},
+		{"too short", rfcSecret, "50471", now, 0, false},
+		{"too long", rfcSecret, "0050471", now, 0, false},
+		{"invalid secret", "not base32!", "050471", now, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotStep, ok := ValidateTOTP(tt.secret, tt.code, tt.at)
+			if ok != tt.wantOK || gotStep != tt.wantStep {
+				t.Errorf("ValidateTOTP = (%d, %v), want (%d, %v)", gotStep, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	got := TOTPURI("Web Ponderada", "maria@example.com", rfcSecret)
+	want := "otpauth://totp/Web%20Ponderada:maria@example.com?algorithm=SHA1&digits=6&issuer=Web+Ponderada&period=30&secret=" + rfcSecret
+	if got != want {
+		t.Errorf("TOTPURI = %s\nwant      %s", got, want)
+	}
+}
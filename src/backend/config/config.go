@@ -3,7 +3,10 @@ package config
 import (
 	"log"
 	"os"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -13,6 +16,140 @@ type Config struct {
 	Port        string
 	JWTSecret   string
 	UploadDir   string
+
+	// Asymmetric token signing. When JWTKeysDir is set, every *.pem in it is
+	// accepted for verification and JWTSecret is no longer used.
+	JWTKeysDir      string
+	JWTSigningKeyID string // File name (without .pem) of the signing key
+
+	// iss and aud of access tokens. Other signed tokens (email verification,
+	// MFA challenges, OIDC state) carry neither, so services verifying tokens
+	// through the JWKS must check both.
+	JWTIssuer   string // Defaults to PublicURL
+	JWTAudience string
+
+	// Token lifetimes
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+
+	// Lifetime of the tokens admins get to act as another user (not renewable)
+	ImpersonationTTL time.Duration
+
+	// How long revocation lookups are cached in memory
+	RevocationCacheTTL time.Duration
+
+	// Email that is granted the admin role once verified, if no admin exists yet (bootstraps the first admin)
+	BootstrapAdminEmail string
+
+	// Outgoing email
+	MailerDriver  string // "smtp" or "file"
+	SMTPHost      string
+	SMTPPort      int
+	SMTPUsername  string
+	SMTPPassword  string
+	MailFrom      string
+	MailOutboxDir string // Used by the "file" driver
+
+	// Public URL of the frontend, used to build links sent by email
+	FrontendURL string
+
+	PasswordResetTTL time.Duration
+
+	// Password reset and verification emails that can be requested per email
+	// within EmailLinkWindow, which is also how long further requests are refused
+	EmailLinkMaxRequests int
+	EmailLinkWindow      time.Duration
+
+	// Passwordless login links sent by email
+	MagicLinkEnabled     bool
+	MagicLinkTTL         time.Duration
+	MagicLinkMaxRequests int           // Links that can be requested per email within MagicLinkWindow
+	MagicLinkWindow      time.Duration // Also how long further requests are refused after the max
+
+	// Passkey (WebAuthn) login. The relying party ID is the domain passkeys
+	// are bound to; browsers only use them on pages served from one of the origins.
+	PasskeysEnabled     bool
+	WebAuthnRPID        string
+	WebAuthnRPName      string        // Shown by the browser when creating a passkey
+	WebAuthnOrigins     []string      // Defaults to FrontendURL
+	WebAuthnCeremonyTTL time.Duration // How long the browser has to answer a challenge
+
+	// Password policy for new passwords
+	PasswordMinLength        int
+	PasswordRequiredClasses  []string // Any of lower, upper, digit, symbol
+	PasswordBreachedListFile string   // SHA-1 digests of passwords to reject: a file, one per line, or a directory of range files
+
+	// argon2id parameters for new password hashes. Changing them upgrades
+	// existing hashes as users log in.
+	Argon2Memory      int // KiB
+	Argon2Iterations  int
+	Argon2Parallelism int
+
+	// Email verification
+	EmailVerificationTTL     time.Duration
+	RequireEmailVerification bool // Reject logins from unverified accounts
+
+	// Login throttling: after LoginFreeAttempts failures each further one doubles
+	// the wait (starting at LoginBackoffBase); reaching the max locks the key out.
+	LoginFreeAttempts    int
+	LoginMaxFailures     int // Per account
+	LoginIPMaxFailures   int // Per client IP
+	LoginBackoffBase     time.Duration
+	LoginLockoutDuration time.Duration
+	LoginFailureWindow   time.Duration // Failures older than this are forgotten
+
+	// Two-factor authentication
+	MFAIssuer       string        // Shown in authenticator apps
+	MFAPendingTTL   time.Duration // How long the user has to enter the code after the password
+	RequireAdminMFA bool          // Admins without 2FA enabled cannot use admin-only routes
+
+	// Proxies whose X-Forwarded-For header is trusted when resolving the client IP
+	TrustedProxies []string
+
+	// Public URL of this API, used to build OAuth redirect URLs
+	PublicURL string
+
+	// OpenID Connect identity providers, keyed by the name used in the URL
+	OIDCProviders map[string]OIDCProvider
+
+	// Browser sessions in HttpOnly cookies, for clients that ask for them
+	SessionCookies bool
+	CookieSecure   bool
+	CookieSameSite string // "strict", "lax" or "none"
+	CookieDomain   string // Empty for the API host only
+
+	// Deleted users are kept (and can be restored by an admin) for
+	// DeletedUserRetention, then purged along with their files
+	DeletedUserRetention time.Duration
+	UserPurgeInterval    time.Duration // How often the purge and the anonymisation below run
+
+	// Accounts users close themselves are anonymised after this many days,
+	// unless they log in again before then
+	AccountDeletionGraceDays int
+
+	// Personal data exports: zips are kept in DataExportDir (never served
+	// publicly) and downloadable for DataExportTTL
+	DataExportDir string
+	DataExportTTL time.Duration
+
+	// Profile pictures are cropped square and stored in these sizes (pixels
+	// per side); the largest doubles as the user's profile_pic
+	ProfilePicSizes []int
+
+	// Origins allowed to make credentialed (cookie) requests. When empty any
+	// origin is allowed, but without credentials.
+	CORSAllowedOrigins []string
+}
+
+// OIDCProvider holds the settings for one OpenID Connect identity provider.
+// Read from OIDC_<NAME>_* for every name listed in OIDC_PROVIDERS.
+type OIDCProvider struct {
+	Name         string
+	IssuerURL    string // Discovery is done from <issuer>/.well-known/openid-configuration
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
 }
 
 var AppConfig *Config
@@ -25,6 +162,117 @@ func LoadConfig() {
 		Port:        getEnv("PORT", "8080"),
 		JWTSecret:   getEnv("JWT_SECRET", "a-very-secret-key"),
 		UploadDir:   getEnv("UPLOAD_DIR", "./uploads"), // Relative to backend executable or volume mount
+
+		JWTKeysDir:      getEnv("JWT_KEYS_DIR", ""),
+		JWTSigningKeyID: getEnv("JWT_SIGNING_KEY_ID", ""),
+		JWTAudience:     getEnv("JWT_AUDIENCE", "web-ponderada-api"),
+
+		AccessTokenTTL:  getEnvAsDuration("ACCESS_TOKEN_TTL", 15*time.Minute),
+		RefreshTokenTTL: getEnvAsDuration("REFRESH_TOKEN_TTL", 30*24*time.Hour),
+
+		ImpersonationTTL: getEnvAsDuration("IMPERSONATION_TTL", 10*time.Minute),
+
+		RevocationCacheTTL: getEnvAsDuration("REVOCATION_CACHE_TTL", 30*time.Second),
+
+		BootstrapAdminEmail: getEnv("BOOTSTRAP_ADMIN_EMAIL", ""),
+
+		MailerDriver:  getEnv("MAILER_DRIVER", "file"),
+		SMTPHost:      getEnv("SMTP_HOST", "localhost"),
+		SMTPPort:      getEnvAsInt("SMTP_PORT", 587),
+		SMTPUsername:  getEnv("SMTP_USERNAME", ""),
+		SMTPPassword:  getEnv("SMTP_PASSWORD", ""),
+		MailFrom:      getEnv("MAIL_FROM", "no-reply@localhost"),
+		MailOutboxDir: getEnv("MAIL_OUTBOX_DIR", "./outbox"),
+
+		FrontendURL: getEnv("FRONTEND_URL", "http://localhost:8080"),
+
+		PasswordResetTTL: getEnvAsDuration("PASSWORD_RESET_TTL", time.Hour),
+
+		EmailLinkMaxRequests: getEnvAsInt("EMAIL_LINK_MAX_REQUESTS", 3),
+		EmailLinkWindow:      getEnvAsDuration("EMAIL_LINK_WINDOW", time.Hour),
+
+		MagicLinkEnabled:     getEnvAsBool("MAGIC_LINK_ENABLED", false),
+		MagicLinkTTL:         getEnvAsDuration("MAGIC_LINK_TTL", 15*time.Minute),
+		MagicLinkMaxRequests: getEnvAsInt("MAGIC_LINK_MAX_REQUESTS", 3),
+		MagicLinkWindow:      getEnvAsDuration("MAGIC_LINK_WINDOW", time.Hour),
+
+		PasskeysEnabled:     getEnvAsBool("PASSKEYS_ENABLED", false),
+		WebAuthnRPID:        getEnv("WEBAUTHN_RP_ID", "localhost"),
+		WebAuthnRPName:      getEnv("WEBAUTHN_RP_NAME", "Web Ponderada"),
+		WebAuthnCeremonyTTL: getEnvAsDuration("WEBAUTHN_CEREMONY_TTL", 5*time.Minute),
+
+		PasswordMinLength:        getEnvAsInt("PASSWORD_MIN_LENGTH", 8),
+		PasswordRequiredClasses:  getEnvAsSlice("PASSWORD_REQUIRED_CLASSES", nil),
+		PasswordBreachedListFile: getEnv("PASSWORD_BREACHED_LIST_FILE", ""),
+
+		Argon2Memory:      getEnvAsInt("ARGON2_MEMORY_KIB", 64*1024),
+		Argon2Iterations:  getEnvAsInt("ARGON2_ITERATIONS", 3),
+		Argon2Parallelism: getEnvAsInt("ARGON2_PARALLELISM", 2),
+
+		EmailVerificationTTL:     getEnvAsDuration("EMAIL_VERIFICATION_TTL", 48*time.Hour),
+		RequireEmailVerification: getEnvAsBool("REQUIRE_EMAIL_VERIFICATION", false),
+
+		LoginFreeAttempts:    getEnvAsInt("LOGIN_FREE_ATTEMPTS", 3),
+		LoginMaxFailures:     getEnvAsInt("LOGIN_MAX_FAILURES", 10),
+		LoginIPMaxFailures:   getEnvAsInt("LOGIN_IP_MAX_FAILURES", 50),
+		LoginBackoffBase:     getEnvAsDuration("LOGIN_BACKOFF_BASE", time.Second),
+		LoginLockoutDuration: getEnvAsDuration("LOGIN_LOCKOUT_DURATION", 15*time.Minute),
+		LoginFailureWindow:   getEnvAsDuration("LOGIN_FAILURE_WINDOW", time.Hour),
+
+		MFAIssuer:       getEnv("MFA_ISSUER", "Web Ponderada"),
+		MFAPendingTTL:   getEnvAsDuration("MFA_PENDING_TTL", 5*time.Minute),
+		RequireAdminMFA: getEnvAsBool("REQUIRE_ADMIN_MFA", false),
+
+		TrustedProxies: getEnvAsSlice("TRUSTED_PROXIES", nil),
+
+		PublicURL: strings.TrimSuffix(getEnv("PUBLIC_URL", "http://localhost:8000"), "/"),
+
+		SessionCookies: getEnvAsBool("SESSION_COOKIES", false),
+		CookieSecure:   getEnvAsBool("COOKIE_SECURE", true),
+		CookieSameSite: strings.ToLower(getEnv("COOKIE_SAMESITE", "lax")),
+		CookieDomain:   getEnv("COOKIE_DOMAIN", ""),
+
+		DeletedUserRetention: getEnvAsDuration("DELETED_USER_RETENTION", 30*24*time.Hour),
+		UserPurgeInterval:    getEnvAsDuration("USER_PURGE_INTERVAL", time.Hour),
+
+		AccountDeletionGraceDays: getEnvAsInt("ACCOUNT_DELETION_GRACE_DAYS", 14),
+
+		DataExportDir: getEnv("DATA_EXPORT_DIR", "./exports"),
+		DataExportTTL: getEnvAsDuration("DATA_EXPORT_TTL", 72*time.Hour),
+
+		CORSAllowedOrigins: getEnvAsSlice("CORS_ALLOWED_ORIGINS", nil),
+	}
+
+	AppConfig.ProfilePicSizes = loadProfilePicSizes()
+
+	AppConfig.JWTIssuer = getEnv("JWT_ISSUER", AppConfig.PublicURL)
+	AppConfig.OIDCProviders = loadOIDCProviders(AppConfig.PublicURL)
+	AppConfig.WebAuthnOrigins = getEnvAsSlice("WEBAUTHN_ORIGINS", []string{strings.TrimSuffix(AppConfig.FrontendURL, "/")})
+
+	if AppConfig.Argon2Memory < 8*AppConfig.Argon2Parallelism || AppConfig.Argon2Iterations < 1 ||
+		AppConfig.Argon2Parallelism < 1 || AppConfig.Argon2Parallelism > 255 {
+		log.Fatalf("Invalid argon2 parameters: memory must be at least 8 KiB per thread, iterations at least 1 and parallelism between 1 and 255")
+	}
+
+	switch AppConfig.CookieSameSite {
+	case "strict", "lax":
+	case "none":
+		if !AppConfig.CookieSecure {
+			log.Fatalf("COOKIE_SAMESITE=none requires COOKIE_SECURE=true")
+		}
+	default:
+		log.Fatalf("Invalid COOKIE_SAMESITE %q (use strict, lax or none)", AppConfig.CookieSameSite)
+	}
+	if AppConfig.DeletedUserRetention < 0 || AppConfig.UserPurgeInterval <= 0 {
+		log.Fatalf("DELETED_USER_RETENTION cannot be negative and USER_PURGE_INTERVAL must be positive")
+	}
+	if AppConfig.AccountDeletionGraceDays < 0 {
+		log.Fatalf("ACCOUNT_DELETION_GRACE_DAYS cannot be negative")
+	}
+	for _, origin := range AppConfig.CORSAllowedOrigins {
+		if origin == "*" {
+			log.Fatalf("CORS_ALLOWED_ORIGINS cannot contain \"*\", since those origins may send cookies")
+		}
 	}
 
 	// Ensure upload directory exists
@@ -39,6 +287,48 @@ func LoadConfig() {
 	}
 }
 
+// loadProfilePicSizes reads PROFILE_PIC_SIZES, sorted from smallest to largest
+func loadProfilePicSizes() []int {
+	var sizes []int
+	for _, value := range getEnvAsSlice("PROFILE_PIC_SIZES", []string{"64", "256", "512"}) {
+		size, err := strconv.Atoi(value)
+		if err != nil || size < 16 || size > 2048 {
+			log.Fatalf("Invalid PROFILE_PIC_SIZES entry %q (use sizes between 16 and 2048 pixels)", value)
+		}
+		if !slices.Contains(sizes, size) {
+			sizes = append(sizes, size)
+		}
+	}
+	if len(sizes) == 0 {
+		log.Fatalf("PROFILE_PIC_SIZES must list at least one size")
+	}
+	slices.Sort(sizes)
+	return sizes
+}
+
+// loadOIDCProviders reads the settings of every provider in OIDC_PROVIDERS
+func loadOIDCProviders(publicURL string) map[string]OIDCProvider {
+	providers := make(map[string]OIDCProvider)
+	for _, name := range getEnvAsSlice("OIDC_PROVIDERS", nil) {
+		name = strings.ToLower(name)
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+
+		provider := OIDCProvider{
+			Name:         name,
+			IssuerURL:    getEnv(prefix+"ISSUER", ""),
+			ClientID:     getEnv(prefix+"CLIENT_ID", ""),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"), // Not logged; empty for public clients
+			RedirectURL:  getEnv(prefix+"REDIRECT_URL", publicURL+"/api/v1/auth/oidc/"+name+"/callback"),
+			Scopes:       getEnvAsSlice(prefix+"SCOPES", []string{"openid", "email", "profile"}),
+		}
+		if provider.IssuerURL == "" || provider.ClientID == "" {
+			log.Fatalf("OIDC provider %q requires %sISSUER and %sCLIENT_ID", name, prefix, prefix)
+		}
+		providers[name] = provider
+	}
+	return providers
+}
+
 func getEnv(key, fallback string) string {
 	if value, exists := os.LookupEnv(key); exists {
 		return value
@@ -56,3 +346,38 @@ func getEnvAsInt(key string, fallback int) int {
 	log.Printf("Environment variable %s not a valid integer, using fallback: %d", key, fallback)
 	return fallback
 }
+
+// Helper to get env var as a bool ("true", "1", "false", "0", ...)
+func getEnvAsBool(key string, fallback bool) bool {
+	valueStr := getEnv(key, "")
+	if value, err := strconv.ParseBool(valueStr); err == nil {
+		return value
+	}
+	log.Printf("Environment variable %s not a valid boolean, using fallback: %t", key, fallback)
+	return fallback
+}
+
+// Helper to get a comma-separated env var as a slice, skipping empty items
+func getEnvAsSlice(key string, fallback []string) []string {
+	valueStr := getEnv(key, "")
+	if valueStr == "" {
+		return fallback
+	}
+	var values []string
+	for _, item := range strings.Split(valueStr, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			values = append(values, item)
+		}
+	}
+	return values
+}
+
+// Helper to get env var as a duration (e.g. "15m", "720h")
+func getEnvAsDuration(key string, fallback time.Duration) time.Duration {
+	valueStr := getEnv(key, "")
+	if value, err := time.ParseDuration(valueStr); err == nil {
+		return value
+	}
+	log.Printf("Environment variable %s not a valid duration, using fallback: %s", key, fallback)
+	return fallback
+}
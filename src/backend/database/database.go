@@ -2,6 +2,8 @@ package database
 
 import (
 	"context"
+	_ "embed"
+	"fmt"
 	"log"
 	"os"
 	"time"
@@ -54,6 +56,35 @@ func ConnectDB() {
 	os.Exit(1)
 }
 
+// schema is applied on every start. Each statement is idempotent (IF NOT EXISTS,
+// CREATE OR REPLACE...), so databases created by an older version pick up new
+// tables, columns and indexes while fresh ones are created from scratch.
+//
+//go:embed init.sql
+var schema string
+
+// Arbitrary key for the advisory lock that keeps instances starting together
+// from migrating at the same time
+const migrationLockKey = 7_381_004_512
+
+// Migrate brings the database schema up to date with init.sql
+func Migrate(ctx context.Context) error {
+	tx, err := Pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin migration: %w", err)
+	}
+	defer tx.Rollback(ctx) // No-op after commit
+
+	if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", migrationLockKey); err != nil {
+		return fmt.Errorf("failed to acquire migration lock: %w", err)
+	}
+	// Without arguments pgx uses the simple protocol, which runs the whole script
+	if _, err := tx.Exec(ctx, schema); err != nil {
+		return fmt.Errorf("failed to apply schema: %w", err)
+	}
+	return tx.Commit(ctx)
+}
+
 // Optional: Function to close the pool gracefully on shutdown
 func CloseDB() {
 	if Pool != nil {
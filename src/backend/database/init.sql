@@ -1,4 +1,6 @@
 -- src/database/init.sql
+-- Also applied by the backend on every start (database.Migrate), so every statement
+-- must be safe to re-run: existing databases are upgraded by running this file again.
 
 -- Enable UUID generation if needed (though we use Go's UUID for filenames)
 -- CREATE EXTENSION IF NOT EXISTS "uuid-ossp";
@@ -7,13 +9,40 @@
 CREATE TABLE IF NOT EXISTS users (
     id SERIAL PRIMARY KEY,
     name VARCHAR(100) NOT NULL,
-    email VARCHAR(255) UNIQUE NOT NULL,
+    email VARCHAR(255) NOT NULL, -- Unique among active users, see idx_users_email_active
     password_hash VARCHAR(255) NOT NULL,
-    profile_pic VARCHAR(255) DEFAULT '', -- Stores relative path like 'users/uuid.jpg'
+    profile_pic VARCHAR(255) DEFAULT '', -- Stores relative path like 'users/uuid_512.jpg' (the largest variant)
+    profile_pic_variants JSONB NOT NULL DEFAULT '{}', -- Size in pixels -> path, e.g. {"64": "users/uuid_64.jpg"}
+    email_verified_at TIMESTAMPTZ, -- NULL until the user follows the verification link
+    role VARCHAR(20) NOT NULL DEFAULT 'user' CHECK (role IN ('user', 'editor', 'admin')),
+    token_version INTEGER NOT NULL DEFAULT 0, -- Bumped by "log out everywhere"
+    totp_secret VARCHAR(64) NOT NULL DEFAULT '', -- Base32 TOTP secret, set at enrolment
+    totp_enabled_at TIMESTAMPTZ, -- NULL until enrolment is confirmed with a first code
+    totp_last_step BIGINT NOT NULL DEFAULT 0, -- Time step of the last accepted code; older and equal ones are replays
+    deletion_scheduled_at TIMESTAMPTZ, -- Self-service closure takes effect then, unless the user logs in first
+    anonymized_at TIMESTAMPTZ, -- Set when a closed account is scrubbed; such rows are also soft-deleted but never purged
+    deleted_at TIMESTAMPTZ, -- Soft delete: set when the account is deleted, purged after the retention period
+    attributes JSONB NOT NULL DEFAULT '{}', -- Profile fields (phone, locale...) validated against user_attribute_schemas
     created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
     updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
 );
 
+-- Columns added to users since its first release. The CREATE TABLE above is skipped
+-- on existing databases, so each later column is also added here.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS token_version INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role VARCHAR(20) NOT NULL DEFAULT 'user' CHECK (role IN ('user', 'editor', 'admin'));
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified_at TIMESTAMPTZ;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_secret VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_enabled_at TIMESTAMPTZ;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_last_step BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+-- Email uniqueness moved to idx_users_email_active so deleted accounts don't hold on to their address
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_email_key;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deletion_scheduled_at TIMESTAMPTZ;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS anonymized_at TIMESTAMPTZ;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS attributes JSONB NOT NULL DEFAULT '{}';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS profile_pic_variants JSONB NOT NULL DEFAULT '{}';
+
 -- Products Table
 CREATE TABLE IF NOT EXISTS products (
     id SERIAL PRIMARY KEY,
@@ -25,9 +54,189 @@ CREATE TABLE IF NOT EXISTS products (
     updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
 );
 
+-- JSON Schema for users.attributes, managed by admins; the highest id is in force
+CREATE TABLE IF NOT EXISTS user_attribute_schemas (
+    id SERIAL PRIMARY KEY,
+    schema JSONB NOT NULL,
+    created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Login Sessions (one per login per device)
+-- The session ID doubles as the refresh token family ID and is carried in access tokens as 'sid'.
+CREATE TABLE IF NOT EXISTS sessions (
+    id UUID PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    user_agent VARCHAR(512) NOT NULL DEFAULT '',
+    ip VARCHAR(45) NOT NULL DEFAULT '', -- Last IP the session was seen from
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_seen_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    revoked_at TIMESTAMPTZ
+);
+
+-- Refresh Tokens Table
+-- Each login starts a new token family; every rotation adds a row to the same family.
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    family_id UUID NOT NULL,
+    token_hash CHAR(64) UNIQUE NOT NULL, -- SHA-256 hex digest, the raw token is never stored
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMPTZ, -- Set once the token has been rotated
+    revoked_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Revoked Access Tokens (jti denylist)
+-- Rows only need to live until the token would have expired anyway.
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+    jti UUID PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    expires_at TIMESTAMPTZ NOT NULL,
+    revoked_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Single-use User Tokens (password reset links, etc.)
+CREATE TABLE IF NOT EXISTS user_tokens (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    purpose VARCHAR(50) NOT NULL, -- e.g. 'password_reset'
+    token_hash CHAR(64) UNIQUE NOT NULL, -- SHA-256 hex digest, the raw token is only sent by email
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Two-factor Recovery Codes (one-time use)
+CREATE TABLE IF NOT EXISTS mfa_recovery_codes (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    code_hash CHAR(64) NOT NULL, -- SHA-256 hex digest
+    used_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- External Identities (OpenID Connect logins)
+-- Users are matched by provider + subject first, and by verified email only the first time.
+CREATE TABLE IF NOT EXISTS user_identities (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    provider VARCHAR(50) NOT NULL, -- Name from OIDC_PROVIDERS
+    subject VARCHAR(255) NOT NULL, -- The provider's 'sub' claim
+    email VARCHAR(255) NOT NULL,
+    last_login_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (provider, subject)
+);
+
+-- Passkeys (WebAuthn credentials)
+-- The user handle given to authenticators is the user's ID, so discoverable logins map straight back to users.
+CREATE TABLE IF NOT EXISTS webauthn_credentials (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL DEFAULT '', -- Chosen by the user to tell passkeys apart
+    credential_id BYTEA UNIQUE NOT NULL,
+    public_key BYTEA NOT NULL, -- COSE-encoded
+    attestation_type VARCHAR(32) NOT NULL DEFAULT '',
+    aaguid BYTEA, -- Identifies the authenticator model
+    sign_count BIGINT NOT NULL DEFAULT 0,
+    transports TEXT[] NOT NULL DEFAULT '{}', -- e.g. {'internal', 'hybrid'}
+    backup_eligible BOOLEAN NOT NULL DEFAULT FALSE, -- Synced passkey; never changes after registration
+    backup_state BOOLEAN NOT NULL DEFAULT FALSE,
+    last_used_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Pending WebAuthn Ceremonies
+-- Holds the challenge between the begin and finish calls; each row can be used once.
+CREATE TABLE IF NOT EXISTS webauthn_ceremonies (
+    token_hash CHAR(64) PRIMARY KEY, -- SHA-256 hex digest of the token handed to the client
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE, -- NULL for passkey logins
+    kind VARCHAR(20) NOT NULL CHECK (kind IN ('registration', 'login')),
+    session_data JSONB NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Service Account API Keys
+-- Keys look like 'wp_<prefix>_<secret>'; the prefix is kept in clear so admins can tell keys apart.
+CREATE TABLE IF NOT EXISTS api_keys (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    prefix VARCHAR(16) UNIQUE NOT NULL,
+    key_hash CHAR(64) UNIQUE NOT NULL, -- SHA-256 hex digest, the raw key is only shown once
+    scopes TEXT[] NOT NULL DEFAULT '{}', -- e.g. {'products:read', 'products:write'}
+    created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    expires_at TIMESTAMPTZ,
+    last_used_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Impersonation Audit Log
+-- One row when an admin starts impersonating a user and one per request made with that token.
+-- No foreign keys: the trail must outlive the accounts involved.
+CREATE TABLE IF NOT EXISTS impersonation_audit_log (
+    id BIGSERIAL PRIMARY KEY,
+    actor_id INTEGER NOT NULL, -- The admin
+    subject_id INTEGER NOT NULL, -- The impersonated user
+    token_id UUID NOT NULL, -- jti of the impersonation token
+    method VARCHAR(10) NOT NULL,
+    path TEXT NOT NULL,
+    status_code INTEGER, -- NULL until the request completes
+    ip VARCHAR(45),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Personal Data Exports
+-- Zips are written outside the upload directory and fetched with a token sent to the user.
+CREATE TABLE IF NOT EXISTS data_exports (
+    id UUID PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    status VARCHAR(10) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'ready', 'failed')),
+    filename VARCHAR(255) NOT NULL DEFAULT '', -- Relative to DATA_EXPORT_DIR
+    token_hash CHAR(64) UNIQUE NOT NULL, -- SHA-256 hex digest of the download token
+    error TEXT NOT NULL DEFAULT '',
+    expires_at TIMESTAMPTZ NOT NULL, -- The link and the file are removed after this
+    completed_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Failed Login Tracking
+-- Keyed by 'email:<address>' or 'ip:<address>' so accounts and clients are throttled independently.
+CREATE TABLE IF NOT EXISTS login_attempts (
+    key VARCHAR(320) PRIMARY KEY,
+    failures INTEGER NOT NULL DEFAULT 0,
+    last_failure_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    locked_until TIMESTAMPTZ
+);
+
 -- Optional: Indexes for performance
 CREATE INDEX IF NOT EXISTS idx_users_email ON users(email);
+-- A deleted account keeps its email until purged, so the address can be registered again meanwhile
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email_active ON users(email) WHERE deleted_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_users_deleted_at ON users(deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_users_deletion_scheduled_at ON users(deletion_scheduled_at) WHERE deletion_scheduled_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_users_name_id ON users(name, id); -- Keyset pagination of GET /users
+CREATE INDEX IF NOT EXISTS idx_users_created_at_id ON users(created_at, id);
 CREATE INDEX IF NOT EXISTS idx_products_description ON products(description);
+CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions(user_id);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family_id ON refresh_tokens(family_id);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user_id ON refresh_tokens(user_id);
+CREATE INDEX IF NOT EXISTS idx_revoked_tokens_expires_at ON revoked_tokens(expires_at);
+CREATE INDEX IF NOT EXISTS idx_user_tokens_user_id_purpose ON user_tokens(user_id, purpose);
+CREATE INDEX IF NOT EXISTS idx_mfa_recovery_codes_user_id ON mfa_recovery_codes(user_id);
+CREATE INDEX IF NOT EXISTS idx_user_identities_user_id ON user_identities(user_id);
+CREATE INDEX IF NOT EXISTS idx_webauthn_credentials_user_id ON webauthn_credentials(user_id);
+CREATE INDEX IF NOT EXISTS idx_webauthn_ceremonies_expires_at ON webauthn_ceremonies(expires_at);
+CREATE INDEX IF NOT EXISTS idx_data_exports_user_id ON data_exports(user_id);
+-- One export in progress per user. Duplicates left by the old read-then-insert check are failed first.
+UPDATE data_exports SET status = 'failed', error = 'superseded', completed_at = NOW()
+WHERE status = 'pending' AND id NOT IN (SELECT DISTINCT ON (user_id) id FROM data_exports WHERE status = 'pending' ORDER BY user_id, created_at DESC);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_data_exports_user_pending ON data_exports(user_id) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_data_exports_expires_at ON data_exports(expires_at);
+CREATE INDEX IF NOT EXISTS idx_impersonation_audit_log_created_at ON impersonation_audit_log(created_at);
+CREATE INDEX IF NOT EXISTS idx_impersonation_audit_log_subject_id ON impersonation_audit_log(subject_id);
 
 -- TODO: Add trigger function to automatically update updated_at timestamp
 CREATE OR REPLACE FUNCTION trigger_set_timestamp()
@@ -0,0 +1,369 @@
+// Package exports builds personal data exports: a zip of everything stored
+// about a user, produced in the background and downloaded through a link
+// that stops working once the export expires.
+package exports
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Eduardo-Barreto/web-ponderada/backend/auth"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/config"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/mailer"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/models"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/repository"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrExportInProgress = errors.New("a data export is already being prepared")
+	ErrExportNotFound   = errors.New("download link is invalid or has expired")
+)
+
+// Service starts exports and hands out their files
+type Service struct {
+	UserRepo     repository.UserRepository
+	ExportRepo   repository.DataExportRepository
+	SessionRepo  repository.SessionRepository
+	AuditRepo    repository.ImpersonationAuditRepository
+	IdentityRepo repository.IdentityRepository
+	WebAuthnRepo repository.WebAuthnRepository
+	FileRepo     repository.StorageRepository
+	Mailer       mailer.Mailer
+	dir          string
+}
+
+// NewService writes exports to DATA_EXPORT_DIR, which must not be publicly served
+func NewService(userRepo repository.UserRepository, exportRepo repository.DataExportRepository, sessionRepo repository.SessionRepository,
+	auditRepo repository.ImpersonationAuditRepository, identityRepo repository.IdentityRepository, webAuthnRepo repository.WebAuthnRepository,
+	fileRepo repository.StorageRepository, m mailer.Mailer) *Service {
+	dir := config.AppConfig.DataExportDir
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		log.Fatalf("Failed to create data export directory: %v", err)
+	}
+	return &Service{
+		UserRepo:     userRepo,
+		ExportRepo:   exportRepo,
+		SessionRepo:  sessionRepo,
+		AuditRepo:    auditRepo,
+		IdentityRepo: identityRepo,
+		WebAuthnRepo: webAuthnRepo,
+		FileRepo:     fileRepo,
+		Mailer:       m,
+		dir:          dir,
+	}
+}
+
+// DownloadURL is the link that fetches the export issued with the given token
+func DownloadURL(token string) string {
+	return config.AppConfig.PublicURL + "/api/v1/exports/download?token=" + url.QueryEscape(token)
+}
+
+// Request starts building an export of the user's data in the background and
+// returns it along with the token for its download link. The user is also
+// emailed the link once the zip is ready.
+func (s *Service) Request(ctx context.Context, userID int) (*models.DataExport, string, error) {
+	token, err := auth.GenerateOpaqueToken()
+	if err != nil {
+		return nil, "", err
+	}
+	export := &models.DataExport{
+		ID:        uuid.New().String(),
+		UserID:    userID,
+		Status:    models.DataExportPending,
+		TokenHash: auth.HashToken(token),
+		ExpiresAt: time.Now().Add(config.AppConfig.DataExportTTL),
+	}
+	if err := s.ExportRepo.CreateExport(ctx, export); err != nil {
+		if strings.Contains(err.Error(), "unique constraint") {
+			return nil, "", ErrExportInProgress
+		}
+		return nil, "", err
+	}
+
+	go s.build(export, token)
+	return export, token, nil
+}
+
+// Open returns the export a download token belongs to and the path of its zip
+func (s *Service) Open(ctx context.Context, token string) (*models.DataExport, string, error) {
+	export, err := s.ExportRepo.GetReadyExportByTokenHash(ctx, auth.HashToken(token))
+	if err != nil {
+		if err.Error() == "export not ready or expired" {
+			return nil, "", ErrExportNotFound
+		}
+		return nil, "", err
+	}
+	return export, filepath.Join(s.dir, export.Filename), nil
+}
+
+// Prune removes expired exports and their files
+func (s *Service) Prune(ctx context.Context) (int, error) {
+	expired, err := s.ExportRepo.DeleteExpiredExports(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for _, export := range expired {
+		if export.Filename == "" {
+			continue
+		}
+		if err := os.Remove(filepath.Join(s.dir, export.Filename)); err != nil && !os.IsNotExist(err) {
+			log.Printf("Warning: Failed to delete data export file %s: %v", export.Filename, err)
+		}
+	}
+	return len(expired), nil
+}
+
+// DeleteUserExports removes every export of a user along with its file,
+// before the account is purged or anonymised. Files go first, so if one
+// cannot be removed everything is left in place to be retried.
+func (s *Service) DeleteUserExports(ctx context.Context, userID int) error {
+	userExports, err := s.ExportRepo.GetUserExports(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, export := range userExports {
+		// A pending build writes to this name; completing it fails once the row is gone
+		filename := export.Filename
+		if filename == "" {
+			filename = export.ID + ".zip"
+		}
+		if err := os.Remove(filepath.Join(s.dir, filename)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to delete data export file %s: %w", filename, err)
+		}
+	}
+	return s.ExportRepo.DeleteUserExports(ctx, userID)
+}
+
+func (s *Service) build(export *models.DataExport, token string) {
+	ctx := context.Background()
+
+	user, err := s.UserRepo.GetUserByID(ctx, export.UserID)
+	if err == nil {
+		err = s.writeZip(ctx, export, user)
+	}
+	if err == nil {
+		err = s.ExportRepo.CompleteExport(ctx, export.ID, export.ID+".zip")
+	}
+	if err != nil {
+		log.Printf("Error building data export %s for user %d: %v", export.ID, export.UserID, err)
+		os.Remove(filepath.Join(s.dir, export.ID+".zip"))
+		if err := s.ExportRepo.FailExport(ctx, export.ID, err.Error()); err != nil {
+			log.Printf("Error marking data export %s as failed: %v", export.ID, err)
+		}
+		return
+	}
+
+	log.Printf("Data export %s ready for user %d", export.ID, export.UserID)
+	mailer.SendAsync(s.Mailer, mailer.Message{
+		To:      user.Email,
+		Subject: "Your data export is ready",
+		Body: fmt.Sprintf("Hi %s,\n\nThe copy of your data you asked for is ready. Download it from the link below before %s:\n\n%s\n",
+			user.Name, export.ExpiresAt.UTC().Format(time.RFC1123), DownloadURL(token)),
+	})
+}
+
+// manifest describes the contents of an export; it is written last, as manifest.json
+type manifest struct {
+	ExportID    string         `json:"export_id"`
+	UserID      int            `json:"user_id"`
+	GeneratedAt time.Time      `json:"generated_at"`
+	Files       []manifestFile `json:"files"`
+	Notes       []string       `json:"notes"`
+}
+
+type manifestFile struct {
+	Path        string `json:"path"`
+	Description string `json:"description"`
+	Records     *int   `json:"records,omitempty"` // For JSON arrays
+	SHA256      string `json:"sha256"`
+}
+
+// Records without the internal fields models hide from API responses
+type sessionRecord struct {
+	ID         string     `json:"id"`
+	UserAgent  string     `json:"user_agent"`
+	IP         string     `json:"ip"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastSeenAt time.Time  `json:"last_seen_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+}
+
+type identityRecord struct {
+	Provider    string     `json:"provider"`
+	Subject     string     `json:"subject"`
+	Email       string     `json:"email"`
+	LastLoginAt *time.Time `json:"last_login_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// zipWriter adds files to the archive and records them in the manifest
+type zipWriter struct {
+	zip      *zip.Writer
+	manifest *manifest
+}
+
+func (w *zipWriter) add(name, description string, records *int, content io.Reader) error {
+	f, err := w.zip.Create(name)
+	if err != nil {
+		return fmt.Errorf("failed to add %s to export: %w", name, err)
+	}
+	digest := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(f, digest), content); err != nil {
+		return fmt.Errorf("failed to write %s to export: %w", name, err)
+	}
+	w.manifest.Files = append(w.manifest.Files, manifestFile{
+		Path:        name,
+		Description: description,
+		Records:     records,
+		SHA256:      hex.EncodeToString(digest.Sum(nil)),
+	})
+	return nil
+}
+
+func (w *zipWriter) addJSON(name, description string, records *int, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %w", name, err)
+	}
+	return w.add(name, description, records, bytes.NewReader(data))
+}
+
+func count(n int) *int { return &n }
+
+func (s *Service) writeZip(ctx context.Context, export *models.DataExport, user *models.User) error {
+	sessions, err := s.SessionRepo.GetUserSessions(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	auditEntries, err := s.AuditRepo.GetAuditEntriesForUser(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	identities, err := s.IdentityRepo.GetIdentitiesByUser(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	passkeys, err := s.WebAuthnRepo.GetCredentialsByUser(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(filepath.Join(s.dir, export.ID+".zip"), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create export file: %w", err)
+	}
+	defer file.Close()
+
+	w := &zipWriter{
+		zip: zip.NewWriter(file),
+		manifest: &manifest{
+			ExportID:    export.ID,
+			UserID:      user.ID,
+			GeneratedAt: time.Now().UTC(),
+			Notes: []string{
+				"user.json is your account record; the password hash and two-factor secret are not included.",
+				"Products are not linked to user accounts, so none are included.",
+			},
+		},
+	}
+
+	// models.User already leaves the password hash and TOTP secret out of its JSON
+	if err := w.addJSON("user.json", "Account record", nil, user); err != nil {
+		return err
+	}
+
+	if user.ProfilePic != "" {
+		if err := s.addProfilePic(w, user.ProfilePic); err != nil {
+			return err
+		}
+	}
+
+	sessionRecords := make([]sessionRecord, len(sessions))
+	for i, session := range sessions {
+		sessionRecords[i] = sessionRecord{
+			ID:         session.ID,
+			UserAgent:  session.UserAgent,
+			IP:         session.IP,
+			CreatedAt:  session.CreatedAt,
+			LastSeenAt: session.LastSeenAt,
+			RevokedAt:  session.RevokedAt,
+		}
+	}
+	if err := w.addJSON("sessions.json", "Login sessions, including ended ones", count(len(sessionRecords)), sessionRecords); err != nil {
+		return err
+	}
+
+	if err := w.addJSON("audit_events.json", "Impersonation audit events where you were the admin or the impersonated user",
+		count(len(auditEntries)), auditEntries); err != nil {
+		return err
+	}
+
+	identityRecords := make([]identityRecord, len(identities))
+	for i, identity := range identities {
+		identityRecords[i] = identityRecord{
+			Provider:    identity.Provider,
+			Subject:     identity.Subject,
+			Email:       identity.Email,
+			LastLoginAt: identity.LastLoginAt,
+			CreatedAt:   identity.CreatedAt,
+		}
+	}
+	if err := w.addJSON("identities.json", "Linked single sign-on accounts", count(len(identityRecords)), identityRecords); err != nil {
+		return err
+	}
+
+	if err := w.addJSON("passkeys.json", "Registered passkeys (public keys are not included)", count(len(passkeys)), passkeys); err != nil {
+		return err
+	}
+
+	manifestData, err := json.MarshalIndent(w.manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	f, err := w.zip.Create("manifest.json")
+	if err != nil {
+		return fmt.Errorf("failed to add manifest to export: %w", err)
+	}
+	if _, err := f.Write(manifestData); err != nil {
+		return fmt.Errorf("failed to write manifest to export: %w", err)
+	}
+
+	if err := w.zip.Close(); err != nil {
+		return fmt.Errorf("failed to finish export zip: %w", err)
+	}
+	return file.Close()
+}
+
+// addProfilePic copies the picture from storage; a missing file is noted
+// in the manifest rather than failing the whole export
+func (s *Service) addProfilePic(w *zipWriter, filename string) error {
+	fullPath := s.FileRepo.GetFilePath(filename)
+	if fullPath == "" {
+		return fmt.Errorf("invalid profile picture path %q", filename)
+	}
+	picture, err := os.Open(fullPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			w.manifest.Notes = append(w.manifest.Notes, "Your profile picture could not be found in storage.")
+			return nil
+		}
+		return fmt.Errorf("failed to open profile picture: %w", err)
+	}
+	defer picture.Close()
+	return w.add("profile_picture/"+path.Base(filepath.ToSlash(filename)), "Profile picture", nil, picture)
+}
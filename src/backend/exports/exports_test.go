@@ -0,0 +1,102 @@
+package exports
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Eduardo-Barreto/web-ponderada/backend/config"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/models"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/repository"
+)
+
+// fakeExportRepo keeps exports in memory; unused methods panic
+type fakeExportRepo struct {
+	repository.DataExportRepository
+	exports   []models.DataExport
+	createErr error
+}
+
+func (r *fakeExportRepo) CreateExport(ctx context.Context, export *models.DataExport) error {
+	if r.createErr != nil {
+		return r.createErr
+	}
+	r.exports = append(r.exports, *export)
+	return nil
+}
+
+func (r *fakeExportRepo) GetUserExports(ctx context.Context, userID int) ([]models.DataExport, error) {
+	var found []models.DataExport
+	for _, export := range r.exports {
+		if export.UserID == userID {
+			found = append(found, export)
+		}
+	}
+	return found, nil
+}
+
+func (r *fakeExportRepo) DeleteUserExports(ctx context.Context, userID int) error {
+	kept := r.exports[:0]
+	for _, export := range r.exports {
+		if export.UserID != userID {
+			kept = append(kept, export)
+		}
+	}
+	r.exports = kept
+	return nil
+}
+
+func newTestService(t *testing.T, repo *fakeExportRepo) *Service {
+	t.Helper()
+	config.AppConfig = &config.Config{DataExportDir: t.TempDir()}
+	return NewService(nil, repo, nil, nil, nil, nil, nil, nil)
+}
+
+func TestRequestWhileAnotherExportIsPending(t *testing.T) {
+	// What the partial unique index on pending exports makes Postgres return
+	repo := &fakeExportRepo{createErr: errors.New(`failed to create data export: ERROR: duplicate key value violates unique constraint "idx_data_exports_user_pending" (SQLSTATE 23505)`)}
+	service := newTestService(t, repo)
+
+	if _, _, err := service.Request(context.Background(), 1); !errors.Is(err, ErrExportInProgress) {
+		t.Errorf("got error %v, want ErrExportInProgress", err)
+	}
+}
+
+func TestDeleteUserExportsRemovesFiles(t *testing.T) {
+	repo := &fakeExportRepo{exports: []models.DataExport{
+		{ID: "ready", UserID: 1, Status: models.DataExportReady, Filename: "ready.zip"},
+		{ID: "building", UserID: 1, Status: models.DataExportPending}, // Zip written, row not completed yet
+		{ID: "failed", UserID: 1, Status: models.DataExportFailed},    // Never had a file
+		{ID: "other", UserID: 2, Status: models.DataExportReady, Filename: "other.zip"},
+	}}
+	service := newTestService(t, repo)
+	for _, name := range []string{"ready.zip", "building.zip", "other.zip"} {
+		if err := os.WriteFile(filepath.Join(service.dir, name), []byte("zip"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := service.DeleteUserExports(context.Background(), 1); err != nil {
+		t.Fatalf("DeleteUserExports: %v", err)
+	}
+
+	tests := []struct {
+		file     string
+		wantKept bool
+	}{
+		{"ready.zip", false},
+		{"building.zip", false},
+		{"other.zip", true},
+	}
+	for _, tt := range tests {
+		_, err := os.Stat(filepath.Join(service.dir, tt.file))
+		if kept := err == nil; kept != tt.wantKept {
+			t.Errorf("%s: kept = %v, want %v", tt.file, kept, tt.wantKept)
+		}
+	}
+	if len(repo.exports) != 1 || repo.exports[0].UserID != 2 {
+		t.Errorf("remaining exports = %+v, want only user 2's", repo.exports)
+	}
+}
@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Eduardo-Barreto/web-ponderada/backend/auth"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/config"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/models"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/repository"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// Most recent entries returned by GetImpersonationLog
+const impersonationLogLimit = 200
+
+// AdminHandler groups admin-only account maintenance actions
+type AdminHandler struct {
+	UserRepo  repository.UserRepository
+	Throttler *auth.LoginThrottler
+	AuditRepo repository.ImpersonationAuditRepository
+}
+
+func NewAdminHandler(userRepo repository.UserRepository, throttler *auth.LoginThrottler, auditRepo repository.ImpersonationAuditRepository) *AdminHandler {
+	return &AdminHandler{UserRepo: userRepo, Throttler: throttler, AuditRepo: auditRepo}
+}
+
+// UnlockUser clears failed login attempts and any lockout on a user's account
+func (h *AdminHandler) UnlockUser(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		utils.SendError(c, http.StatusBadRequest, "Invalid user ID format")
+		return
+	}
+
+	user, err := h.UserRepo.GetUserByID(context.Background(), id)
+	if err != nil {
+		if err.Error() == "user not found" {
+			utils.SendError(c, http.StatusNotFound, err.Error())
+		} else {
+			log.Printf("Error getting user by ID %d: %v", id, err)
+			utils.SendError(c, http.StatusInternalServerError, "Failed to retrieve user")
+		}
+		return
+	}
+
+	if err := h.Throttler.Unlock(context.Background(), user.Email); err != nil {
+		log.Printf("Error unlocking user ID %d: %v", id, err)
+		utils.SendError(c, http.StatusInternalServerError, "Failed to unlock user")
+		return
+	}
+
+	log.Printf("User %d unlocked by admin %d", id, c.GetInt("userID"))
+	c.JSON(http.StatusOK, gin.H{"message": "User account unlocked"})
+}
+
+// Impersonate issues a short-lived token that lets an admin see the API as
+// the given user. Every request made with it is recorded in the audit log.
+func (h *AdminHandler) Impersonate(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("userId"))
+	if err != nil {
+		utils.SendError(c, http.StatusBadRequest, "Invalid user ID format")
+		return
+	}
+
+	actorID := c.GetInt("userID")
+	if id == actorID {
+		utils.SendError(c, http.StatusBadRequest, "You cannot impersonate yourself")
+		return
+	}
+
+	user, err := h.UserRepo.GetUserByID(context.Background(), id)
+	if err != nil {
+		if err.Error() == "user not found" {
+			utils.SendError(c, http.StatusNotFound, err.Error())
+		} else {
+			log.Printf("Error getting user by ID %d: %v", id, err)
+			utils.SendError(c, http.StatusInternalServerError, "Failed to retrieve user")
+		}
+		return
+	}
+
+	// Acting as another admin would be a way around that admin's own 2FA and audit trail
+	if user.Role == models.RoleAdmin {
+		utils.SendError(c, http.StatusForbidden, "Admins cannot be impersonated")
+		return
+	}
+
+	token, claims, err := auth.GenerateImpersonationToken(user, actorID)
+	if err != nil {
+		log.Printf("Error generating impersonation token: %v", err)
+		utils.SendError(c, http.StatusInternalServerError, "Failed to start impersonation")
+		return
+	}
+
+	// The audit trail starts with issuing the token; without it, no token
+	status := http.StatusCreated
+	entry := &models.ImpersonationAuditEntry{
+		ActorID:    actorID,
+		SubjectID:  user.ID,
+		TokenID:    claims.ID,
+		Method:     c.Request.Method,
+		Path:       c.Request.URL.RequestURI(),
+		StatusCode: &status,
+		IP:         c.ClientIP(),
+	}
+	if _, err := h.AuditRepo.CreateAuditEntry(context.Background(), entry); err != nil {
+		log.Printf("Error auditing impersonation of user %d by admin %d: %v", user.ID, actorID, err)
+		utils.SendError(c, http.StatusInternalServerError, "Failed to start impersonation")
+		return
+	}
+
+	log.Printf("Admin %d started impersonating user %d (token %s)", actorID, user.ID, claims.ID)
+	c.JSON(status, gin.H{
+		"token":      token,
+		"token_type": "Bearer",
+		"expires_in": int(config.AppConfig.ImpersonationTTL.Seconds()),
+		"actor_id":   actorID,
+		"subject_id": user.ID,
+	})
+}
+
+// GetImpersonationLog lists the most recent impersonation audit entries,
+// optionally only those for one user (?subject_id=)
+func (h *AdminHandler) GetImpersonationLog(c *gin.Context) {
+	subjectID := 0
+	if value := c.Query("subject_id"); value != "" {
+		id, err := strconv.Atoi(value)
+		if err != nil {
+			utils.SendError(c, http.StatusBadRequest, "Invalid subject_id format")
+			return
+		}
+		subjectID = id
+	}
+
+	entries, err := h.AuditRepo.GetAuditEntries(context.Background(), subjectID, impersonationLogLimit)
+	if err != nil {
+		log.Printf("Error getting impersonation log: %v", err)
+		utils.SendError(c, http.StatusInternalServerError, "Failed to retrieve impersonation log")
+		return
+	}
+
+	c.JSON(http.StatusOK, entries)
+}
+
+// deletedUser is a soft-deleted user along with when it will be purged
+type deletedUser struct {
+	models.User
+	PurgeAt time.Time `json:"purge_at"`
+}
+
+// GetDeletedUsers lists soft-deleted users that can still be restored
+func (h *AdminHandler) GetDeletedUsers(c *gin.Context) {
+	users, err := h.UserRepo.GetDeletedUsers(context.Background(), time.Now())
+	if err != nil {
+		log.Printf("Error getting deleted users: %v", err)
+		utils.SendError(c, http.StatusInternalServerError, "Failed to retrieve deleted users")
+		return
+	}
+
+	response := make([]deletedUser, len(users))
+	for i, user := range users {
+		response[i] = deletedUser{User: user, PurgeAt: user.DeletedAt.Add(config.AppConfig.DeletedUserRetention)}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// RestoreUser brings back a soft-deleted user that has not been purged yet.
+// Tokens issued before the deletion stay invalid.
+func (h *AdminHandler) RestoreUser(c *gin.Context) {
+	id, ok := userIDParam(c)
+	if !ok {
+		return
+	}
+
+	if err := h.UserRepo.RestoreUser(context.Background(), id); err != nil {
+		if err.Error() == "deleted user not found" {
+			utils.SendError(c, http.StatusNotFound, err.Error())
+		} else if strings.Contains(err.Error(), "unique constraint") {
+			utils.SendError(c, http.StatusConflict, "Email address is now used by another account")
+		} else {
+			log.Printf("Error restoring user ID %d: %v", id, err)
+			utils.SendError(c, http.StatusInternalServerError, "Failed to restore user")
+		}
+		return
+	}
+
+	log.Printf("User %d restored by admin %d", id, c.GetInt("userID"))
+	c.JSON(http.StatusOK, gin.H{"message": "User restored successfully"})
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Eduardo-Barreto/web-ponderada/backend/auth"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/models"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/repository"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// APIKeyHandler manages service account API keys (admin only)
+type APIKeyHandler struct {
+	APIKeyRepo repository.APIKeyRepository
+}
+
+func NewAPIKeyHandler(apiKeyRepo repository.APIKeyRepository) *APIKeyHandler {
+	return &APIKeyHandler{APIKeyRepo: apiKeyRepo}
+}
+
+// CreateAPIKey issues a new key. The raw key is only returned here.
+func (h *APIKeyHandler) CreateAPIKey(c *gin.Context) {
+	var input models.APIKeyInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.SendError(c, http.StatusBadRequest, "Invalid input: "+err.Error())
+		return
+	}
+
+	for _, scope := range input.Scopes {
+		if !auth.IsValidAPIKeyScope(scope) {
+			utils.SendError(c, http.StatusBadRequest, "Invalid scope: "+scope)
+			return
+		}
+	}
+	if input.ExpiresAt != nil && input.ExpiresAt.Before(time.Now()) {
+		utils.SendError(c, http.StatusBadRequest, "expires_at must be in the future")
+		return
+	}
+
+	rawKey, prefix, err := auth.GenerateAPIKey()
+	if err != nil {
+		log.Printf("Error generating api key: %v", err)
+		utils.SendError(c, http.StatusInternalServerError, "Failed to create API key")
+		return
+	}
+
+	adminID := c.GetInt("userID")
+	apiKey := &models.APIKey{
+		Name:      input.Name,
+		Prefix:    prefix,
+		KeyHash:   auth.HashToken(rawKey),
+		Scopes:    input.Scopes,
+		CreatedBy: &adminID,
+		ExpiresAt: input.ExpiresAt,
+	}
+	if _, err := h.APIKeyRepo.CreateAPIKey(context.Background(), apiKey); err != nil {
+		log.Printf("Error storing api key: %v", err)
+		utils.SendError(c, http.StatusInternalServerError, "Failed to create API key")
+		return
+	}
+
+	log.Printf("API key %d (%s) created by admin %d with scopes %v", apiKey.ID, apiKey.Prefix, adminID, apiKey.Scopes)
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "API key created. Store it now, it cannot be shown again",
+		"key":     rawKey,
+		"api_key": apiKey,
+	})
+}
+
+// GetAPIKeys lists every key, including revoked ones, without their secrets
+func (h *APIKeyHandler) GetAPIKeys(c *gin.Context) {
+	keys, err := h.APIKeyRepo.GetAllAPIKeys(context.Background())
+	if err != nil {
+		log.Printf("Error getting api keys: %v", err)
+		utils.SendError(c, http.StatusInternalServerError, "Failed to retrieve API keys")
+		return
+	}
+	c.JSON(http.StatusOK, keys)
+}
+
+// RevokeAPIKey permanently disables a key
+func (h *APIKeyHandler) RevokeAPIKey(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		utils.SendError(c, http.StatusBadRequest, "Invalid API key ID format")
+		return
+	}
+
+	if err := h.APIKeyRepo.RevokeAPIKey(context.Background(), id); err != nil {
+		if err.Error() == "api key not found" {
+			utils.SendError(c, http.StatusNotFound, "API key not found or already revoked")
+		} else {
+			log.Printf("Error revoking api key %d: %v", id, err)
+			utils.SendError(c, http.StatusInternalServerError, "Failed to revoke API key")
+		}
+		return
+	}
+
+	log.Printf("API key %d revoked by admin %d", id, c.GetInt("userID"))
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked"})
+}
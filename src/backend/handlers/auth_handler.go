@@ -2,22 +2,31 @@ package handlers
 
 import (
 	"context"
+	"errors"
+	"io"
 	"log"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"github.com/Eduardo-Barreto/web-ponderada/backend/auth"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/config"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/mailer"
 	"github.com/Eduardo-Barreto/web-ponderada/backend/models"
 	"github.com/Eduardo-Barreto/web-ponderada/backend/repository"
 	"github.com/Eduardo-Barreto/web-ponderada/backend/utils"
 )
 
 type AuthHandler struct {
-	UserRepo repository.UserRepository
+	UserRepo    repository.UserRepository
+	Tokens      *auth.TokenIssuer
+	Revocations *auth.RevocationStore
+	Mailer      mailer.Mailer
+	Throttler   *auth.LoginThrottler
+	Passkeys    *auth.PasskeyService
 }
 
-func NewAuthHandler(userRepo repository.UserRepository) *AuthHandler {
-	return &AuthHandler{UserRepo: userRepo}
+func NewAuthHandler(userRepo repository.UserRepository, tokens *auth.TokenIssuer, revocations *auth.RevocationStore, m mailer.Mailer, throttler *auth.LoginThrottler, passkeys *auth.PasskeyService) *AuthHandler {
+	return &AuthHandler{UserRepo: userRepo, Tokens: tokens, Revocations: revocations, Mailer: m, Throttler: throttler, Passkeys: passkeys}
 }
 
 // Register handles user registration
@@ -28,6 +37,10 @@ func (h *AuthHandler) Register(c *gin.Context) {
 		return
 	}
 
+	if !checkPasswordPolicy(c, "password", input.Password, input.Name, input.Email) {
+		return
+	}
+
 	// Check if user already exists
 	existingUser, err := h.UserRepo.GetUserByEmail(context.Background(), input.Email)
 	if err != nil {
@@ -54,6 +67,7 @@ func (h *AuthHandler) Register(c *gin.Context) {
 		Name:     input.Name,
 		Email:    input.Email,
 		Password: hashedPassword, // Store the hash
+		Role:     models.RoleUser,
 		// ProfilePic is initially empty or default
 	}
 
@@ -69,6 +83,11 @@ func (h *AuthHandler) Register(c *gin.Context) {
 	newUser.Password = ""
 	newUser.ID = userID // Set the returned ID
 
+	// Registration succeeds even if the email can't be prepared; the user can ask for a resend
+	if err := sendVerificationEmail(h.Mailer, newUser); err != nil {
+		log.Printf("Error preparing verification email for user %d: %v", userID, err)
+	}
+
 	c.JSON(http.StatusCreated, gin.H{"message": "User registered successfully", "user": newUser})
 }
 
@@ -80,6 +99,19 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
+	// Refuse early if this account or client is backing off or locked out
+	clientIP := c.ClientIP()
+	wait, err := h.Throttler.Check(context.Background(), input.Email, clientIP)
+	if err != nil {
+		log.Printf("Error checking login throttle: %v", err)
+		utils.SendError(c, http.StatusInternalServerError, "Error during login")
+		return
+	}
+	if wait > 0 {
+		utils.SendRateLimited(c, wait, "Too many failed login attempts, try again later")
+		return
+	}
+
 	// Find user by email
 	user, err := h.UserRepo.GetUserByEmail(context.Background(), input.Email)
 	if err != nil {
@@ -87,24 +119,189 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		utils.SendError(c, http.StatusInternalServerError, "Error during login")
 		return
 	}
-	if user == nil {
+
+	// Check password (unknown emails count as failures too)
+	if user == nil || !auth.CheckPasswordHash(input.Password, user.Password) {
+		if err := h.Throttler.RecordFailure(context.Background(), input.Email, clientIP); err != nil {
+			log.Printf("Error recording failed login: %v", err)
+		}
 		utils.SendError(c, http.StatusUnauthorized, "Invalid email or password")
 		return
 	}
 
-	// Check password
-	if !auth.CheckPasswordHash(input.Password, user.Password) {
-		utils.SendError(c, http.StatusUnauthorized, "Invalid email or password")
+	// Only now is the plain password at hand to move old bcrypt hashes, or
+	// ones with outdated parameters, to the current argon2id settings
+	if auth.NeedsRehash(user.Password) {
+		h.upgradePasswordHash(user, input.Password)
+	}
+
+	if config.AppConfig.RequireEmailVerification && user.EmailVerifiedAt == nil {
+		utils.SendError(c, http.StatusForbidden, "Email address not verified")
+		return
+	}
+
+	// With 2FA enabled the password alone only earns a short-lived challenge
+	// token; failures are cleared once the second factor succeeds
+	if user.TOTPEnabledAt != nil {
+		mfaToken, err := auth.GenerateMFAPendingToken(user)
+		if err != nil {
+			log.Printf("Error generating MFA token: %v", err)
+			utils.SendError(c, http.StatusInternalServerError, "Failed to login")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"mfa_required": true,
+			"mfa_token":    mfaToken,
+			"expires_in":   int(config.AppConfig.MFAPendingTTL.Seconds()),
+		})
 		return
 	}
 
-	// Generate JWT token
-	token, err := auth.GenerateToken(user.ID, user.Email)
+	if err := h.Throttler.RecordSuccess(context.Background(), input.Email); err != nil {
+		log.Printf("Error clearing failed logins for user %d: %v", user.ID, err)
+	}
+
+	// Issue a short-lived access token plus a refresh token
+	tokens, err := h.Tokens.Issue(context.Background(), user, clientInfo(c))
 	if err != nil {
-		log.Printf("Error generating JWT token: %v", err)
+		log.Printf("Error issuing tokens: %v", err)
 		utils.SendError(c, http.StatusInternalServerError, "Failed to login")
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"token": token})
+	response, err := tokenResponse(c, tokens)
+	if err != nil {
+		log.Printf("Error setting session cookies: %v", err)
+		utils.SendError(c, http.StatusInternalServerError, "Failed to login")
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// upgradePasswordHash rehashes a verified password. Failures only delay the
+// upgrade to the next login, so they never fail the login itself.
+func (h *AuthHandler) upgradePasswordHash(user *models.User, password string) {
+	newHash, err := auth.HashPassword(password)
+	if err != nil {
+		log.Printf("Error rehashing password for user %d: %v", user.ID, err)
+		return
+	}
+	if err := h.UserRepo.UpgradePasswordHash(context.Background(), user.ID, user.Password, newHash); err != nil {
+		log.Printf("Error storing upgraded password hash for user %d: %v", user.ID, err)
+		return
+	}
+	user.Password = newHash
+}
+
+// Refresh exchanges a refresh token for a new access/refresh token pair
+func (h *AuthHandler) Refresh(c *gin.Context) {
+	var input models.RefreshInput
+	// Body is optional for cookie sessions; ignore EOF from an empty request
+	if err := c.ShouldBindJSON(&input); err != nil && !errors.Is(err, io.EOF) {
+		utils.SendError(c, http.StatusBadRequest, "Invalid input: "+err.Error())
+		return
+	}
+
+	refreshToken := input.RefreshToken
+	if refreshToken == "" {
+		cookie, ok := refreshTokenCookie(c)
+		if !ok {
+			return
+		}
+		refreshToken = cookie
+	}
+	if refreshToken == "" {
+		utils.SendError(c, http.StatusBadRequest, "Invalid input: refresh_token is required")
+		return
+	}
+
+	tokens, err := h.Tokens.Rotate(context.Background(), refreshToken, clientInfo(c))
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrInvalidRefreshToken),
+			errors.Is(err, auth.ErrRefreshTokenExpired),
+			errors.Is(err, auth.ErrRefreshTokenReused):
+			utils.SendError(c, http.StatusUnauthorized, err.Error())
+		default:
+			log.Printf("Error rotating refresh token: %v", err)
+			utils.SendError(c, http.StatusInternalServerError, "Failed to refresh token")
+		}
+		return
+	}
+
+	response, err := tokenResponse(c, tokens)
+	if err != nil {
+		log.Printf("Error setting session cookies: %v", err)
+		utils.SendError(c, http.StatusInternalServerError, "Failed to refresh token")
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Logout ends the session of the access token used for this request and, if
+// provided, the refresh token family it came from
+func (h *AuthHandler) Logout(c *gin.Context) {
+	var input models.LogoutInput
+	// Body is optional; ignore EOF from an empty request
+	if err := c.ShouldBindJSON(&input); err != nil && !errors.Is(err, io.EOF) {
+		utils.SendError(c, http.StatusBadRequest, "Invalid input: "+err.Error())
+		return
+	}
+
+	claims := c.MustGet("tokenClaims").(*auth.Claims)
+	if err := h.Revocations.Revoke(context.Background(), claims); err != nil {
+		log.Printf("Error revoking token for user %d: %v", claims.UserID, err)
+		utils.SendError(c, http.StatusInternalServerError, "Failed to logout")
+		return
+	}
+
+	if claims.SessionID != "" {
+		err := h.Tokens.RevokeSession(context.Background(), claims.UserID, claims.SessionID)
+		if err != nil && err.Error() != "session not found" {
+			log.Printf("Error revoking session for user %d: %v", claims.UserID, err)
+			utils.SendError(c, http.StatusInternalServerError, "Failed to logout")
+			return
+		}
+	}
+
+	if input.RefreshToken == "" && c.GetBool("cookieAuth") {
+		input.RefreshToken, _ = c.Cookie(auth.RefreshTokenCookie)
+	}
+	if input.RefreshToken != "" {
+		err := h.Tokens.RevokeFamily(context.Background(), input.RefreshToken)
+		if err != nil && !errors.Is(err, auth.ErrInvalidRefreshToken) {
+			log.Printf("Error revoking refresh token for user %d: %v", claims.UserID, err)
+			utils.SendError(c, http.StatusInternalServerError, "Failed to logout")
+			return
+		}
+	}
+
+	clearSessionCookies(c)
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
+}
+
+// LogoutAll invalidates every access and refresh token issued to the user
+func (h *AuthHandler) LogoutAll(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	if err := h.Tokens.RevokeAllForUser(context.Background(), userID); err != nil {
+		log.Printf("Error revoking all tokens for user %d: %v", userID, err)
+		utils.SendError(c, http.StatusInternalServerError, "Failed to logout from all devices")
+		return
+	}
+
+	clearSessionCookies(c)
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out from all devices"})
+}
+
+// JWKS publishes the public keys that verify our tokens (RFC 7517)
+func (h *AuthHandler) JWKS(c *gin.Context) {
+	// Short enough that a newly added key is picked up well before it signs anything
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, gin.H{"keys": auth.JWKS()})
+}
+
+// clientInfo describes the device making the request, for session records
+func clientInfo(c *gin.Context) auth.ClientInfo {
+	return auth.ClientInfo{UserAgent: c.Request.UserAgent(), IP: c.ClientIP()}
 }
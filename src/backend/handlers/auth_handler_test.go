@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Eduardo-Barreto/web-ponderada/backend/auth"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/models"
+	"github.com/gin-gonic/gin"
+)
+
+func TestRefreshTokenReuseEndsSession(t *testing.T) {
+	setupTestAuth()
+	users := newFakeUserRepo(models.User{ID: 2, Name: "Maria", Email: "maria@example.com", Role: models.RoleUser})
+	sessions := &fakeSessionRepo{}
+	revocations := auth.NewRevocationStore(&fakeRevocationRepo{}, users, sessions, 0)
+	tokens := auth.NewTokenIssuer(users, &fakeRefreshTokenRepo{}, sessions, revocations)
+	auth.SetRevocationStore(revocations)
+	t.Cleanup(func() { auth.SetRevocationStore(nil) })
+
+	router := gin.New()
+	router.POST("/auth/refresh", NewAuthHandler(users, tokens, revocations, nil, nil, nil).Refresh)
+	refresh := func(refreshToken string) (int, auth.TokenPair) {
+		body, _ := json.Marshal(models.RefreshInput{RefreshToken: refreshToken})
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/auth/refresh", bytes.NewReader(body)))
+		var pair auth.TokenPair
+		json.Unmarshal(rec.Body.Bytes(), &pair)
+		return rec.Code, pair
+	}
+
+	maria, _ := users.GetUserByID(context.Background(), 2)
+	stolen, err := tokens.Issue(context.Background(), maria, auth.ClientInfo{})
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+
+	// The attacker rotates the stolen refresh token first
+	code, attackers := refresh(stolen.RefreshToken)
+	if code != http.StatusOK {
+		t.Fatalf("first rotation: got status %d", code)
+	}
+	if _, err := auth.ValidateToken(attackers.AccessToken); err != nil {
+		t.Fatalf("rotated access token rejected before the reuse: %v", err)
+	}
+
+	// The victim's client then presents the same token again
+	if code, _ := refresh(stolen.RefreshToken); code != http.StatusUnauthorized {
+		t.Fatalf("reused refresh token: got status %d, want %d", code, http.StatusUnauthorized)
+	}
+	if _, err := auth.ValidateToken(attackers.AccessToken); err == nil {
+		t.Error("the access token from the last rotation still works after the reuse")
+	}
+	if code, _ := refresh(attackers.RefreshToken); code != http.StatusUnauthorized {
+		t.Errorf("refresh token from the last rotation: got status %d, want %d", code, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/Eduardo-Barreto/web-ponderada/backend/exports"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// ExportHandler lets users download a copy of the data stored about them
+type ExportHandler struct {
+	Exports *exports.Service
+}
+
+func NewExportHandler(exportService *exports.Service) *ExportHandler {
+	return &ExportHandler{Exports: exportService}
+}
+
+// RequestExport starts building the logged-in user's data export. The zip is
+// built in the background; the download link is returned here and emailed
+// once it is ready.
+func (h *ExportHandler) RequestExport(c *gin.Context) {
+	userID := c.GetInt("userID")
+	export, token, err := h.Exports.Request(context.Background(), userID)
+	if err != nil {
+		if errors.Is(err, exports.ErrExportInProgress) {
+			utils.SendError(c, http.StatusConflict, err.Error())
+		} else {
+			log.Printf("Error requesting data export for user %d: %v", userID, err)
+			utils.SendError(c, http.StatusInternalServerError, "Failed to start data export")
+		}
+		return
+	}
+
+	log.Printf("Data export %s requested by user %d", export.ID, userID)
+	c.JSON(http.StatusAccepted, gin.H{
+		"export":       export,
+		"download_url": exports.DownloadURL(token), // Works once the status is "ready"
+		"message":      "Your export is being prepared. We will email you the download link when it is ready.",
+	})
+}
+
+// GetExport reports the status of one of the logged-in user's exports
+func (h *ExportHandler) GetExport(c *gin.Context) {
+	id := c.Param("id")
+	if _, err := uuid.Parse(id); err != nil {
+		utils.SendError(c, http.StatusBadRequest, "Invalid export ID format")
+		return
+	}
+
+	userID := c.GetInt("userID")
+	export, err := h.Exports.ExportRepo.GetExport(context.Background(), userID, id)
+	if err != nil {
+		if err.Error() == "export not found" {
+			utils.SendError(c, http.StatusNotFound, "Export not found")
+		} else {
+			log.Printf("Error getting data export %s for user %d: %v", id, userID, err)
+			utils.SendError(c, http.StatusInternalServerError, "Failed to retrieve export")
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, export)
+}
+
+// DownloadExport serves an export zip. The token in the link is the only
+// credential, so the link works straight from the email.
+func (h *ExportHandler) DownloadExport(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		utils.SendError(c, http.StatusBadRequest, "Download token is required")
+		return
+	}
+
+	export, path, err := h.Exports.Open(context.Background(), token)
+	if err != nil {
+		if errors.Is(err, exports.ErrExportNotFound) {
+			utils.SendError(c, http.StatusNotFound, err.Error())
+		} else {
+			log.Printf("Error opening data export: %v", err)
+			utils.SendError(c, http.StatusInternalServerError, "Failed to retrieve export")
+		}
+		return
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		log.Printf("Error opening data export file %s: %v", path, err)
+		utils.SendError(c, http.StatusNotFound, exports.ErrExportNotFound.Error())
+		return
+	}
+
+	log.Printf("Data export %s downloaded by %s", export.ID, c.ClientIP())
+	c.Header("Cache-Control", "no-store")
+	c.FileAttachment(path, fmt.Sprintf("data-export-%s.zip", export.CreatedAt.Format(time.DateOnly)))
+}
@@ -0,0 +1,474 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/Eduardo-Barreto/web-ponderada/backend/auth"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/config"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/models"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/repository"
+)
+
+// In-memory repositories for handler tests. Each fake embeds its interface,
+// so calling a method a test did not need panics instead of passing silently.
+
+type fakeUserRepo struct {
+	repository.UserRepository
+
+	mu        sync.Mutex
+	users     map[int]*models.User
+	totpSteps map[int]int64 // users.totp_last_step
+}
+
+func newFakeUserRepo(users ...models.User) *fakeUserRepo {
+	r := &fakeUserRepo{users: make(map[int]*models.User)}
+	for i := range users {
+		user := users[i]
+		r.users[user.ID] = &user
+	}
+	return r
+}
+
+func (r *fakeUserRepo) GetUserByID(ctx context.Context, id int) (*models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user, ok := r.users[id]
+	if !ok || user.DeletedAt != nil {
+		return nil, errors.New("user not found")
+	}
+	copied := *user
+	return &copied, nil
+}
+
+// GetUsers ignores filters and sorting, returning every active user by ID
+func (r *fakeUserRepo) GetUsers(ctx context.Context, q *models.UserListQuery) ([]models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	users := []models.User{}
+	for id := 1; len(users) < q.Limit && id <= len(r.users); id++ {
+		if user, ok := r.users[id]; ok && user.DeletedAt == nil {
+			users = append(users, *user)
+		}
+	}
+	return users, nil
+}
+
+func (r *fakeUserRepo) DeleteUser(ctx context.Context, id int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user, ok := r.users[id]
+	if !ok || user.DeletedAt != nil {
+		return errors.New("user not found")
+	}
+	now := time.Now()
+	user.DeletedAt = &now
+	user.TokenVersion++
+	return nil
+}
+
+func (r *fakeUserRepo) RestoreUser(ctx context.Context, id int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user, ok := r.users[id]
+	if !ok || user.DeletedAt == nil {
+		return errors.New("deleted user not found")
+	}
+	user.DeletedAt = nil
+	return nil
+}
+
+func (r *fakeUserRepo) ScheduleUserDeletion(ctx context.Context, id int, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user, ok := r.users[id]
+	if !ok || user.DeletedAt != nil {
+		return errors.New("user not found")
+	}
+	user.DeletionScheduledAt = &at
+	return nil
+}
+
+func (r *fakeUserRepo) UseTOTPStep(ctx context.Context, id int, step int64) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.totpSteps == nil {
+		r.totpSteps = make(map[int]int64)
+	}
+	if step <= r.totpSteps[id] {
+		return false, nil
+	}
+	r.totpSteps[id] = step
+	return true, nil
+}
+
+func (r *fakeUserRepo) GetUserTokenVersion(ctx context.Context, id int) (int, error) {
+	user, err := r.GetUserByID(ctx, id)
+	if err != nil {
+		return 0, err
+	}
+	return user.TokenVersion, nil
+}
+
+func (r *fakeUserRepo) IncrementUserTokenVersion(ctx context.Context, id int) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user, ok := r.users[id]
+	if !ok || user.DeletedAt != nil {
+		return 0, errors.New("user not found")
+	}
+	user.TokenVersion++
+	return user.TokenVersion, nil
+}
+
+type fakeRefreshTokenRepo struct {
+	mu     sync.Mutex
+	tokens []*models.RefreshToken
+}
+
+func (r *fakeRefreshTokenRepo) CreateRefreshToken(ctx context.Context, token *models.RefreshToken) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stored := *token
+	stored.ID = len(r.tokens) + 1
+	r.tokens = append(r.tokens, &stored)
+	return stored.ID, nil
+}
+
+func (r *fakeRefreshTokenRepo) GetRefreshTokenByHash(ctx context.Context, tokenHash string) (*models.RefreshToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, token := range r.tokens {
+		if token.TokenHash == tokenHash {
+			copied := *token
+			return &copied, nil
+		}
+	}
+	return nil, errors.New("refresh token not found")
+}
+
+func (r *fakeRefreshTokenRepo) MarkRefreshTokenUsed(ctx context.Context, id int) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	token := r.tokens[id-1]
+	if token.UsedAt != nil || token.RevokedAt != nil {
+		return false, nil
+	}
+	now := time.Now()
+	token.UsedAt = &now
+	return true, nil
+}
+
+func (r *fakeRefreshTokenRepo) RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+	return r.revokeWhere(func(token *models.RefreshToken) bool { return token.FamilyID == familyID })
+}
+
+func (r *fakeRefreshTokenRepo) RevokeUserRefreshTokens(ctx context.Context, userID int) error {
+	return r.revokeWhere(func(token *models.RefreshToken) bool { return token.UserID == userID })
+}
+
+func (r *fakeRefreshTokenRepo) revokeWhere(match func(*models.RefreshToken) bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	for _, token := range r.tokens {
+		if match(token) && token.RevokedAt == nil {
+			token.RevokedAt = &now
+		}
+	}
+	return nil
+}
+
+type fakeSessionRepo struct {
+	repository.SessionRepository
+
+	mu       sync.Mutex
+	sessions map[string]*models.Session
+}
+
+func (r *fakeSessionRepo) CreateSession(ctx context.Context, session *models.Session) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.sessions == nil {
+		r.sessions = make(map[string]*models.Session)
+	}
+	stored := *session
+	r.sessions[session.ID] = &stored
+	return nil
+}
+
+func (r *fakeSessionRepo) IsSessionRevoked(ctx context.Context, id string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	session, ok := r.sessions[id]
+	return !ok || session.RevokedAt != nil, nil
+}
+
+func (r *fakeSessionRepo) TouchSession(ctx context.Context, id, ip string, seenAt time.Time) error {
+	return nil
+}
+
+func (r *fakeSessionRepo) RevokeUserSessions(ctx context.Context, userID int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	for _, session := range r.sessions {
+		if session.UserID == userID && session.RevokedAt == nil {
+			session.RevokedAt = &now
+		}
+	}
+	return nil
+}
+
+type fakeRevocationRepo struct {
+	repository.TokenRevocationRepository
+
+	mu      sync.Mutex
+	revoked map[string]bool
+}
+
+func (r *fakeRevocationRepo) RevokeToken(ctx context.Context, jti string, userID int, expiresAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.revoked == nil {
+		r.revoked = make(map[string]bool)
+	}
+	r.revoked[jti] = true
+	return nil
+}
+
+func (r *fakeRevocationRepo) IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.revoked[jti], nil
+}
+
+type fakeLoginAttemptRepo struct {
+	mu       sync.Mutex
+	attempts map[string]*models.LoginAttempt
+}
+
+func (r *fakeLoginAttemptRepo) GetLoginAttempt(ctx context.Context, key string) (*models.LoginAttempt, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if attempt, ok := r.attempts[key]; ok {
+		copied := *attempt
+		return &copied, nil
+	}
+	return nil, nil
+}
+
+func (r *fakeLoginAttemptRepo) RecordLoginFailure(ctx context.Context, key string, windowStart time.Time) (*models.LoginAttempt, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.attempts == nil {
+		r.attempts = make(map[string]*models.LoginAttempt)
+	}
+	attempt, ok := r.attempts[key]
+	if !ok || attempt.LastFailureAt.Before(windowStart) {
+		attempt = &models.LoginAttempt{Key: key}
+		r.attempts[key] = attempt
+	}
+	attempt.Failures++
+	attempt.LastFailureAt = time.Now()
+	copied := *attempt
+	return &copied, nil
+}
+
+func (r *fakeLoginAttemptRepo) SetLoginLockout(ctx context.Context, key string, lockedUntil time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.attempts[key].LockedUntil = &lockedUntil
+	return nil
+}
+
+func (r *fakeLoginAttemptRepo) ResetLoginAttempts(ctx context.Context, key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.attempts, key)
+	return nil
+}
+
+// setupTestAuth configures just enough to issue and validate tokens
+func setupTestAuth() {
+	config.AppConfig = &config.Config{
+		JWTSecret:          "test-secret",
+		JWTIssuer:          "http://localhost:8000",
+		JWTAudience:        "web-ponderada-api",
+		AccessTokenTTL:     15 * time.Minute,
+		RefreshTokenTTL:    time.Hour,
+		RevocationCacheTTL: time.Minute,
+		MFAPendingTTL:      5 * time.Minute,
+		LoginMaxFailures:   10,
+		LoginIPMaxFailures: 50,
+		LoginFreeAttempts:  3,
+		LoginFailureWindow: time.Hour,
+	}
+	auth.InitializeAuth()
+}
+
+// GetUserByEmail returns nil, like the Postgres repository, when no user has the email
+func (r *fakeUserRepo) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, user := range r.users {
+		if user.Email == email && user.DeletedAt == nil {
+			copied := *user
+			return &copied, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *fakeUserRepo) CreateUser(ctx context.Context, user *models.User) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user.ID = len(r.users) + 1
+	stored := *user
+	r.users[user.ID] = &stored
+	return user.ID, nil
+}
+
+func (r *fakeUserRepo) UpdateUserPassword(ctx context.Context, id int, passwordHash string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.users[id].Password = passwordHash
+	return nil
+}
+
+func (r *fakeUserRepo) MarkEmailVerified(ctx context.Context, id int, email string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	r.users[id].EmailVerifiedAt = &now
+	return nil
+}
+
+type fakeIdentityRepo struct {
+	repository.IdentityRepository
+
+	mu         sync.Mutex
+	identities []models.UserIdentity
+}
+
+func (r *fakeIdentityRepo) GetIdentity(ctx context.Context, provider, subject string) (*models.UserIdentity, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, identity := range r.identities {
+		if identity.Provider == provider && identity.Subject == subject {
+			return &identity, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *fakeIdentityRepo) CreateIdentity(ctx context.Context, identity *models.UserIdentity) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	identity.ID = len(r.identities) + 1
+	r.identities = append(r.identities, *identity)
+	return identity.ID, nil
+}
+
+func (r *fakeIdentityRepo) TouchIdentity(ctx context.Context, id int, email string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	r.identities[id-1].Email, r.identities[id-1].LastLoginAt = email, &now
+	return nil
+}
+
+func (r *fakeUserRepo) PromoteBootstrapAdmin(ctx context.Context, id int) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, user := range r.users {
+		if user.Role == models.RoleAdmin {
+			return false, nil
+		}
+	}
+	user, ok := r.users[id]
+	if !ok || user.DeletedAt != nil || user.EmailVerifiedAt == nil {
+		return false, nil
+	}
+	user.Role = models.RoleAdmin
+	return true, nil
+}
+
+type fakeUserTokenRepo struct {
+	repository.UserTokenRepository
+
+	mu     sync.Mutex
+	tokens []*models.UserToken
+}
+
+func (r *fakeUserTokenRepo) GetUserToken(ctx context.Context, purpose, tokenHash string) (*models.UserToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, token := range r.tokens {
+		if token.Purpose == purpose && token.TokenHash == tokenHash && token.UsedAt == nil && token.ExpiresAt.After(time.Now()) {
+			copied := *token
+			return &copied, nil
+		}
+	}
+	return nil, errors.New("token invalid or expired")
+}
+
+func (r *fakeUserTokenRepo) ConsumeUserToken(ctx context.Context, purpose, tokenHash string) (*models.UserToken, error) {
+	token, err := r.GetUserToken(ctx, purpose, tokenHash)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	r.tokens[token.ID-1].UsedAt = &now
+	return token, nil
+}
+
+func (r *fakeUserRepo) UpdateUserRole(ctx context.Context, id int, role string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user, ok := r.users[id]
+	if !ok || user.DeletedAt != nil {
+		return errors.New("user not found")
+	}
+	user.Role = role
+	return nil
+}
+
+func (r *fakeSessionRepo) RevokeSession(ctx context.Context, userID int, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	session, ok := r.sessions[id]
+	if !ok || session.UserID != userID || session.RevokedAt != nil {
+		return errors.New("session not found")
+	}
+	now := time.Now()
+	session.RevokedAt = &now
+	return nil
+}
+
+func (r *fakeUserRepo) DisableTOTP(ctx context.Context, id int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user, ok := r.users[id]
+	if !ok || user.DeletedAt != nil {
+		return errors.New("user not found")
+	}
+	user.TOTPSecret = ""
+	user.TOTPEnabledAt = nil
+	return nil
+}
+
+// fakeRecoveryCodeRepo fails DeleteRecoveryCodes with deleteErr, if set
+type fakeRecoveryCodeRepo struct {
+	repository.RecoveryCodeRepository
+
+	deleteErr error
+}
+
+func (r *fakeRecoveryCodeRepo) DeleteRecoveryCodes(ctx context.Context, userID int) error {
+	return r.deleteErr
+}
@@ -4,8 +4,11 @@ import (
 	"log"
 	"net/http"
 	"os" // Use filepath for safety
+	"strconv"
 	"strings"
 
+	"github.com/Eduardo-Barreto/web-ponderada/backend/config"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/images"
 	"github.com/Eduardo-Barreto/web-ponderada/backend/repository"
 	"github.com/Eduardo-Barreto/web-ponderada/backend/utils"
 	"github.com/gin-gonic/gin"
@@ -19,7 +22,8 @@ func NewImageHandler(fileRepo repository.StorageRepository) *ImageHandler {
 	return &ImageHandler{FileRepo: fileRepo}
 }
 
-// ServeImage serves static image files stored by the application
+// ServeImage serves static image files stored by the application. Profile
+// pictures accept ?size= (pixels per side) to get a smaller variant.
 func (h *ImageHandler) ServeImage(c *gin.Context) {
 	// Expecting path like /images/users/uuid.jpg or /images/products/uuid.png
 	// We capture the subdirectory and filename together
@@ -39,6 +43,22 @@ func (h *ImageHandler) ServeImage(c *gin.Context) {
 		return
 	}
 
+	// ?size= serves the closest square variant of a profile picture. Pictures
+	// without variants (products, older uploads) are served as they are.
+	if sizeStr := c.Query("size"); sizeStr != "" {
+		size, err := strconv.Atoi(sizeStr)
+		if err != nil || size < 1 {
+			utils.SendError(c, http.StatusBadRequest, "size must be a positive number of pixels")
+			return
+		}
+		variant := images.VariantPath(imgPath, images.NearestSize(config.AppConfig.ProfilePicSizes, size))
+		if variantPath := h.FileRepo.GetFilePath(variant); variantPath != "" {
+			if _, err := os.Stat(variantPath); err == nil {
+				fullPath = variantPath
+			}
+		}
+	}
+
 	// Check if the file exists
 	if _, err := os.Stat(fullPath); os.IsNotExist(err) {
 		log.Printf("Image not found: %s (requested path: %s)", fullPath, imgPath)
@@ -46,6 +66,9 @@ func (h *ImageHandler) ServeImage(c *gin.Context) {
 		return
 	}
 
+	// Uploads get a new name every time, so a served file never changes
+	c.Header("Cache-Control", "public, max-age=86400")
+
 	// Serve the file
 	// Gin's c.File() handles setting Content-Type based on extension
 	c.File(fullPath)
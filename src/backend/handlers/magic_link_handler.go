@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/Eduardo-Barreto/web-ponderada/backend/auth"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/config"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/mailer"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/models"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/repository"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// MagicLinkHandler handles passwordless login through links sent by email
+type MagicLinkHandler struct {
+	UserRepo  repository.UserRepository
+	TokenRepo repository.UserTokenRepository
+	Tokens    *auth.TokenIssuer
+	Mailer    mailer.Mailer
+	Throttler *auth.LoginThrottler
+}
+
+func NewMagicLinkHandler(userRepo repository.UserRepository, tokenRepo repository.UserTokenRepository, tokens *auth.TokenIssuer, m mailer.Mailer, throttler *auth.LoginThrottler) *MagicLinkHandler {
+	return &MagicLinkHandler{UserRepo: userRepo, TokenRepo: tokenRepo, Tokens: tokens, Mailer: m, Throttler: throttler}
+}
+
+// RequestMagicLink emails a single-use login link if the address is registered.
+// The response is the same either way so it cannot be used to probe for accounts.
+func (h *MagicLinkHandler) RequestMagicLink(c *gin.Context) {
+	if !config.AppConfig.MagicLinkEnabled {
+		utils.SendError(c, http.StatusNotFound, "Magic link login is disabled")
+		return
+	}
+
+	var input models.MagicLinkInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.SendError(c, http.StatusBadRequest, "Invalid input: "+err.Error())
+		return
+	}
+
+	wait, err := h.Throttler.AllowMagicLink(context.Background(), input.Email)
+	if err != nil {
+		log.Printf("Error checking magic link rate limit: %v", err)
+		utils.SendError(c, http.StatusInternalServerError, "Failed to process request")
+		return
+	}
+	if wait > 0 {
+		utils.SendRateLimited(c, wait, "Too many login links requested for this email, try again later")
+		return
+	}
+
+	response := gin.H{"message": "If that email is registered, a login link has been sent"}
+
+	user, err := h.UserRepo.GetUserByEmail(context.Background(), input.Email)
+	if err != nil {
+		log.Printf("Error fetching user by email for magic link: %v", err)
+		utils.SendError(c, http.StatusInternalServerError, "Failed to process request")
+		return
+	}
+	if user == nil {
+		c.JSON(http.StatusAccepted, response)
+		return
+	}
+
+	// Only the most recent link should work
+	if err := h.TokenRepo.InvalidateUserTokens(context.Background(), user.ID, models.TokenPurposeMagicLink); err != nil {
+		log.Printf("Error invalidating old magic links for user %d: %v", user.ID, err)
+		utils.SendError(c, http.StatusInternalServerError, "Failed to process request")
+		return
+	}
+
+	token, err := auth.GenerateOpaqueToken()
+	if err != nil {
+		log.Printf("Error generating magic link token: %v", err)
+		utils.SendError(c, http.StatusInternalServerError, "Failed to process request")
+		return
+	}
+
+	_, err = h.TokenRepo.CreateUserToken(context.Background(), &models.UserToken{
+		UserID:    user.ID,
+		Purpose:   models.TokenPurposeMagicLink,
+		TokenHash: auth.HashToken(token),
+		ExpiresAt: time.Now().Add(config.AppConfig.MagicLinkTTL),
+	})
+	if err != nil {
+		log.Printf("Error storing magic link token for user %d: %v", user.ID, err)
+		utils.SendError(c, http.StatusInternalServerError, "Failed to process request")
+		return
+	}
+
+	link := config.AppConfig.FrontendURL + "/magic-link.html?token=" + url.QueryEscape(token)
+	mailer.SendAsync(h.Mailer, mailer.Message{
+		To:      user.Email,
+		Subject: "Your login link",
+		Body: fmt.Sprintf("Hi %s,\n\nOpen the link below within %s to log in. It works only once:\n\n%s\n\n"+
+			"If you did not ask for this, you can ignore this email.\n",
+			user.Name, config.AppConfig.MagicLinkTTL, link),
+	})
+
+	c.JSON(http.StatusAccepted, response)
+}
+
+// LoginWithMagicLink exchanges the token from a magic link for the same
+// token pair a password login returns. Accounts with 2FA still need the
+// second step.
+func (h *MagicLinkHandler) LoginWithMagicLink(c *gin.Context) {
+	if !config.AppConfig.MagicLinkEnabled {
+		utils.SendError(c, http.StatusNotFound, "Magic link login is disabled")
+		return
+	}
+
+	var input models.MagicLinkLoginInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.SendError(c, http.StatusBadRequest, "Invalid input: "+err.Error())
+		return
+	}
+
+	token, err := h.TokenRepo.ConsumeUserToken(context.Background(), models.TokenPurposeMagicLink, auth.HashToken(input.Token))
+	if err != nil {
+		if err.Error() == "token invalid or expired" {
+			utils.SendError(c, http.StatusUnauthorized, "Login link is invalid or has expired")
+		} else {
+			log.Printf("Error consuming magic link token: %v", err)
+			utils.SendError(c, http.StatusInternalServerError, "Failed to login")
+		}
+		return
+	}
+
+	user, err := h.UserRepo.GetUserByID(context.Background(), token.UserID)
+	if err != nil {
+		if err.Error() == "user not found" {
+			utils.SendError(c, http.StatusUnauthorized, "Login link is invalid or has expired")
+		} else {
+			log.Printf("Error getting user by ID %d: %v", token.UserID, err)
+			utils.SendError(c, http.StatusInternalServerError, "Failed to login")
+		}
+		return
+	}
+
+	if config.AppConfig.RequireEmailVerification && user.EmailVerifiedAt == nil {
+		utils.SendError(c, http.StatusForbidden, "Email address not verified")
+		return
+	}
+
+	// The link replaces the password, not the second factor
+	if user.TOTPEnabledAt != nil {
+		mfaToken, err := auth.GenerateMFAPendingToken(user)
+		if err != nil {
+			log.Printf("Error generating MFA token: %v", err)
+			utils.SendError(c, http.StatusInternalServerError, "Failed to login")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"mfa_required": true,
+			"mfa_token":    mfaToken,
+			"expires_in":   int(config.AppConfig.MFAPendingTTL.Seconds()),
+		})
+		return
+	}
+
+	tokens, err := h.Tokens.Issue(context.Background(), user, clientInfo(c))
+	if err != nil {
+		log.Printf("Error issuing tokens: %v", err)
+		utils.SendError(c, http.StatusInternalServerError, "Failed to login")
+		return
+	}
+
+	response, err := tokenResponse(c, tokens)
+	if err != nil {
+		log.Printf("Error setting session cookies: %v", err)
+		utils.SendError(c, http.StatusInternalServerError, "Failed to login")
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
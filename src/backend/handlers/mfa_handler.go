@@ -0,0 +1,421 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/Eduardo-Barreto/web-ponderada/backend/auth"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/config"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/models"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/repository"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/skip2/go-qrcode"
+)
+
+type MFAHandler struct {
+	UserRepo     repository.UserRepository
+	RecoveryRepo repository.RecoveryCodeRepository
+	Tokens       *auth.TokenIssuer
+	Revocations  *auth.RevocationStore
+	Throttler    *auth.LoginThrottler
+}
+
+func NewMFAHandler(userRepo repository.UserRepository, recoveryRepo repository.RecoveryCodeRepository, tokens *auth.TokenIssuer, revocations *auth.RevocationStore, throttler *auth.LoginThrottler) *MFAHandler {
+	return &MFAHandler{UserRepo: userRepo, RecoveryRepo: recoveryRepo, Tokens: tokens, Revocations: revocations, Throttler: throttler}
+}
+
+// EnrollTOTP starts 2FA enrolment: it stores a new pending secret and returns
+// it as an otpauth URI and a QR code PNG for the authenticator app
+func (h *MFAHandler) EnrollTOTP(c *gin.Context) {
+	user, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+	if user.TOTPEnabledAt != nil {
+		utils.SendError(c, http.StatusConflict, "Two-factor authentication is already enabled")
+		return
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		log.Printf("Error generating TOTP secret: %v", err)
+		utils.SendError(c, http.StatusInternalServerError, "Failed to start enrolment")
+		return
+	}
+	if err := h.UserRepo.SetTOTPSecret(context.Background(), user.ID, secret); err != nil {
+		log.Printf("Error storing TOTP secret for user %d: %v", user.ID, err)
+		utils.SendError(c, http.StatusInternalServerError, "Failed to start enrolment")
+		return
+	}
+
+	uri := auth.TOTPURI(config.AppConfig.MFAIssuer, user.Email, secret)
+	png, err := qrcode.Encode(uri, qrcode.Medium, 256)
+	if err != nil {
+		log.Printf("Error rendering TOTP QR code: %v", err)
+		utils.SendError(c, http.StatusInternalServerError, "Failed to start enrolment")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret":      secret, // For apps that can't scan the QR code
+		"otpauth_uri": uri,
+		"qr_code":     "data:image/png;base64," + base64.StdEncoding.EncodeToString(png),
+	})
+}
+
+// ConfirmTOTP enables 2FA once the user proves the authenticator works.
+// Every existing session is revoked, and a fresh token pair is returned
+// together with the one-time recovery codes.
+func (h *MFAHandler) ConfirmTOTP(c *gin.Context) {
+	var input models.TOTPCodeInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.SendError(c, http.StatusBadRequest, "Invalid input: "+err.Error())
+		return
+	}
+
+	user, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+	if user.TOTPEnabledAt != nil {
+		utils.SendError(c, http.StatusConflict, "Two-factor authentication is already enabled")
+		return
+	}
+	if user.TOTPSecret == "" {
+		utils.SendError(c, http.StatusBadRequest, "Start enrolment first")
+		return
+	}
+	valid, ok := h.useTOTPCode(c, user, input.Code)
+	if !ok {
+		return
+	}
+	if !valid {
+		utils.SendError(c, http.StatusBadRequest, "Invalid authentication code")
+		return
+	}
+
+	if err := h.UserRepo.EnableTOTP(context.Background(), user.ID); err != nil {
+		log.Printf("Error enabling TOTP for user %d: %v", user.ID, err)
+		utils.SendError(c, http.StatusInternalServerError, "Failed to enable two-factor authentication")
+		return
+	}
+
+	codes, ok := h.replaceRecoveryCodes(c, user.ID)
+	if !ok {
+		return
+	}
+
+	// Sessions started with only a password must not carry on as 2FA sessions
+	if err := h.Tokens.RevokeAllForUser(context.Background(), user.ID); err != nil {
+		log.Printf("Error revoking sessions after enabling TOTP for user %d: %v", user.ID, err)
+		utils.SendError(c, http.StatusInternalServerError, "Failed to enable two-factor authentication")
+		return
+	}
+
+	user, ok = h.currentUser(c) // Reload for the new token version
+	if !ok {
+		return
+	}
+	tokens, err := h.Tokens.Issue(context.Background(), user, clientInfo(c))
+	if err != nil {
+		log.Printf("Error issuing tokens for user %d: %v", user.ID, err)
+		utils.SendError(c, http.StatusInternalServerError, "Failed to enable two-factor authentication")
+		return
+	}
+	response, err := tokenResponse(c, tokens)
+	if err != nil {
+		log.Printf("Error setting session cookies for user %d: %v", user.ID, err)
+		utils.SendError(c, http.StatusInternalServerError, "Failed to enable two-factor authentication")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "Two-factor authentication enabled",
+		"recovery_codes": codes,
+		"tokens":         response,
+	})
+}
+
+// DisableTOTP turns 2FA off; requires the password and a current code.
+// Every session is signed out; the caller gets a fresh token pair to carry on.
+func (h *MFAHandler) DisableTOTP(c *gin.Context) {
+	var input models.DisableTOTPInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.SendError(c, http.StatusBadRequest, "Invalid input: "+err.Error())
+		return
+	}
+
+	user, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+	if user.TOTPEnabledAt == nil {
+		utils.SendError(c, http.StatusBadRequest, "Two-factor authentication is not enabled")
+		return
+	}
+
+	// A stolen access token must not allow guessing the password and code
+	clientIP := c.ClientIP()
+	wait, err := h.Throttler.Check(context.Background(), user.Email, clientIP)
+	if err != nil {
+		log.Printf("Error checking login throttle: %v", err)
+		utils.SendError(c, http.StatusInternalServerError, "Failed to disable two-factor authentication")
+		return
+	}
+	if wait > 0 {
+		utils.SendRateLimited(c, wait, "Too many failed attempts, try again later")
+		return
+	}
+
+	valid := auth.CheckPasswordHash(input.Password, user.Password)
+	if valid {
+		if valid, ok = h.useTOTPCode(c, user, input.Code); !ok {
+			return
+		}
+	}
+	if !valid {
+		if err := h.Throttler.RecordFailure(context.Background(), user.Email, clientIP); err != nil {
+			log.Printf("Error recording failed attempt to disable TOTP: %v", err)
+		}
+		utils.SendError(c, http.StatusUnauthorized, "Invalid password or authentication code")
+		return
+	}
+	if err := h.Throttler.RecordSuccess(context.Background(), user.Email); err != nil {
+		log.Printf("Error clearing failed logins for user %d: %v", user.ID, err)
+	}
+
+	if err := h.UserRepo.DisableTOTP(context.Background(), user.ID); err != nil {
+		log.Printf("Error disabling TOTP for user %d: %v", user.ID, err)
+		utils.SendError(c, http.StatusInternalServerError, "Failed to disable two-factor authentication")
+		return
+	}
+	if err := h.RecoveryRepo.DeleteRecoveryCodes(context.Background(), user.ID); err != nil {
+		log.Printf("Error deleting recovery codes for user %d: %v", user.ID, err)
+		utils.SendError(c, http.StatusInternalServerError, "Two-factor authentication disabled, but the recovery codes could not be deleted")
+		return
+	}
+
+	// Existing tokens say the login used a second factor, which would keep
+	// satisfying REQUIRE_ADMIN_MFA
+	if err := h.Tokens.RevokeAllForUser(context.Background(), user.ID); err != nil {
+		log.Printf("Error revoking sessions after disabling TOTP for user %d: %v", user.ID, err)
+		utils.SendError(c, http.StatusInternalServerError, "Two-factor authentication disabled, but existing sessions could not be signed out")
+		return
+	}
+
+	user, ok = h.currentUser(c) // Reload for the new token version
+	if !ok {
+		return
+	}
+	tokens, err := h.Tokens.Issue(context.Background(), user, clientInfo(c))
+	if err != nil {
+		log.Printf("Error issuing tokens for user %d: %v", user.ID, err)
+		utils.SendError(c, http.StatusInternalServerError, "Two-factor authentication disabled, please login again")
+		return
+	}
+	response, err := tokenResponse(c, tokens)
+	if err != nil {
+		log.Printf("Error setting session cookies for user %d: %v", user.ID, err)
+		utils.SendError(c, http.StatusInternalServerError, "Two-factor authentication disabled, please login again")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled", "tokens": response})
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes; requires a current code
+func (h *MFAHandler) RegenerateRecoveryCodes(c *gin.Context) {
+	var input models.TOTPCodeInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.SendError(c, http.StatusBadRequest, "Invalid input: "+err.Error())
+		return
+	}
+
+	user, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+	if user.TOTPEnabledAt == nil {
+		utils.SendError(c, http.StatusBadRequest, "Two-factor authentication is not enabled")
+		return
+	}
+	valid, ok := h.useTOTPCode(c, user, input.Code)
+	if !ok {
+		return
+	}
+	if !valid {
+		utils.SendError(c, http.StatusUnauthorized, "Invalid authentication code")
+		return
+	}
+
+	codes, ok := h.replaceRecoveryCodes(c, user.ID)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// VerifyMFA is the second login step: it exchanges the mfa_token from Login
+// plus a TOTP or recovery code for the usual token pair
+func (h *MFAHandler) VerifyMFA(c *gin.Context) {
+	var input models.MFAVerifyInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.SendError(c, http.StatusBadRequest, "Invalid input: "+err.Error())
+		return
+	}
+	if input.Code == "" && input.RecoveryCode == "" {
+		utils.SendError(c, http.StatusBadRequest, "Either code or recovery_code is required")
+		return
+	}
+
+	claims, err := auth.ValidateMFAPendingToken(input.MFAToken)
+	if err != nil {
+		utils.SendError(c, http.StatusUnauthorized, err.Error())
+		return
+	}
+	// Each mfa_token completes one login; a wrong code leaves it usable
+	if claims.ID == "" {
+		utils.SendError(c, http.StatusUnauthorized, "invalid mfa token")
+		return
+	}
+	used, err := h.Revocations.IsIDRevoked(context.Background(), claims.ID)
+	if err != nil {
+		log.Printf("Error checking mfa token %s: %v", claims.ID, err)
+		utils.SendError(c, http.StatusInternalServerError, "Error during login")
+		return
+	}
+	if used {
+		utils.SendError(c, http.StatusUnauthorized, "mfa token has already been used, please login again")
+		return
+	}
+
+	user, err := h.UserRepo.GetUserByID(context.Background(), claims.UserID)
+	if err != nil || user.TOTPEnabledAt == nil || user.Email != claims.Email {
+		if err != nil && err.Error() != "user not found" {
+			log.Printf("Error getting user %d for MFA verification: %v", claims.UserID, err)
+		}
+		utils.SendError(c, http.StatusUnauthorized, "invalid mfa token")
+		return
+	}
+
+	// Codes are short, so guesses count towards the same lockout as passwords
+	clientIP := c.ClientIP()
+	wait, err := h.Throttler.Check(context.Background(), user.Email, clientIP)
+	if err != nil {
+		log.Printf("Error checking login throttle: %v", err)
+		utils.SendError(c, http.StatusInternalServerError, "Error during login")
+		return
+	}
+	if wait > 0 {
+		utils.SendRateLimited(c, wait, "Too many failed login attempts, try again later")
+		return
+	}
+
+	var valid bool
+	if input.Code != "" {
+		var ok bool
+		if valid, ok = h.useTOTPCode(c, user, input.Code); !ok {
+			return
+		}
+	} else {
+		hash := auth.HashToken(auth.NormalizeRecoveryCode(input.RecoveryCode))
+		valid, err = h.RecoveryRepo.ConsumeRecoveryCode(context.Background(), user.ID, hash)
+		if err != nil {
+			log.Printf("Error consuming recovery code for user %d: %v", user.ID, err)
+			utils.SendError(c, http.StatusInternalServerError, "Error during login")
+			return
+		}
+	}
+
+	if !valid {
+		if err := h.Throttler.RecordFailure(context.Background(), user.Email, clientIP); err != nil {
+			log.Printf("Error recording failed MFA attempt: %v", err)
+		}
+		utils.SendError(c, http.StatusUnauthorized, "Invalid authentication code")
+		return
+	}
+
+	if err := h.Throttler.RecordSuccess(context.Background(), user.Email); err != nil {
+		log.Printf("Error clearing failed logins for user %d: %v", user.ID, err)
+	}
+
+	if err := h.Revocations.RevokeID(context.Background(), claims.ID, user.ID, claims.ExpiresAt.Time); err != nil {
+		log.Printf("Error consuming mfa token for user %d: %v", user.ID, err)
+		utils.SendError(c, http.StatusInternalServerError, "Failed to login")
+		return
+	}
+
+	tokens, err := h.Tokens.Issue(context.Background(), user, clientInfo(c))
+	if err != nil {
+		log.Printf("Error issuing tokens: %v", err)
+		utils.SendError(c, http.StatusInternalServerError, "Failed to login")
+		return
+	}
+
+	response, err := tokenResponse(c, tokens)
+	if err != nil {
+		log.Printf("Error setting session cookies: %v", err)
+		utils.SendError(c, http.StatusInternalServerError, "Failed to login")
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// currentUser loads the authenticated user, sending an error response on failure
+func (h *MFAHandler) currentUser(c *gin.Context) (*models.User, bool) {
+	userID := c.GetInt("userID")
+	user, err := h.UserRepo.GetUserByID(context.Background(), userID)
+	if err != nil {
+		if err.Error() == "user not found" {
+			utils.SendError(c, http.StatusNotFound, err.Error())
+		} else {
+			log.Printf("Error getting user by ID %d: %v", userID, err)
+			utils.SendError(c, http.StatusInternalServerError, "Failed to retrieve user")
+		}
+		return nil, false
+	}
+	return user, true
+}
+
+// useTOTPCode checks a code and records its time step, so that each code is
+// accepted once. Sends an error response and returns ok=false on failure.
+func (h *MFAHandler) useTOTPCode(c *gin.Context, user *models.User, code string) (valid, ok bool) {
+	step, valid := auth.ValidateTOTP(user.TOTPSecret, code, time.Now())
+	if !valid {
+		return false, true
+	}
+	valid, err := h.UserRepo.UseTOTPStep(context.Background(), user.ID, step)
+	if err != nil {
+		log.Printf("Error recording TOTP step for user %d: %v", user.ID, err)
+		utils.SendError(c, http.StatusInternalServerError, "Failed to verify authentication code")
+		return false, false
+	}
+	return valid, true
+}
+
+// replaceRecoveryCodes generates and stores a new set of recovery codes,
+// sending an error response on failure
+func (h *MFAHandler) replaceRecoveryCodes(c *gin.Context, userID int) ([]string, bool) {
+	codes, err := auth.GenerateRecoveryCodes()
+	if err != nil {
+		log.Printf("Error generating recovery codes: %v", err)
+		utils.SendError(c, http.StatusInternalServerError, "Failed to generate recovery codes")
+		return nil, false
+	}
+
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = auth.HashToken(code)
+	}
+	if err := h.RecoveryRepo.ReplaceRecoveryCodes(context.Background(), userID, hashes); err != nil {
+		log.Printf("Error storing recovery codes for user %d: %v", userID, err)
+		utils.SendError(c, http.StatusInternalServerError, "Failed to generate recovery codes")
+		return nil, false
+	}
+	return codes, true
+}
@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Eduardo-Barreto/web-ponderada/backend/auth"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/config"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/models"
+	"github.com/gin-gonic/gin"
+)
+
+// totpAt computes the code an authenticator app shows at time t (RFC 6238)
+func totpAt(t *testing.T, secret string, at time.Time) string {
+	t.Helper()
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(at.Unix()/30))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	return fmt.Sprintf("%06d", (binary.BigEndian.Uint32(sum[offset:])&0x7fffffff)%1000000)
+}
+
+func TestVerifyMFARejectsReplays(t *testing.T) {
+	setupTestAuth()
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	enabledAt := time.Now()
+	maria := models.User{ID: 2, Name: "Maria", Email: "maria@example.com", Role: models.RoleUser, TOTPSecret: secret, TOTPEnabledAt: &enabledAt}
+	users := newFakeUserRepo(maria)
+	sessions := &fakeSessionRepo{}
+	revocations := auth.NewRevocationStore(&fakeRevocationRepo{}, users, sessions, time.Minute)
+	tokens := auth.NewTokenIssuer(users, &fakeRefreshTokenRepo{}, sessions, revocations)
+	handler := NewMFAHandler(users, nil, tokens, revocations, auth.NewLoginThrottler(&fakeLoginAttemptRepo{}))
+
+	router := gin.New()
+	router.POST("/auth/mfa/verify", handler.VerifyMFA)
+	verify := func(mfaToken, code string) int {
+		body, _ := json.Marshal(models.MFAVerifyInput{MFAToken: mfaToken, Code: code})
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/auth/mfa/verify", bytes.NewReader(body)))
+		return rec.Code
+	}
+	newMFAToken := func() string {
+		token, err := auth.GenerateMFAPendingToken(&maria)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	firstToken := newMFAToken()
+	code := totpAt(t, secret, time.Now())
+	nextCode := totpAt(t, secret, time.Now().Add(30*time.Second)) // Still within the accepted skew
+
+	steps := []struct {
+		name       string
+		mfaToken   string
+		code       string
+		wantStatus int
+	}{
+		{"wrong code keeps the token usable", firstToken, "000000", http.StatusUnauthorized},
+		{"first login", firstToken, code, http.StatusOK},
+		{"same mfa_token again", firstToken, nextCode, http.StatusUnauthorized},
+		{"same code with a new mfa_token", newMFAToken(), code, http.StatusUnauthorized},
+		{"next code with a new mfa_token", newMFAToken(), nextCode, http.StatusOK},
+	}
+	for _, step := range steps {
+		if step.name == "wrong code keeps the token usable" && step.code == code {
+			continue // One in a million: the current code is 000000
+		}
+		if got := verify(step.mfaToken, step.code); got != step.wantStatus {
+			t.Errorf("%s: got status %d, want %d", step.name, got, step.wantStatus)
+		}
+	}
+}
+
+func TestDisableTOTP(t *testing.T) {
+	tests := []struct {
+		name             string
+		password         string
+		deleteErr        error
+		wantStatus       int
+		wantDisabled     bool
+		wantTokenVersion int
+	}{
+		{"signs out existing sessions", "Blue-Kettle-42", nil, http.StatusOK, true, 1},
+		{"wrong password", "wrong-password", nil, http.StatusUnauthorized, false, 0},
+		{"recovery codes left behind", "Blue-Kettle-42", errors.New("connection reset"), http.StatusInternalServerError, true, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestAuth()
+			config.AppConfig.Argon2Memory, config.AppConfig.Argon2Iterations, config.AppConfig.Argon2Parallelism = 64, 1, 1
+			hash, err := auth.HashPassword("Blue-Kettle-42")
+			if err != nil {
+				t.Fatal(err)
+			}
+			secret, err := auth.GenerateTOTPSecret()
+			if err != nil {
+				t.Fatal(err)
+			}
+			enabledAt := time.Now()
+			users := newFakeUserRepo(models.User{ID: 2, Name: "Maria", Email: "maria@example.com", Password: hash, Role: models.RoleUser, TOTPSecret: secret, TOTPEnabledAt: &enabledAt})
+			sessions := &fakeSessionRepo{}
+			revocations := auth.NewRevocationStore(&fakeRevocationRepo{}, users, sessions, 0)
+			tokens := auth.NewTokenIssuer(users, &fakeRefreshTokenRepo{}, sessions, revocations)
+			handler := NewMFAHandler(users, &fakeRecoveryCodeRepo{deleteErr: tt.deleteErr}, tokens, revocations, auth.NewLoginThrottler(&fakeLoginAttemptRepo{}))
+
+			router := gin.New()
+			router.Use(func(c *gin.Context) { c.Set("userID", 2) })
+			router.POST("/auth/mfa/totp/disable", handler.DisableTOTP)
+
+			body, _ := json.Marshal(models.DisableTOTPInput{Password: tt.password, Code: totpAt(t, secret, time.Now())})
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/auth/mfa/totp/disable", bytes.NewReader(body)))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			user := users.users[2]
+			if disabled := user.TOTPEnabledAt == nil; disabled != tt.wantDisabled {
+				t.Errorf("2FA disabled = %v, want %v", disabled, tt.wantDisabled)
+			}
+			if user.TokenVersion != tt.wantTokenVersion {
+				t.Errorf("token version = %d, want %d", user.TokenVersion, tt.wantTokenVersion)
+			}
+		})
+	}
+}
+
+func TestDisableTOTPCountsFailedAttempts(t *testing.T) {
+	setupTestAuth()
+	config.AppConfig.Argon2Memory, config.AppConfig.Argon2Iterations, config.AppConfig.Argon2Parallelism = 64, 1, 1
+	config.AppConfig.LoginMaxFailures = 3
+	config.AppConfig.LoginLockoutDuration = time.Hour
+	enabledAt := time.Now()
+	users := newFakeUserRepo(models.User{ID: 2, Name: "Maria", Email: "maria@example.com", Role: models.RoleUser, TOTPSecret: "JBSWY3DPEHPK3PXP", TOTPEnabledAt: &enabledAt})
+	handler := NewMFAHandler(users, nil, nil, nil, auth.NewLoginThrottler(&fakeLoginAttemptRepo{}))
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set("userID", 2) })
+	router.POST("/auth/mfa/totp/disable", handler.DisableTOTP)
+
+	body, _ := json.Marshal(models.DisableTOTPInput{Password: "guess", Code: "000000"})
+	for attempt := 1; attempt <= 4; attempt++ {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/auth/mfa/totp/disable", bytes.NewReader(body)))
+		want := http.StatusUnauthorized
+		if attempt > 3 {
+			want = http.StatusTooManyRequests
+		}
+		if rec.Code != want {
+			t.Errorf("attempt %d: got status %d, want %d", attempt, rec.Code, want)
+		}
+	}
+}
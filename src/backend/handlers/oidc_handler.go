@@ -0,0 +1,258 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/Eduardo-Barreto/web-ponderada/backend/auth"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/config"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/models"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/repository"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/utils"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	oidcStateCookie     = "oidc_state"
+	oidcStateCookiePath = "/api/v1/auth/oidc"
+)
+
+// OIDCHandler signs users in through external OpenID Connect providers
+type OIDCHandler struct {
+	UserRepo     repository.UserRepository
+	IdentityRepo repository.IdentityRepository
+	Tokens       *auth.TokenIssuer
+	OIDC         *auth.OIDCClient
+}
+
+func NewOIDCHandler(userRepo repository.UserRepository, identityRepo repository.IdentityRepository, tokens *auth.TokenIssuer, oidcClient *auth.OIDCClient) *OIDCHandler {
+	return &OIDCHandler{UserRepo: userRepo, IdentityRepo: identityRepo, Tokens: tokens, OIDC: oidcClient}
+}
+
+// GetProviders lists the configured providers, for the login page buttons
+func (h *OIDCHandler) GetProviders(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"providers": h.OIDC.ProviderNames()})
+}
+
+// Login redirects the browser to the provider's authorization endpoint
+func (h *OIDCHandler) Login(c *gin.Context) {
+	provider := c.Param("provider")
+
+	authURL, stateToken, err := h.OIDC.BeginLogin(c.Request.Context(), provider)
+	if err != nil {
+		if errors.Is(err, auth.ErrUnknownOIDCProvider) {
+			utils.SendError(c, http.StatusNotFound, err.Error())
+			return
+		}
+		log.Printf("Error starting OIDC login with %s: %v", provider, err)
+		utils.SendError(c, http.StatusBadGateway, "Identity provider is unavailable")
+		return
+	}
+
+	setOIDCStateCookie(c, stateToken, int(auth.OIDCStateTTL.Seconds()))
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// Callback finishes the login and hands the tokens to the frontend in the
+// URL fragment of login.html, which never reaches server logs
+func (h *OIDCHandler) Callback(c *gin.Context) {
+	provider := c.Param("provider")
+
+	stateToken, _ := c.Cookie(oidcStateCookie)
+	setOIDCStateCookie(c, "", -1) // Single use
+
+	if providerErr := c.Query("error"); providerErr != "" {
+		log.Printf("OIDC login with %s failed at the provider: %s %s", provider, providerErr, c.Query("error_description"))
+		redirectToLogin(c, url.Values{"error": {"Login was cancelled or denied by the identity provider"}})
+		return
+	}
+
+	identity, err := h.OIDC.CompleteLogin(c.Request.Context(), provider, stateToken, c.Query("state"), c.Query("code"))
+	if err != nil {
+		if errors.Is(err, auth.ErrUnknownOIDCProvider) || errors.Is(err, auth.ErrInvalidOIDCState) {
+			redirectToLogin(c, url.Values{"error": {err.Error()}})
+			return
+		}
+		log.Printf("Error completing OIDC login with %s: %v", provider, err)
+		redirectToLogin(c, url.Values{"error": {"Could not sign in with the identity provider"}})
+		return
+	}
+	if identity.Email == "" || !identity.EmailVerified {
+		redirectToLogin(c, url.Values{"error": {"Your identity provider did not confirm your email address"}})
+		return
+	}
+
+	user, err := h.resolveUser(context.Background(), identity)
+	if err != nil {
+		log.Printf("Error resolving user for %s identity %s: %v", provider, identity.Subject, err)
+		redirectToLogin(c, url.Values{"error": {"Failed to login"}})
+		return
+	}
+
+	// The provider replaces the password, not the second factor
+	if user.TOTPEnabledAt != nil {
+		mfaToken, err := auth.GenerateMFAPendingToken(user)
+		if err != nil {
+			log.Printf("Error generating MFA token: %v", err)
+			redirectToLogin(c, url.Values{"error": {"Failed to login"}})
+			return
+		}
+		redirectToLogin(c, url.Values{"mfa_token": {mfaToken}})
+		return
+	}
+
+	tokens, err := h.Tokens.Issue(context.Background(), user, clientInfo(c))
+	if err != nil {
+		log.Printf("Error issuing tokens: %v", err)
+		redirectToLogin(c, url.Values{"error": {"Failed to login"}})
+		return
+	}
+
+	// The whole flow runs in the browser, so use cookies whenever they are enabled
+	if config.AppConfig.SessionCookies {
+		session, err := setSessionCookies(c, tokens)
+		if err != nil {
+			log.Printf("Error setting session cookies: %v", err)
+			redirectToLogin(c, url.Values{"error": {"Failed to login"}})
+			return
+		}
+		redirectToLogin(c, url.Values{
+			"token_type": {session.TokenType},
+			"csrf_token": {session.CSRFToken},
+			"expires_in": {strconv.Itoa(session.ExpiresIn)},
+		})
+		return
+	}
+
+	redirectToLogin(c, url.Values{
+		"token":         {tokens.AccessToken},
+		"refresh_token": {tokens.RefreshToken},
+		"token_type":    {tokens.TokenType},
+		"expires_in":    {strconv.Itoa(tokens.ExpiresIn)},
+	})
+}
+
+// resolveUser finds the user behind an identity: by an existing link first,
+// then by verified email (linking it), creating the user if neither matches
+func (h *OIDCHandler) resolveUser(ctx context.Context, identity *auth.OIDCIdentity) (*models.User, error) {
+	link, err := h.IdentityRepo.GetIdentity(ctx, identity.Provider, identity.Subject)
+	if err != nil {
+		return nil, err
+	}
+	if link != nil {
+		if err := h.IdentityRepo.TouchIdentity(ctx, link.ID, identity.Email); err != nil {
+			log.Printf("Warning: Failed to record login for identity %d: %v", link.ID, err)
+		}
+		return h.UserRepo.GetUserByID(ctx, link.UserID)
+	}
+
+	user, err := h.UserRepo.GetUserByEmail(ctx, identity.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	if user == nil {
+		if user, err = h.createUser(ctx, identity); err != nil {
+			return nil, err
+		}
+	} else if user.EmailVerifiedAt == nil {
+		// Whoever registered this address never proved they own it, so the
+		// password they chose must not keep working alongside the real owner
+		if err := h.takeOverUnverifiedAccount(ctx, user); err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = h.IdentityRepo.CreateIdentity(ctx, &models.UserIdentity{
+		UserID:   user.ID,
+		Provider: identity.Provider,
+		Subject:  identity.Subject,
+		Email:    identity.Email,
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Linked %s identity %s to user %d", identity.Provider, identity.Subject, user.ID)
+
+	return user, nil
+}
+
+func (h *OIDCHandler) createUser(ctx context.Context, identity *auth.OIDCIdentity) (*models.User, error) {
+	// The account has no usable password until the user sets one through a reset
+	hashedPassword, err := randomPasswordHash()
+	if err != nil {
+		return nil, err
+	}
+
+	name := identity.Name
+	if name == "" {
+		name = strings.SplitN(identity.Email, "@", 2)[0]
+	}
+
+	newUser := &models.User{
+		Name:     name,
+		Email:    identity.Email,
+		Password: hashedPassword,
+		Role:     models.RoleUser,
+	}
+	if _, err := h.UserRepo.CreateUser(ctx, newUser); err != nil {
+		return nil, err
+	}
+	if err := h.UserRepo.MarkEmailVerified(ctx, newUser.ID, newUser.Email); err != nil {
+		return nil, err
+	}
+
+	return h.UserRepo.GetUserByID(ctx, newUser.ID)
+}
+
+func (h *OIDCHandler) takeOverUnverifiedAccount(ctx context.Context, user *models.User) error {
+	hashedPassword, err := randomPasswordHash()
+	if err != nil {
+		return err
+	}
+	if err := h.UserRepo.UpdateUserPassword(ctx, user.ID, hashedPassword); err != nil {
+		return err
+	}
+	if err := h.UserRepo.MarkEmailVerified(ctx, user.ID, user.Email); err != nil {
+		return err
+	}
+	if err := h.Tokens.RevokeAllForUser(ctx, user.ID); err != nil {
+		return err
+	}
+
+	// Reload for the new token version
+	reloaded, err := h.UserRepo.GetUserByID(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	*user = *reloaded
+	return nil
+}
+
+// randomPasswordHash hashes a random password nobody knows
+func randomPasswordHash() (string, error) {
+	randomPassword, err := auth.GenerateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+	return auth.HashPassword(randomPassword)
+}
+
+// setOIDCStateCookie stores (or with maxAge -1, clears) the login state.
+// Lax so the cookie comes back on the provider's top-level redirect to the callback.
+func setOIDCStateCookie(c *gin.Context, value string, maxAge int) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, value, maxAge, oidcStateCookiePath, "",
+		strings.HasPrefix(config.AppConfig.PublicURL, "https://"), true)
+}
+
+// redirectToLogin sends the browser back to the frontend login page with the
+// given values in the URL fragment
+func redirectToLogin(c *gin.Context, values url.Values) {
+	c.Redirect(http.StatusFound, config.AppConfig.FrontendURL+"/login.html#"+values.Encode())
+}
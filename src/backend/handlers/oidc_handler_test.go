@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/Eduardo-Barreto/web-ponderada/backend/auth"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/auth/oidctest"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/config"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/models"
+	"github.com/gin-gonic/gin"
+)
+
+func TestOIDCCallback(t *testing.T) {
+	verifiedAt := time.Now().Add(-24 * time.Hour)
+
+	tests := []struct {
+		name      string
+		maria     models.User // User 1, already registered with a password
+		linked    bool        // Maria's account is already linked to the provider subject
+		email     string
+		verified  bool
+		wantError bool
+		wantUser  int // Logged in as, when there is no error
+	}{
+		{"links a verified account", models.User{EmailVerifiedAt: &verifiedAt}, false, "maria@example.com", true, false, 1},
+		{"takes over an unverified account", models.User{}, false, "maria@example.com", true, false, 1},
+		{"creates a new account", models.User{EmailVerifiedAt: &verifiedAt}, false, "joao@example.com", true, false, 2},
+		{"follows an existing link", models.User{EmailVerifiedAt: &verifiedAt}, true, "maria@work.example.com", true, false, 1},
+		{"rejects an unverified email", models.User{EmailVerifiedAt: &verifiedAt}, false, "maria@example.com", false, true, 0},
+		{"rejects an unverified email for a new account", models.User{EmailVerifiedAt: &verifiedAt}, false, "joao@example.com", false, true, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestAuth()
+			config.AppConfig.FrontendURL = "http://localhost:3000"
+			config.AppConfig.Argon2Memory, config.AppConfig.Argon2Iterations, config.AppConfig.Argon2Parallelism = 64, 1, 1
+
+			provider := oidctest.NewProvider(t)
+			maria := tt.maria
+			maria.ID, maria.Name, maria.Email, maria.Role, maria.Password = 1, "Maria", "maria@example.com", models.RoleUser, "original-hash"
+			users := newFakeUserRepo(maria)
+			identities := &fakeIdentityRepo{}
+			if tt.linked {
+				identities.identities = []models.UserIdentity{{ID: 1, UserID: 1, Provider: "test", Subject: "google-123", Email: "maria@example.com"}}
+			}
+			sessions := &fakeSessionRepo{}
+			revocations := auth.NewRevocationStore(&fakeRevocationRepo{}, users, sessions, time.Minute)
+			auth.SetRevocationStore(revocations)
+			t.Cleanup(func() { auth.SetRevocationStore(nil) })
+			tokens := auth.NewTokenIssuer(users, &fakeRefreshTokenRepo{}, sessions, revocations)
+			oidcClient := auth.NewOIDCClient(map[string]config.OIDCProvider{"test": {
+				IssuerURL:    provider.URL,
+				ClientID:     provider.ClientID,
+				ClientSecret: provider.ClientSecret,
+				RedirectURL:  "http://localhost:8000/api/v1/auth/oidc/test/callback",
+			}})
+			handler := NewOIDCHandler(users, identities, tokens, oidcClient)
+
+			router := gin.New()
+			router.GET("/api/v1/auth/oidc/:provider", handler.Login)
+			router.GET("/api/v1/auth/oidc/:provider/callback", handler.Callback)
+
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/auth/oidc/test", nil))
+			if rec.Code != http.StatusFound {
+				t.Fatalf("login: got status %d, want a redirect: %s", rec.Code, rec.Body)
+			}
+			state, code := provider.Authorize(t, rec.Header().Get("Location"), map[string]interface{}{
+				"sub":            "google-123",
+				"email":          tt.email,
+				"email_verified": tt.verified,
+				"name":           "From Google",
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/auth/oidc/test/callback?"+url.Values{"state": {state}, "code": {code}}.Encode(), nil)
+			for _, cookie := range rec.Result().Cookies() {
+				req.AddCookie(cookie)
+			}
+			rec = httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			location, err := url.Parse(rec.Header().Get("Location"))
+			if err != nil || rec.Code != http.StatusFound {
+				t.Fatalf("callback: got status %d and location %q, want a redirect", rec.Code, rec.Header().Get("Location"))
+			}
+			result, _ := url.ParseQuery(location.Fragment)
+
+			if tt.wantError {
+				if result.Get("error") == "" || result.Get("token") != "" {
+					t.Errorf("got %v, want an error and no tokens", result)
+				}
+				if len(identities.identities) != 0 || len(users.users) != 1 {
+					t.Errorf("got %d identities and %d users, want none linked or created", len(identities.identities), len(users.users))
+				}
+				return
+			}
+
+			claims, err := auth.ValidateToken(result.Get("token"))
+			if err != nil {
+				t.Fatalf("got %v, want a valid access token: %v", result, err)
+			}
+			if claims.UserID != tt.wantUser {
+				t.Errorf("logged in as user %d, want %d", claims.UserID, tt.wantUser)
+			}
+			if len(identities.identities) != 1 || identities.identities[0].UserID != tt.wantUser || identities.identities[0].Subject != "google-123" {
+				t.Errorf("got identities %+v, want one linking user %d", identities.identities, tt.wantUser)
+			}
+			if user := users.users[tt.wantUser]; user.EmailVerifiedAt == nil {
+				t.Errorf("user %d is not verified after logging in through the provider", tt.wantUser)
+			}
+
+			// The password of an account whose email was never proven stops working
+			passwordKept := users.users[1].Password == "original-hash"
+			if wantKept := tt.maria.EmailVerifiedAt != nil; passwordKept != wantKept {
+				t.Errorf("Maria's password kept = %v, want %v", passwordKept, wantKept)
+			}
+		})
+	}
+}
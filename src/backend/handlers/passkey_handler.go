@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/Eduardo-Barreto/web-ponderada/backend/auth"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/config"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/models"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// passkeysEnabled responds with 404 when passkeys are turned off
+func passkeysEnabled(c *gin.Context) bool {
+	if !config.AppConfig.PasskeysEnabled {
+		utils.SendError(c, http.StatusNotFound, "Passkey login is disabled")
+		return false
+	}
+	return true
+}
+
+// BeginPasskeyRegistration starts adding a passkey to the logged-in user's account
+func (h *AuthHandler) BeginPasskeyRegistration(c *gin.Context) {
+	if !passkeysEnabled(c) {
+		return
+	}
+
+	userID := c.GetInt("userID")
+	user, err := h.UserRepo.GetUserByID(context.Background(), userID)
+	if err != nil {
+		if err.Error() == "user not found" {
+			utils.SendError(c, http.StatusNotFound, err.Error())
+		} else {
+			log.Printf("Error getting user by ID %d: %v", userID, err)
+			utils.SendError(c, http.StatusInternalServerError, "Failed to start passkey registration")
+		}
+		return
+	}
+
+	options, ceremony, err := h.Passkeys.BeginRegistration(context.Background(), user)
+	if err != nil {
+		log.Printf("Error starting passkey registration for user %d: %v", userID, err)
+		utils.SendError(c, http.StatusInternalServerError, "Failed to start passkey registration")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"ceremony":   ceremony, // Send back with the new credential
+		"options":    options,  // Pass options.publicKey to navigator.credentials.create()
+		"expires_in": int(config.AppConfig.WebAuthnCeremonyTTL.Seconds()),
+	})
+}
+
+// FinishPasskeyRegistration verifies and stores the credential the browser created
+func (h *AuthHandler) FinishPasskeyRegistration(c *gin.Context) {
+	if !passkeysEnabled(c) {
+		return
+	}
+
+	var input models.PasskeyRegistrationInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.SendError(c, http.StatusBadRequest, "Invalid input: "+err.Error())
+		return
+	}
+
+	userID := c.GetInt("userID")
+	user, err := h.UserRepo.GetUserByID(context.Background(), userID)
+	if err != nil {
+		if err.Error() == "user not found" {
+			utils.SendError(c, http.StatusNotFound, err.Error())
+		} else {
+			log.Printf("Error getting user by ID %d: %v", userID, err)
+			utils.SendError(c, http.StatusInternalServerError, "Failed to register passkey")
+		}
+		return
+	}
+
+	name := input.Name
+	if name == "" {
+		name = "Passkey"
+	}
+
+	credential, err := h.Passkeys.FinishRegistration(context.Background(), user, input.Ceremony, name, input.Credential)
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrInvalidPasskeyCeremony):
+			utils.SendError(c, http.StatusBadRequest, err.Error())
+		case errors.Is(err, auth.ErrPasskeyRejected):
+			log.Printf("Rejected passkey registration for user %d: %v", userID, err)
+			utils.SendError(c, http.StatusBadRequest, auth.ErrPasskeyRejected.Error())
+		default:
+			log.Printf("Error registering passkey for user %d: %v", userID, err)
+			utils.SendError(c, http.StatusInternalServerError, "Failed to register passkey")
+		}
+		return
+	}
+
+	log.Printf("Passkey %d registered for user %d", credential.ID, userID)
+	c.JSON(http.StatusCreated, credential)
+}
+
+// BeginPasskeyLogin returns a challenge for any passkey registered on this site
+func (h *AuthHandler) BeginPasskeyLogin(c *gin.Context) {
+	if !passkeysEnabled(c) {
+		return
+	}
+
+	options, ceremony, err := h.Passkeys.BeginLogin(context.Background())
+	if err != nil {
+		log.Printf("Error starting passkey login: %v", err)
+		utils.SendError(c, http.StatusInternalServerError, "Failed to start passkey login")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"ceremony":   ceremony, // Send back with the assertion
+		"options":    options,  // Pass options.publicKey to navigator.credentials.get()
+		"expires_in": int(config.AppConfig.WebAuthnCeremonyTTL.Seconds()),
+	})
+}
+
+// FinishPasskeyLogin verifies the assertion and returns the same token pair
+// as a password login. The authenticator already verified the user with a
+// PIN or biometrics, so no TOTP code is asked for.
+func (h *AuthHandler) FinishPasskeyLogin(c *gin.Context) {
+	if !passkeysEnabled(c) {
+		return
+	}
+
+	var input models.PasskeyLoginInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.SendError(c, http.StatusBadRequest, "Invalid input: "+err.Error())
+		return
+	}
+
+	user, err := h.Passkeys.FinishLogin(context.Background(), input.Ceremony, input.Credential)
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrInvalidPasskeyCeremony):
+			utils.SendError(c, http.StatusUnauthorized, err.Error())
+		case errors.Is(err, auth.ErrPasskeyRejected):
+			log.Printf("Rejected passkey login from %s: %v", c.ClientIP(), err)
+			utils.SendError(c, http.StatusUnauthorized, auth.ErrPasskeyRejected.Error())
+		default:
+			log.Printf("Error during passkey login: %v", err)
+			utils.SendError(c, http.StatusInternalServerError, "Failed to login")
+		}
+		return
+	}
+
+	if config.AppConfig.RequireEmailVerification && user.EmailVerifiedAt == nil {
+		utils.SendError(c, http.StatusForbidden, "Email address not verified")
+		return
+	}
+
+	tokens, err := h.Tokens.Issue(context.Background(), user, clientInfo(c))
+	if err != nil {
+		log.Printf("Error issuing tokens: %v", err)
+		utils.SendError(c, http.StatusInternalServerError, "Failed to login")
+		return
+	}
+
+	response, err := tokenResponse(c, tokens)
+	if err != nil {
+		log.Printf("Error setting session cookies: %v", err)
+		utils.SendError(c, http.StatusInternalServerError, "Failed to login")
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// GetPasskeys lists the passkeys registered by the logged-in user
+func (h *AuthHandler) GetPasskeys(c *gin.Context) {
+	userID := c.GetInt("userID")
+	credentials, err := h.Passkeys.WebAuthnRepo.GetCredentialsByUser(context.Background(), userID)
+	if err != nil {
+		log.Printf("Error getting passkeys for user %d: %v", userID, err)
+		utils.SendError(c, http.StatusInternalServerError, "Failed to retrieve passkeys")
+		return
+	}
+	c.JSON(http.StatusOK, credentials)
+}
+
+// DeletePasskey removes one of the logged-in user's passkeys
+func (h *AuthHandler) DeletePasskey(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		utils.SendError(c, http.StatusBadRequest, "Invalid passkey ID format")
+		return
+	}
+
+	userID := c.GetInt("userID")
+	if err := h.Passkeys.WebAuthnRepo.DeleteCredential(context.Background(), userID, id); err != nil {
+		if err.Error() == "credential not found" {
+			utils.SendError(c, http.StatusNotFound, "Passkey not found")
+		} else {
+			log.Printf("Error deleting passkey %d for user %d: %v", id, userID, err)
+			utils.SendError(c, http.StatusInternalServerError, "Failed to delete passkey")
+		}
+		return
+	}
+
+	log.Printf("Passkey %d deleted by user %d", id, userID)
+	c.JSON(http.StatusOK, gin.H{"message": "Passkey deleted"})
+}
@@ -0,0 +1,291 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/Eduardo-Barreto/web-ponderada/backend/auth"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/config"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/mailer"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/models"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/repository"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/utils"
+	"github.com/gin-gonic/gin"
+)
+
+type PasswordHandler struct {
+	UserRepo  repository.UserRepository
+	TokenRepo repository.UserTokenRepository
+	Tokens    *auth.TokenIssuer
+	Mailer    mailer.Mailer
+	Throttler *auth.LoginThrottler
+}
+
+func NewPasswordHandler(userRepo repository.UserRepository, tokenRepo repository.UserTokenRepository, tokens *auth.TokenIssuer, m mailer.Mailer, throttler *auth.LoginThrottler) *PasswordHandler {
+	return &PasswordHandler{UserRepo: userRepo, TokenRepo: tokenRepo, Tokens: tokens, Mailer: m, Throttler: throttler}
+}
+
+// ForgotPassword emails a single-use reset link if the address is registered.
+// The response is the same either way so it cannot be used to probe for accounts.
+func (h *PasswordHandler) ForgotPassword(c *gin.Context) {
+	var input models.ForgotPasswordInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.SendError(c, http.StatusBadRequest, "Invalid input: "+err.Error())
+		return
+	}
+
+	wait, err := h.Throttler.AllowPasswordReset(context.Background(), input.Email)
+	if err != nil {
+		log.Printf("Error checking password reset rate limit: %v", err)
+		utils.SendError(c, http.StatusInternalServerError, "Failed to process request")
+		return
+	}
+	if wait > 0 {
+		utils.SendRateLimited(c, wait, "Too many password reset links requested for this email, try again later")
+		return
+	}
+
+	response := gin.H{"message": "If that email is registered, a password reset link has been sent"}
+
+	user, err := h.UserRepo.GetUserByEmail(context.Background(), input.Email)
+	if err != nil {
+		log.Printf("Error fetching user by email for password reset: %v", err)
+		utils.SendError(c, http.StatusInternalServerError, "Failed to process request")
+		return
+	}
+	if user == nil {
+		c.JSON(http.StatusAccepted, response)
+		return
+	}
+
+	// Only the most recent link should work
+	if err := h.TokenRepo.InvalidateUserTokens(context.Background(), user.ID, models.TokenPurposePasswordReset); err != nil {
+		log.Printf("Error invalidating old reset tokens for user %d: %v", user.ID, err)
+		utils.SendError(c, http.StatusInternalServerError, "Failed to process request")
+		return
+	}
+
+	token, err := auth.GenerateOpaqueToken()
+	if err != nil {
+		log.Printf("Error generating reset token: %v", err)
+		utils.SendError(c, http.StatusInternalServerError, "Failed to process request")
+		return
+	}
+
+	_, err = h.TokenRepo.CreateUserToken(context.Background(), &models.UserToken{
+		UserID:    user.ID,
+		Purpose:   models.TokenPurposePasswordReset,
+		TokenHash: auth.HashToken(token),
+		ExpiresAt: time.Now().Add(config.AppConfig.PasswordResetTTL),
+	})
+	if err != nil {
+		log.Printf("Error storing reset token for user %d: %v", user.ID, err)
+		utils.SendError(c, http.StatusInternalServerError, "Failed to process request")
+		return
+	}
+
+	link := config.AppConfig.FrontendURL + "/reset-password.html?token=" + url.QueryEscape(token)
+	mailer.SendAsync(h.Mailer, mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nSomeone asked to reset the password for your account. "+
+			"If it was you, open the link below within %s:\n\n%s\n\n"+
+			"If you did not ask for this, you can ignore this email.\n",
+			user.Name, config.AppConfig.PasswordResetTTL, link),
+	})
+
+	c.JSON(http.StatusAccepted, response)
+}
+
+// ResetPassword sets a new password using a reset token and signs the user
+// out of every existing session
+func (h *PasswordHandler) ResetPassword(c *gin.Context) {
+	var input models.ResetPasswordInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.SendError(c, http.StatusBadRequest, "Invalid input: "+err.Error())
+		return
+	}
+
+	// Look the token up without using it, so a rejected password can be retried
+	token, err := h.TokenRepo.GetUserToken(context.Background(), models.TokenPurposePasswordReset, auth.HashToken(input.Token))
+	if err != nil {
+		if err.Error() == "token invalid or expired" {
+			utils.SendError(c, http.StatusBadRequest, "Reset token is invalid or has expired")
+		} else {
+			log.Printf("Error getting reset token: %v", err)
+			utils.SendError(c, http.StatusInternalServerError, "Failed to reset password")
+		}
+		return
+	}
+
+	user, err := h.UserRepo.GetUserByID(context.Background(), token.UserID)
+	if err != nil {
+		if err.Error() == "user not found" {
+			utils.SendError(c, http.StatusBadRequest, "Reset token is invalid or has expired")
+		} else {
+			log.Printf("Error getting user by ID %d: %v", token.UserID, err)
+			utils.SendError(c, http.StatusInternalServerError, "Failed to reset password")
+		}
+		return
+	}
+	if !checkPasswordPolicy(c, "password", input.Password, user.Name, user.Email) {
+		return
+	}
+
+	token, err = h.TokenRepo.ConsumeUserToken(context.Background(), models.TokenPurposePasswordReset, auth.HashToken(input.Token))
+	if err != nil {
+		if err.Error() == "token invalid or expired" {
+			utils.SendError(c, http.StatusBadRequest, "Reset token is invalid or has expired")
+		} else {
+			log.Printf("Error consuming reset token: %v", err)
+			utils.SendError(c, http.StatusInternalServerError, "Failed to reset password")
+		}
+		return
+	}
+
+	hashedPassword, err := auth.HashPassword(input.Password)
+	if err != nil {
+		log.Printf("Error hashing password: %v", err)
+		utils.SendError(c, http.StatusInternalServerError, "Failed to reset password")
+		return
+	}
+
+	if err := h.UserRepo.UpdateUserPassword(context.Background(), token.UserID, hashedPassword); err != nil {
+		log.Printf("Error updating password for user %d: %v", token.UserID, err)
+		utils.SendError(c, http.StatusInternalServerError, "Failed to reset password")
+		return
+	}
+
+	if err := h.Tokens.RevokeAllForUser(context.Background(), token.UserID); err != nil {
+		log.Printf("Error revoking sessions after password reset for user %d: %v", token.UserID, err)
+		utils.SendError(c, http.StatusInternalServerError, "Password changed, but existing sessions could not be signed out; request a new reset link to try again")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password reset successfully"})
+}
+
+// ChangePassword sets a new password for the logged-in user. Every other
+// session is signed out; the caller gets a fresh token pair to carry on.
+func (h *PasswordHandler) ChangePassword(c *gin.Context) {
+	var input models.ChangePasswordInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.SendError(c, http.StatusBadRequest, "Invalid input: "+err.Error())
+		return
+	}
+
+	userID := c.GetInt("userID")
+	user, err := h.UserRepo.GetUserByID(context.Background(), userID)
+	if err != nil {
+		if err.Error() == "user not found" {
+			utils.SendError(c, http.StatusNotFound, err.Error())
+		} else {
+			log.Printf("Error getting user by ID %d: %v", userID, err)
+			utils.SendError(c, http.StatusInternalServerError, "Failed to change password")
+		}
+		return
+	}
+
+	// A stolen access token must not become a way to guess the password
+	clientIP := c.ClientIP()
+	wait, err := h.Throttler.Check(context.Background(), user.Email, clientIP)
+	if err != nil {
+		log.Printf("Error checking login throttle: %v", err)
+		utils.SendError(c, http.StatusInternalServerError, "Failed to change password")
+		return
+	}
+	if wait > 0 {
+		utils.SendRateLimited(c, wait, "Too many failed attempts, try again later")
+		return
+	}
+
+	if !auth.CheckPasswordHash(input.CurrentPassword, user.Password) {
+		if err := h.Throttler.RecordFailure(context.Background(), user.Email, clientIP); err != nil {
+			log.Printf("Error recording failed password check: %v", err)
+		}
+		utils.SendError(c, http.StatusForbidden, "Current password is incorrect")
+		return
+	}
+	if input.NewPassword == input.CurrentPassword {
+		utils.SendError(c, http.StatusBadRequest, "New password must be different from the current one")
+		return
+	}
+	if !checkPasswordPolicy(c, "new_password", input.NewPassword, user.Name, user.Email) {
+		return
+	}
+
+	hashedPassword, err := auth.HashPassword(input.NewPassword)
+	if err != nil {
+		log.Printf("Error hashing password: %v", err)
+		utils.SendError(c, http.StatusInternalServerError, "Failed to change password")
+		return
+	}
+	if err := h.UserRepo.UpdateUserPassword(context.Background(), user.ID, hashedPassword); err != nil {
+		log.Printf("Error updating password for user %d: %v", user.ID, err)
+		utils.SendError(c, http.StatusInternalServerError, "Failed to change password")
+		return
+	}
+
+	// Pending reset links were requested for the old password
+	if err := h.TokenRepo.InvalidateUserTokens(context.Background(), user.ID, models.TokenPurposePasswordReset); err != nil {
+		log.Printf("Warning: Failed to invalidate reset tokens for user %d: %v", user.ID, err)
+	}
+
+	if err := h.Tokens.RevokeAllForUser(context.Background(), user.ID); err != nil {
+		log.Printf("Error revoking sessions after password change for user %d: %v", user.ID, err)
+		utils.SendError(c, http.StatusInternalServerError, "Password changed, but other sessions could not be signed out")
+		return
+	}
+
+	user, err = h.UserRepo.GetUserByID(context.Background(), user.ID) // Reload for the new token version
+	if err != nil {
+		log.Printf("Error reloading user %d: %v", userID, err)
+		utils.SendError(c, http.StatusInternalServerError, "Password changed, please login again")
+		return
+	}
+	tokens, err := h.Tokens.Issue(context.Background(), user, clientInfo(c))
+	if err != nil {
+		log.Printf("Error issuing tokens for user %d: %v", user.ID, err)
+		utils.SendError(c, http.StatusInternalServerError, "Password changed, please login again")
+		return
+	}
+	response, err := tokenResponse(c, tokens)
+	if err != nil {
+		log.Printf("Error setting session cookies for user %d: %v", user.ID, err)
+		utils.SendError(c, http.StatusInternalServerError, "Password changed, please login again")
+		return
+	}
+
+	mailer.SendAsync(h.Mailer, mailer.Message{
+		To:      user.Email,
+		Subject: "Your password was changed",
+		Body: fmt.Sprintf("Hi %s,\n\nThe password for your account was just changed and all other sessions were signed out.\n\n"+
+			"If you did not do this, reset your password right away:\n\n%s\n",
+			user.Name, config.AppConfig.FrontendURL+"/reset-password.html"),
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password changed successfully", "tokens": response})
+}
+
+// checkPasswordPolicy validates a new password and, if it is rejected,
+// responds with every violation under the given input field
+func checkPasswordPolicy(c *gin.Context, field, password, name, email string) bool {
+	err := auth.ValidatePasswordPolicy(password, name, email)
+	if err == nil {
+		return true
+	}
+
+	var policyErr *auth.PasswordPolicyError
+	if errors.As(err, &policyErr) {
+		utils.SendValidationError(c, "Password does not meet the requirements", map[string]interface{}{field: policyErr.Violations})
+	} else {
+		log.Printf("Error checking password policy: %v", err)
+		utils.SendError(c, http.StatusInternalServerError, "Failed to check password")
+	}
+	return false
+}
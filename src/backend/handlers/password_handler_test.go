@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Eduardo-Barreto/web-ponderada/backend/auth"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/config"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/models"
+	"github.com/gin-gonic/gin"
+)
+
+func TestResetPasswordFailsWhenSessionsCannotBeRevoked(t *testing.T) {
+	setupTestAuth()
+	config.AppConfig.Argon2Memory, config.AppConfig.Argon2Iterations, config.AppConfig.Argon2Parallelism = 64, 1, 1
+	users := unrevocableUserRepo{newFakeUserRepo(models.User{ID: 2, Name: "Maria", Email: "maria@example.com", Role: models.RoleUser})}
+	resetTokens := &fakeUserTokenRepo{tokens: []*models.UserToken{{
+		ID:        1,
+		UserID:    2,
+		Purpose:   models.TokenPurposePasswordReset,
+		TokenHash: auth.HashToken("reset-token"),
+		ExpiresAt: time.Now().Add(time.Hour),
+	}}}
+	sessions := &fakeSessionRepo{}
+	revocations := auth.NewRevocationStore(&fakeRevocationRepo{}, users, sessions, 0)
+	tokens := auth.NewTokenIssuer(users, &fakeRefreshTokenRepo{}, sessions, revocations)
+
+	router := gin.New()
+	router.POST("/auth/password/reset", NewPasswordHandler(users, resetTokens, tokens, nil, nil).ResetPassword)
+
+	body, _ := json.Marshal(models.ResetPasswordInput{Token: "reset-token", Password: "Blue-Kettle-42"})
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/auth/password/reset", bytes.NewReader(body)))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("got status %d, want %d so the user knows old sessions may still work", rec.Code, http.StatusInternalServerError)
+	}
+}
+
+func TestEmailLinkRequestsAreLimitedPerEmail(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		handler func(throttler *auth.LoginThrottler) gin.HandlerFunc
+	}{
+		{"password reset", "/auth/password/forgot", func(throttler *auth.LoginThrottler) gin.HandlerFunc {
+			return NewPasswordHandler(newFakeUserRepo(), nil, nil, nil, throttler).ForgotPassword
+		}},
+		{"verification", "/auth/verify/resend", func(throttler *auth.LoginThrottler) gin.HandlerFunc {
+			return NewVerificationHandler(newFakeUserRepo(), nil, throttler).ResendVerification
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestAuth()
+			config.AppConfig.EmailLinkMaxRequests = 2
+			config.AppConfig.EmailLinkWindow = time.Hour
+			router := gin.New()
+			router.POST(tt.path, tt.handler(auth.NewLoginThrottler(&fakeLoginAttemptRepo{})))
+			request := func(email string) int {
+				body, _ := json.Marshal(gin.H{"email": email})
+				rec := httptest.NewRecorder()
+				router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, tt.path, bytes.NewReader(body)))
+				return rec.Code
+			}
+
+			// Unknown emails are counted too, so a 429 does not reveal which exist
+			for i, want := range []int{http.StatusAccepted, http.StatusAccepted, http.StatusTooManyRequests} {
+				if got := request("nobody@example.com"); got != want {
+					t.Errorf("request %d: got status %d, want %d", i+1, got, want)
+				}
+			}
+			if got := request("maria@example.com"); got != http.StatusAccepted {
+				t.Errorf("other email: got status %d, want %d", got, http.StatusAccepted)
+			}
+		})
+	}
+}
@@ -138,7 +138,7 @@ func (h *ProductHandler) UpdateProduct(c *gin.Context) {
 		return
 	}
 
-	// --- Authorization: Ensure user is allowed (e.g., admin) - Skipped for brevity ---
+	// Authorization (admin/editor) is enforced by RequirePermission in the router
 
 	// Similar to Create, parse form fields manually
 	desc := c.PostForm("description")
@@ -237,7 +237,7 @@ func (h *ProductHandler) DeleteProduct(c *gin.Context) {
 		return
 	}
 
-	// --- Authorization Check (e.g., Admin only) - Skipped ---
+	// Authorization (admin/editor) is enforced by RequirePermission in the router
 
     // Get product details to delete the image first
     product, err := h.ProductRepo.GetProductByID(context.Background(), id)
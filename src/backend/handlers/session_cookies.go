@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/Eduardo-Barreto/web-ponderada/backend/auth"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/config"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// Cookie paths: the access token is only needed by the API, the refresh
+// token only by refresh and logout
+const (
+	accessTokenCookiePath  = "/api/"
+	refreshTokenCookiePath = "/api/v1/auth/"
+	csrfCookiePath         = "/"
+)
+
+// cookieSession replaces the token pair in responses to clients using cookies
+type cookieSession struct {
+	TokenType string `json:"token_type"` // Always "Cookie"
+	ExpiresIn int    `json:"expires_in"` // Access token lifetime in seconds
+	CSRFToken string `json:"csrf_token"` // Must be sent back in the X-CSRF-Token header
+}
+
+// usesCookies reports whether tokens for this request should be set as
+// cookies: the client asked for it at login, or is already using them
+func usesCookies(c *gin.Context) bool {
+	if !config.AppConfig.SessionCookies {
+		return false
+	}
+	return c.GetHeader(auth.AuthModeHeader) == "cookie" || c.GetBool("cookieAuth")
+}
+
+// tokenResponse returns what to send the client for a new token pair: the
+// pair itself, or a cookieSession after setting the tokens as cookies
+func tokenResponse(c *gin.Context, tokens *auth.TokenPair) (interface{}, error) {
+	if !usesCookies(c) {
+		return tokens, nil
+	}
+	return setSessionCookies(c, tokens)
+}
+
+// setSessionCookies stores a token pair in HttpOnly cookies along with a
+// fresh CSRF token
+func setSessionCookies(c *gin.Context, tokens *auth.TokenPair) (*cookieSession, error) {
+	csrfToken, err := auth.GenerateOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	refreshMaxAge := int(config.AppConfig.RefreshTokenTTL.Seconds())
+	setCookie(c, auth.AccessTokenCookie, tokens.AccessToken, accessTokenCookiePath, tokens.ExpiresIn, true)
+	setCookie(c, auth.RefreshTokenCookie, tokens.RefreshToken, refreshTokenCookiePath, refreshMaxAge, true)
+	setCookie(c, auth.CSRFCookie, csrfToken, csrfCookiePath, refreshMaxAge, false) // Read by the frontend
+
+	return &cookieSession{TokenType: "Cookie", ExpiresIn: tokens.ExpiresIn, CSRFToken: csrfToken}, nil
+}
+
+// clearSessionCookies removes the session cookies, if the client had any
+func clearSessionCookies(c *gin.Context) {
+	if !config.AppConfig.SessionCookies {
+		return
+	}
+	setCookie(c, auth.AccessTokenCookie, "", accessTokenCookiePath, -1, true)
+	setCookie(c, auth.RefreshTokenCookie, "", refreshTokenCookiePath, -1, true)
+	setCookie(c, auth.CSRFCookie, "", csrfCookiePath, -1, false)
+}
+
+func setCookie(c *gin.Context, name, value, path string, maxAge int, httpOnly bool) {
+	c.SetSameSite(cookieSameSite())
+	c.SetCookie(name, value, maxAge, path, config.AppConfig.CookieDomain, config.AppConfig.CookieSecure, httpOnly)
+}
+
+func cookieSameSite() http.SameSite {
+	switch config.AppConfig.CookieSameSite {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	default:
+		return http.SameSiteLaxMode
+	}
+}
+
+// refreshTokenCookie returns the refresh token cookie, if cookie sessions
+// are enabled and the client has one. Since browsers attach it on their own,
+// the request must carry the CSRF token too; ok is false after responding
+// with an error.
+func refreshTokenCookie(c *gin.Context) (token string, ok bool) {
+	if !config.AppConfig.SessionCookies {
+		return "", true
+	}
+	token, err := c.Cookie(auth.RefreshTokenCookie)
+	if err != nil || token == "" {
+		return "", true
+	}
+
+	csrfCookie, _ := c.Cookie(auth.CSRFCookie)
+	if !auth.CSRFTokenMatches(csrfCookie, c.GetHeader(auth.CSRFHeader)) {
+		utils.SendError(c, http.StatusForbidden, "Missing or invalid CSRF token")
+		return "", false
+	}
+	c.Set("cookieAuth", true) // Keep answering with cookies
+	return token, true
+}
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/Eduardo-Barreto/web-ponderada/backend/auth"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/config"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/repository"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// SessionHandler lets users see and end their own login sessions
+type SessionHandler struct {
+	SessionRepo repository.SessionRepository
+	Tokens      *auth.TokenIssuer
+}
+
+func NewSessionHandler(sessionRepo repository.SessionRepository, tokens *auth.TokenIssuer) *SessionHandler {
+	return &SessionHandler{SessionRepo: sessionRepo, Tokens: tokens}
+}
+
+// GetSessions lists the user's active sessions, most recently seen first
+func (h *SessionHandler) GetSessions(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	// Sessions idle for longer than a refresh token lives cannot be resumed
+	seenSince := time.Now().Add(-config.AppConfig.RefreshTokenTTL)
+	sessions, err := h.SessionRepo.GetActiveSessions(context.Background(), userID, seenSince)
+	if err != nil {
+		log.Printf("Error getting sessions for user %d: %v", userID, err)
+		utils.SendError(c, http.StatusInternalServerError, "Failed to retrieve sessions")
+		return
+	}
+
+	claims := c.MustGet("tokenClaims").(*auth.Claims)
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == claims.SessionID
+	}
+
+	c.JSON(http.StatusOK, sessions)
+}
+
+// DeleteSession signs one of the user's sessions out
+func (h *SessionHandler) DeleteSession(c *gin.Context) {
+	sessionID := c.Param("id")
+	if _, err := uuid.Parse(sessionID); err != nil {
+		utils.SendError(c, http.StatusBadRequest, "Invalid session ID format")
+		return
+	}
+
+	userID := c.GetInt("userID")
+	if err := h.Tokens.RevokeSession(context.Background(), userID, sessionID); err != nil {
+		if err.Error() == "session not found" {
+			utils.SendError(c, http.StatusNotFound, "Session not found or already signed out")
+		} else {
+			log.Printf("Error revoking session %s for user %d: %v", sessionID, userID, err)
+			utils.SendError(c, http.StatusInternalServerError, "Failed to sign out session")
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Session signed out"})
+}
@@ -1,47 +1,244 @@
 package handlers
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"path"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/Eduardo-Barreto/web-ponderada/backend/attributes"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/auth"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/config"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/images"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/mailer"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/middleware"
 	"github.com/Eduardo-Barreto/web-ponderada/backend/models"
 	"github.com/Eduardo-Barreto/web-ponderada/backend/repository"
 	"github.com/Eduardo-Barreto/web-ponderada/backend/utils"
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 )
 
 type UserHandler struct {
-	UserRepo repository.UserRepository
-	FileRepo repository.StorageRepository // Inject file repo for profile pics
+	UserRepo    repository.UserRepository
+	FileRepo    repository.StorageRepository // Inject file repo for profile pics
+	Revocations *auth.RevocationStore
+	Tokens      *auth.TokenIssuer
+	Mailer      mailer.Mailer
+	Attributes  *attributes.Service
 }
 
-func NewUserHandler(userRepo repository.UserRepository, fileRepo repository.StorageRepository) *UserHandler {
-	return &UserHandler{UserRepo: userRepo, FileRepo: fileRepo}
+func NewUserHandler(userRepo repository.UserRepository, fileRepo repository.StorageRepository, revocations *auth.RevocationStore, tokens *auth.TokenIssuer, m mailer.Mailer, attributeService *attributes.Service) *UserHandler {
+	return &UserHandler{UserRepo: userRepo, FileRepo: fileRepo, Revocations: revocations, Tokens: tokens, Mailer: m, Attributes: attributeService}
 }
 
-// GetUsers retrieves a list of all users
+// Page sizes for GET /users
+const (
+	defaultUserPageSize = 50
+	maxUserPageSize     = 200
+)
+
+// Attribute filters allowed in one GET /users request
+const maxUserAttributeFilters = 5
+
+// GetUsers retrieves one page of users. Supports ?limit=, ?cursor= (from the
+// previous page), ?q= (name or email), ?created_after= and ?created_before=
+// (RFC 3339 or YYYY-MM-DD), ?verified=true|false, ?attr.<key>=<value>
+// (attribute equals value, compared as text) and ?sort= (name, email or
+// created_at, "-" prefix for descending).
 func (h *UserHandler) GetUsers(c *gin.Context) {
-	users, err := h.UserRepo.GetAllUsers(context.Background())
+	query, err := parseUserListQuery(c)
 	if err != nil {
-		log.Printf("Error getting all users: %v", err)
+		utils.SendError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	// Attributes are personal data; listing users only needs users:read
+	if len(query.Attributes) > 0 && !middleware.HasPermission(c, auth.PermUsersManage) {
+		utils.SendError(c, http.StatusForbidden, "Filtering by attributes requires the users:manage permission")
+		return
+	}
+
+	// One extra row tells whether another page follows
+	limit := query.Limit
+	query.Limit++
+	users, err := h.UserRepo.GetUsers(context.Background(), query)
+	if err != nil {
+		log.Printf("Error getting users: %v", err)
 		utils.SendError(c, http.StatusInternalServerError, "Failed to retrieve users")
 		return
 	}
-	c.JSON(http.StatusOK, users)
+
+	pagination := gin.H{"limit": limit, "has_more": false, "next_cursor": nil}
+	if len(users) > limit {
+		users = users[:limit]
+		sort := query.Sort
+		if query.Descending {
+			sort = "-" + sort
+		}
+		next, err := encodeUserCursor(userCursor(sort, users[limit-1]))
+		if err != nil {
+			log.Printf("Error encoding user cursor: %v", err)
+			utils.SendError(c, http.StatusInternalServerError, "Failed to retrieve users")
+			return
+		}
+		pagination["has_more"] = true
+		pagination["next_cursor"] = next
+
+		params := c.Request.URL.Query()
+		params.Set("cursor", next)
+		c.Header("Link", fmt.Sprintf(`<%s%s?%s>; rel="next"`, config.AppConfig.PublicURL, c.Request.URL.Path, params.Encode()))
+	}
+
+	for i := range users {
+		users[i].Attributes = nil // Left out of the list, see GET /users/:id
+	}
+	c.JSON(http.StatusOK, gin.H{"data": users, "pagination": pagination})
 }
 
-// GetUser retrieves a single user by ID
-func (h *UserHandler) GetUser(c *gin.Context) {
-	idStr := c.Param("id")
-	id, err := strconv.Atoi(idStr)
+// parseUserListQuery reads and validates the GET /users query string
+func parseUserListQuery(c *gin.Context) (*models.UserListQuery, error) {
+	query := &models.UserListQuery{
+		Search: strings.TrimSpace(c.Query("q")),
+		Limit:  defaultUserPageSize,
+	}
+
+	if limitStr := c.Query("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > maxUserPageSize {
+			return nil, fmt.Errorf("limit must be a number between 1 and %d", maxUserPageSize)
+		}
+		query.Limit = limit
+	}
+
+	sort := c.Query("sort")
+	if sort == "" {
+		sort = "name"
+	}
+	query.Sort = strings.TrimPrefix(sort, "-")
+	query.Descending = strings.HasPrefix(sort, "-")
+	if !slices.Contains(models.UserSortFields, query.Sort) {
+		return nil, fmt.Errorf("sort must be one of %s, optionally prefixed with -", strings.Join(models.UserSortFields, ", "))
+	}
+
+	// created_after is inclusive, created_before exclusive
+	for _, bound := range []struct {
+		param  string
+		target **time.Time
+	}{{"created_after", &query.CreatedAfter}, {"created_before", &query.CreatedBefore}} {
+		if value := c.Query(bound.param); value != "" {
+			t, err := parseTimeParam(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s must be an RFC 3339 timestamp or a YYYY-MM-DD date", bound.param)
+			}
+			*bound.target = &t
+		}
+	}
+
+	if verifiedStr := c.Query("verified"); verifiedStr != "" {
+		verified, err := strconv.ParseBool(verifiedStr)
+		if err != nil {
+			return nil, errors.New("verified must be true or false")
+		}
+		query.Verified = &verified
+	}
+
+	for param, values := range c.Request.URL.Query() {
+		key, ok := strings.CutPrefix(param, "attr.")
+		if !ok {
+			continue
+		}
+		if key == "" || len(values) != 1 {
+			return nil, fmt.Errorf("%s must name an attribute and be given once", param)
+		}
+		if query.Attributes == nil {
+			query.Attributes = map[string]string{}
+		}
+		query.Attributes[key] = values[0]
+	}
+	if len(query.Attributes) > maxUserAttributeFilters {
+		return nil, fmt.Errorf("at most %d attribute filters are allowed", maxUserAttributeFilters)
+	}
+
+	if cursorStr := c.Query("cursor"); cursorStr != "" {
+		cursor, err := decodeUserCursor(cursorStr)
+		// A cursor only marks a position within the order it was made for
+		if err != nil || cursor.Sort != sort {
+			return nil, errors.New("invalid cursor")
+		}
+		if query.Sort == "created_at" {
+			if _, err := time.Parse(time.RFC3339Nano, cursor.Value); err != nil {
+				return nil, errors.New("invalid cursor")
+			}
+		}
+		query.After = cursor
+	}
+
+	return query, nil
+}
+
+func parseTimeParam(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, value)
+}
+
+// userCursor returns the position of a user in the given sort order
+func userCursor(sort string, user models.User) *models.UserCursor {
+	cursor := &models.UserCursor{Sort: sort, ID: user.ID}
+	switch strings.TrimPrefix(sort, "-") {
+	case "name":
+		cursor.Value = user.Name
+	case "email":
+		cursor.Value = user.Email
+	case "created_at":
+		cursor.Value = user.CreatedAt.Format(time.RFC3339Nano)
+	}
+	return cursor
+}
+
+func encodeUserCursor(cursor *models.UserCursor) (string, error) {
+	data, err := json.Marshal(cursor)
 	if err != nil {
-		utils.SendError(c, http.StatusBadRequest, "Invalid user ID format")
-		return
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodeUserCursor(value string) (*models.UserCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	cursor := &models.UserCursor{}
+	if err := json.Unmarshal(data, cursor); err != nil {
+		return nil, err
+	}
+	return cursor, nil
+}
+
+// GetMe returns the logged-in user's own profile
+func (h *UserHandler) GetMe(c *gin.Context) {
+	h.getUser(c, c.GetInt("userID"))
+}
+
+// GetUser retrieves a single user by ID (admin only, enforced by the router)
+func (h *UserHandler) GetUser(c *gin.Context) {
+	if id, ok := userIDParam(c); ok {
+		h.getUser(c, id)
 	}
+}
 
+func (h *UserHandler) getUser(c *gin.Context, id int) {
 	user, err := h.UserRepo.GetUserByID(context.Background(), id)
 	if err != nil {
 		// Check if the error is "user not found"
@@ -59,29 +256,20 @@ func (h *UserHandler) GetUser(c *gin.Context) {
 	c.JSON(http.StatusOK, user)
 }
 
-// UpdateUser handles updating user information (name, email)
-func (h *UserHandler) UpdateUser(c *gin.Context) {
-	idStr := c.Param("id")
-	id, err := strconv.Atoi(idStr)
-	if err != nil {
-		utils.SendError(c, http.StatusBadRequest, "Invalid user ID format")
-		return
-	}
+// UpdateMe partially updates the logged-in user's own name, email and attributes
+func (h *UserHandler) UpdateMe(c *gin.Context) {
+	h.updateUser(c, c.GetInt("userID"))
+}
 
-	// --- Authorization Check ---
-	// Get user ID from token (set by middleware)
-	tokenUserID, exists := c.Get("userID")
-	if !exists {
-		utils.SendError(c, http.StatusUnauthorized, "Unauthorized access")
-		return
-	}
-	// Check if the user making the request is the user being updated
-	if tokenUserID.(int) != id {
-		utils.SendError(c, http.StatusForbidden, "You can only update your own profile")
-		return
+// UpdateUser handles updating user information (name, email, attributes) of any user
+// (admin only, enforced by the router)
+func (h *UserHandler) UpdateUser(c *gin.Context) {
+	if id, ok := userIDParam(c); ok {
+		h.updateUser(c, id)
 	}
-	// --- End Authorization Check ---
+}
 
+func (h *UserHandler) updateUser(c *gin.Context, id int) {
 	var input models.UserUpdateInput
 	if err := c.ShouldBindJSON(&input); err != nil {
 		utils.SendError(c, http.StatusBadRequest, "Invalid input data: "+err.Error())
@@ -89,12 +277,29 @@ func (h *UserHandler) UpdateUser(c *gin.Context) {
 	}
 
 	// Prevent updating fields that aren't allowed or empty request
-	if input.Name == nil && input.Email == nil {
+	if input.Name == nil && input.Email == nil && input.Attributes == nil {
 		utils.SendError(c, http.StatusBadRequest, "No update fields provided")
 		return
 	}
 
-	err = h.UserRepo.UpdateUser(context.Background(), id, &input)
+	// The result of the merge has to match the schema, not just the keys sent
+	if input.Attributes != nil {
+		user, err := h.UserRepo.GetUserByID(context.Background(), id)
+		if err != nil {
+			if err.Error() == "user not found" {
+				utils.SendError(c, http.StatusNotFound, err.Error())
+			} else {
+				log.Printf("Error getting user by ID %d: %v", id, err)
+				utils.SendError(c, http.StatusInternalServerError, "Failed to update user")
+			}
+			return
+		}
+		if !h.validateAttributes(c, attributes.Merge(user.Attributes, input.Attributes)) {
+			return
+		}
+	}
+
+	err := h.UserRepo.UpdateUser(context.Background(), id, &input)
 	if err != nil {
 		if err.Error() == "user not found or no changes made" {
 			utils.SendError(c, http.StatusNotFound, "User not found or no changes were necessary")
@@ -107,61 +312,146 @@ func (h *UserHandler) UpdateUser(c *gin.Context) {
 		return
 	}
 
+	// A new address starts out unverified; send it a fresh link
+	if input.Email != nil {
+		user, err := h.UserRepo.GetUserByID(context.Background(), id)
+		if err != nil {
+			log.Printf("Warning: Could not fetch user %d to send verification email: %v", id, err)
+		} else if user.EmailVerifiedAt == nil {
+			if err := sendVerificationEmail(h.Mailer, user); err != nil {
+				log.Printf("Error preparing verification email for user %d: %v", id, err)
+			}
+		}
+	}
+
 	c.JSON(http.StatusOK, gin.H{"message": "User updated successfully"})
 }
 
-// UploadProfilePic handles uploading a new profile picture for a user
-func (h *UserHandler) UploadProfilePic(c *gin.Context) {
-	idStr := c.Param("id")
-	id, err := strconv.Atoi(idStr)
+// validateAttributes checks attributes against the current schema, sending an
+// error response on failure
+func (h *UserHandler) validateAttributes(c *gin.Context, attrs map[string]interface{}) bool {
+	err := h.Attributes.Validate(context.Background(), attrs)
+	if err == nil {
+		return true
+	}
+	var attrErr *attributes.Error
+	if errors.As(err, &attrErr) {
+		fields := make(map[string]interface{}, len(attrErr.Fields))
+		for location, messages := range attrErr.Fields {
+			fields["attributes"+location] = messages
+		}
+		utils.SendValidationError(c, "Attributes do not match the schema", fields)
+	} else {
+		log.Printf("Error validating attributes: %v", err)
+		utils.SendError(c, http.StatusInternalServerError, "Failed to update user")
+	}
+	return false
+}
+
+// GetAttributeSchema returns the JSON Schema user attributes must match, so
+// clients can build their profile forms from it
+func (h *UserHandler) GetAttributeSchema(c *gin.Context) {
+	schema, err := h.Attributes.CurrentSchema(context.Background())
 	if err != nil {
-		utils.SendError(c, http.StatusBadRequest, "Invalid user ID format")
+		log.Printf("Error getting attribute schema: %v", err)
+		utils.SendError(c, http.StatusInternalServerError, "Failed to retrieve attribute schema")
 		return
 	}
+	c.JSON(http.StatusOK, schema)
+}
 
-	// --- Authorization Check ---
-	tokenUserID, exists := c.Get("userID")
-	if !exists {
-		utils.SendError(c, http.StatusUnauthorized, "Unauthorized access")
+// SetAttributeSchema replaces the attribute schema (admin only, enforced by
+// the router). Stored attributes are checked against it on their next change.
+func (h *UserHandler) SetAttributeSchema(c *gin.Context) {
+	var input models.AttributeSchemaInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.SendError(c, http.StatusBadRequest, "Invalid input data: "+err.Error())
 		return
 	}
-	if tokenUserID.(int) != id {
-		utils.SendError(c, http.StatusForbidden, "You can only update your own profile picture")
+
+	adminID := c.GetInt("userID")
+	schema, err := h.Attributes.SetSchema(context.Background(), input.Schema, adminID)
+	if err != nil {
+		var attrErr *attributes.Error
+		if errors.As(err, &attrErr) {
+			utils.SendValidationError(c, "Invalid attribute schema", map[string]interface{}{"schema": attrErr.Fields["schema"]})
+		} else {
+			log.Printf("Error saving attribute schema: %v", err)
+			utils.SendError(c, http.StatusInternalServerError, "Failed to save attribute schema")
+		}
 		return
 	}
-	// --- End Authorization Check ---
 
-	file, err := c.FormFile("profile_pic") // Name of the form field
+	log.Printf("Attribute schema version %d saved by admin %d", schema.ID, adminID)
+	c.JSON(http.StatusOK, schema)
+}
+
+// UploadMyProfilePic replaces the logged-in user's own profile picture
+func (h *UserHandler) UploadMyProfilePic(c *gin.Context) {
+	h.uploadProfilePic(c, c.GetInt("userID"))
+}
+
+// UploadProfilePic handles uploading a new profile picture for any user
+// (admin only, enforced by the router)
+func (h *UserHandler) UploadProfilePic(c *gin.Context) {
+	if id, ok := userIDParam(c); ok {
+		h.uploadProfilePic(c, id)
+	}
+}
+
+func (h *UserHandler) uploadProfilePic(c *gin.Context, id int) {
+	fileHeader, err := c.FormFile("profile_pic") // Name of the form field
 	if err != nil {
 		utils.SendError(c, http.StatusBadRequest, "Profile picture file is required: "+err.Error())
 		return
 	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		log.Printf("Error opening uploaded profile picture for user %d: %v", id, err)
+		utils.SendError(c, http.StatusInternalServerError, "Failed to read profile picture")
+		return
+	}
+	defer file.Close()
 
-	// Optional: Delete old picture before saving new one
-	currentUser, err := h.UserRepo.GetUserByID(context.Background(), id)
-	if err == nil && currentUser.ProfilePic != "" {
-		err := h.FileRepo.DeleteFile(context.Background(), currentUser.ProfilePic)
-		if err != nil {
-			// Log the error but don't necessarily block the upload
-			log.Printf("Warning: Failed to delete old profile picture '%s' for user %d: %v", currentUser.ProfilePic, id, err)
+	// Only the square variants are kept, never the uploaded file itself
+	ext, variants, err := images.ProfilePicVariants(file, config.AppConfig.ProfilePicSizes)
+	if err != nil {
+		if errors.Is(err, images.ErrUnsupportedImage) || errors.Is(err, images.ErrImageTooLarge) {
+			utils.SendError(c, http.StatusBadRequest, err.Error())
+		} else {
+			log.Printf("Error processing profile picture for user %d: %v", id, err)
+			utils.SendError(c, http.StatusInternalServerError, "Failed to process profile picture")
 		}
-	} else if err != nil {
-		log.Printf("Warning: Could not fetch user %d to check for old profile pic: %v", id, err)
+		return
 	}
 
-	// Save the file using the storage repository (e.g., to "uploads/users/")
-	filename, err := h.FileRepo.SaveFile(context.Background(), file, "users")
+	base := path.Join("users", uuid.New().String()+ext)
+	paths := make(map[string]string, len(variants))
+	var saved []string
+	for _, variant := range variants {
+		filename := images.VariantPath(base, variant.Size)
+		if err := h.FileRepo.SaveContent(context.Background(), filename, bytes.NewReader(variant.Data)); err != nil {
+			log.Printf("Error saving profile picture for user %d: %v", id, err)
+			h.deleteFiles(saved)
+			utils.SendError(c, http.StatusInternalServerError, "Failed to save profile picture: "+err.Error())
+			return
+		}
+		saved = append(saved, filename)
+		paths[strconv.Itoa(variant.Size)] = filename
+	}
+	// The largest variant stands in for the picture itself
+	filename := saved[len(saved)-1]
+
+	currentUser, err := h.UserRepo.GetUserByID(context.Background(), id)
 	if err != nil {
-		log.Printf("Error saving profile picture for user %d: %v", id, err)
-		utils.SendError(c, http.StatusInternalServerError, "Failed to save profile picture: "+err.Error())
-		return
+		log.Printf("Warning: Could not fetch user %d to check for old profile pic: %v", id, err)
 	}
 
-	// Update the user record in the database with the new filename
-	err = h.UserRepo.UpdateUserProfilePic(context.Background(), id, filename)
+	// Update the user record in the database with the new files
+	err = h.UserRepo.UpdateUserProfilePic(context.Background(), id, filename, paths)
 	if err != nil {
-		// If DB update fails, try to delete the just uploaded file
-		h.FileRepo.DeleteFile(context.Background(), filename) // Best effort deletion
+		// If DB update fails, delete the just uploaded files
+		h.deleteFiles(saved)
 		log.Printf("Error updating user profile pic in DB for user %d: %v", id, err)
 		if err.Error() == "user not found" {
 			utils.SendError(c, http.StatusNotFound, "User not found")
@@ -171,62 +461,149 @@ func (h *UserHandler) UploadProfilePic(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Profile picture updated successfully", "filename": filename})
+	// The old picture only goes once the new one is in place
+	if currentUser != nil {
+		h.deleteFiles(images.ProfilePicFiles(currentUser))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Profile picture updated successfully", "filename": filename, "variants": paths})
 }
 
-// DeleteUser handles deleting a user
-func (h *UserHandler) DeleteUser(c *gin.Context) {
-	idStr := c.Param("id")
-	id, err := strconv.Atoi(idStr)
+// deleteFiles removes stored files, logging rather than failing on errors
+func (h *UserHandler) deleteFiles(filenames []string) {
+	for _, filename := range filenames {
+		if err := h.FileRepo.DeleteFile(context.Background(), filename); err != nil {
+			log.Printf("Warning: Failed to delete file '%s': %v", filename, err)
+		}
+	}
+}
+
+// DeleteMe closes the logged-in user's own account. Nothing is removed right
+// away: every session is signed out and, unless the user logs in again within
+// ACCOUNT_DELETION_GRACE_DAYS, the account is then anonymised.
+func (h *UserHandler) DeleteMe(c *gin.Context) {
+	userID := c.GetInt("userID")
+	user, err := h.UserRepo.GetUserByID(context.Background(), userID)
 	if err != nil {
-		utils.SendError(c, http.StatusBadRequest, "Invalid user ID format")
+		if err.Error() == "user not found" {
+			utils.SendError(c, http.StatusNotFound, err.Error())
+		} else {
+			log.Printf("Error getting user by ID %d: %v", userID, err)
+			utils.SendError(c, http.StatusInternalServerError, "Failed to schedule account deletion")
+		}
 		return
 	}
 
-	// --- Authorization Check ---
-	// Maybe only admins can delete, or users can delete themselves
-	tokenUserID, exists := c.Get("userID")
-	if !exists {
-		utils.SendError(c, http.StatusUnauthorized, "Unauthorized access")
+	deleteAt := time.Now().AddDate(0, 0, config.AppConfig.AccountDeletionGraceDays)
+	if user.DeletionScheduledAt != nil {
+		deleteAt = *user.DeletionScheduledAt // Asking again does not push it back
+	}
+	if err := h.UserRepo.ScheduleUserDeletion(context.Background(), userID, deleteAt); err != nil {
+		log.Printf("Error scheduling deletion of user %d: %v", userID, err)
+		utils.SendError(c, http.StatusInternalServerError, "Failed to schedule account deletion")
 		return
 	}
-	// For simplicity, allow users to delete themselves. Add admin logic if needed.
-	if tokenUserID.(int) != id {
-		utils.SendError(c, http.StatusForbidden, "You can only delete your own account")
+
+	// Logging in is what cancels the closure, so no session may outlive the
+	// request. Scheduling again keeps the date, so the client can just retry.
+	if err := h.Tokens.RevokeAllForUser(context.Background(), userID); err != nil {
+		log.Printf("Error signing out user %d after scheduling deletion: %v", userID, err)
+		utils.SendError(c, http.StatusInternalServerError, "Failed to schedule account deletion")
 		return
 	}
-	// --- End Authorization Check ---
+	clearSessionCookies(c)
 
-	// Important: Delete associated profile picture file first!
-	user, err := h.UserRepo.GetUserByID(context.Background(), id)
+	mailer.SendAsync(h.Mailer, mailer.Message{
+		To:      user.Email,
+		Subject: "Your account is scheduled for deletion",
+		Body: fmt.Sprintf("Hi %s,\n\nAs requested, your account will be deleted on %s. Until then you can keep it by simply logging in again; "+
+			"after that date your personal data is removed for good.\n", user.Name, deleteAt.UTC().Format(time.RFC1123)),
+	})
+
+	log.Printf("User %d scheduled their account for deletion on %s", userID, deleteAt.Format(time.RFC3339))
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":               "Account scheduled for deletion. Log in again before then to cancel.",
+		"deletion_scheduled_at": deleteAt,
+	})
+}
+
+// DeleteUser handles deleting any user (admin only, enforced by the router)
+func (h *UserHandler) DeleteUser(c *gin.Context) {
+	if id, ok := userIDParam(c); ok && h.deleteUser(c, id) {
+		c.JSON(http.StatusOK, gin.H{"message": "User deleted successfully"})
+	}
+}
+
+// deleteUser soft-deletes a user, sending an error response on failure. The
+// profile picture is kept until the account is purged, so it can be restored.
+func (h *UserHandler) deleteUser(c *gin.Context, id int) bool {
+	// Signed out first, while the account still exists: a restore must not
+	// bring back sessions or refresh tokens issued before the deletion
+	err := h.Tokens.RevokeAllForUser(context.Background(), id)
+	if err == nil {
+		err = h.UserRepo.DeleteUser(context.Background(), id)
+	}
 	if err != nil {
 		if err.Error() == "user not found" {
 			utils.SendError(c, http.StatusNotFound, err.Error())
 		} else {
-			log.Printf("Error finding user %d before deletion: %v", id, err)
-			utils.SendError(c, http.StatusInternalServerError, "Failed to retrieve user before deletion")
+			log.Printf("Error deleting user ID %d: %v", id, err)
+			utils.SendError(c, http.StatusInternalServerError, "Failed to delete user")
 		}
+		return false
+	}
+
+	log.Printf("User %d deleted by user %d", id, c.GetInt("userID"))
+	return true
+}
+
+// userIDParam parses the :id route parameter, sending an error response on failure
+func userIDParam(c *gin.Context) (int, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		utils.SendError(c, http.StatusBadRequest, "Invalid user ID format")
+		return 0, false
+	}
+	return id, true
+}
+
+// UpdateUserRole changes a user's role (admin only, enforced by the router)
+func (h *UserHandler) UpdateUserRole(c *gin.Context) {
+	id, ok := userIDParam(c)
+	if !ok {
 		return
 	}
 
-	if user.ProfilePic != "" {
-		err := h.FileRepo.DeleteFile(context.Background(), user.ProfilePic)
-		if err != nil {
-			// Log error, but proceed with DB deletion anyway
-			log.Printf("Warning: Failed to delete profile picture '%s' for user %d during deletion: %v", user.ProfilePic, id, err)
-		}
+	var input models.UserRoleInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.SendError(c, http.StatusBadRequest, "Invalid input data: "+err.Error())
+		return
+	}
+
+	// Prevent the last admin from locking everyone out by demoting themselves
+	if c.GetInt("userID") == id && input.Role != models.RoleAdmin {
+		utils.SendError(c, http.StatusBadRequest, "You cannot remove your own admin role")
+		return
 	}
 
-	err = h.UserRepo.DeleteUser(context.Background(), id)
+	err := h.UserRepo.UpdateUserRole(context.Background(), id, input.Role)
 	if err != nil {
 		if err.Error() == "user not found" {
 			utils.SendError(c, http.StatusNotFound, err.Error())
 		} else {
-			log.Printf("Error deleting user ID %d: %v", id, err)
-			utils.SendError(c, http.StatusInternalServerError, "Failed to delete user")
+			log.Printf("Error updating role for user ID %d: %v", id, err)
+			utils.SendError(c, http.StatusInternalServerError, "Failed to update user role")
 		}
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "User deleted successfully"})
+	// Tokens carry the role, so sign the user out everywhere rather than let
+	// ones issued with the old role run out. Repeating the request retries this.
+	if err := h.Tokens.RevokeAllForUser(context.Background(), id); err != nil {
+		log.Printf("Error revoking tokens after role change for user %d: %v", id, err)
+		utils.SendError(c, http.StatusInternalServerError, "Role updated, but existing sessions could not be signed out")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User role updated successfully"})
 }
@@ -0,0 +1,299 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Eduardo-Barreto/web-ponderada/backend/auth"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/config"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/models"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/repository"
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+func TestDeletedUserTokensStayInvalidAfterRestore(t *testing.T) {
+	setupTestAuth()
+	users := newFakeUserRepo(
+		models.User{ID: 1, Name: "Admin", Email: "admin@example.com", Role: models.RoleAdmin},
+		models.User{ID: 2, Name: "Maria", Email: "maria@example.com", Role: models.RoleUser},
+	)
+	sessions := &fakeSessionRepo{}
+	revocations := auth.NewRevocationStore(&fakeRevocationRepo{}, users, sessions, 0)
+	tokens := auth.NewTokenIssuer(users, &fakeRefreshTokenRepo{}, sessions, revocations)
+	auth.SetRevocationStore(revocations)
+	t.Cleanup(func() { auth.SetRevocationStore(nil) })
+
+	maria, _ := users.GetUserByID(context.Background(), 2)
+	pair, err := tokens.Issue(context.Background(), maria, auth.ClientInfo{})
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set("userID", 1) })
+	userHandler := NewUserHandler(users, nil, revocations, tokens, nil, nil)
+	adminHandler := NewAdminHandler(users, nil, nil)
+	authHandler := NewAuthHandler(users, tokens, revocations, nil, nil, nil)
+	router.DELETE("/users/:id", userHandler.DeleteUser)
+	router.POST("/admin/users/:id/restore", adminHandler.RestoreUser)
+	router.POST("/auth/refresh", authHandler.Refresh)
+
+	send := func(method, path string, body interface{}) int {
+		var buf bytes.Buffer
+		if body != nil {
+			json.NewEncoder(&buf).Encode(body)
+		}
+		req := httptest.NewRequest(method, path, &buf)
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := send(http.MethodDelete, "/users/"+strconv.Itoa(maria.ID), nil); code != http.StatusOK {
+		t.Fatalf("delete: got status %d", code)
+	}
+	if code := send(http.MethodPost, "/admin/users/"+strconv.Itoa(maria.ID)+"/restore", nil); code != http.StatusOK {
+		t.Fatalf("restore: got status %d", code)
+	}
+
+	if code := send(http.MethodPost, "/auth/refresh", models.RefreshInput{RefreshToken: pair.RefreshToken}); code != http.StatusUnauthorized {
+		t.Errorf("refresh with a token issued before the deletion: got status %d, want %d", code, http.StatusUnauthorized)
+	}
+	claims, err := auth.ValidateToken(pair.AccessToken)
+	if err == nil {
+		t.Errorf("access token issued before the deletion is still accepted (claims %+v)", claims)
+	}
+}
+
+// Fails to sign users out, as when the database goes away mid-request
+type unrevocableUserRepo struct {
+	*fakeUserRepo
+}
+
+func (r unrevocableUserRepo) IncrementUserTokenVersion(ctx context.Context, id int) (int, error) {
+	return 0, errors.New("failed to increment token version: connection reset")
+}
+
+func TestDeleteMeFailsWhenSessionsCannotBeRevoked(t *testing.T) {
+	setupTestAuth()
+	config.AppConfig.AccountDeletionGraceDays = 14
+	users := unrevocableUserRepo{newFakeUserRepo(models.User{ID: 2, Name: "Maria", Email: "maria@example.com", Role: models.RoleUser})}
+	sessions := &fakeSessionRepo{}
+	revocations := auth.NewRevocationStore(&fakeRevocationRepo{}, users, sessions, 0)
+	tokens := auth.NewTokenIssuer(users, &fakeRefreshTokenRepo{}, sessions, revocations)
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set("userID", 2) })
+	router.DELETE("/users/me", NewUserHandler(users, nil, revocations, tokens, nil, nil).DeleteMe)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/users/me", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("got status %d, want %d so the client retries", rec.Code, http.StatusInternalServerError)
+	}
+}
+
+func TestGetUsersHidesAttributes(t *testing.T) {
+	setupTestAuth()
+	users := newFakeUserRepo(
+		models.User{ID: 1, Name: "Admin", Email: "admin@example.com", Role: models.RoleAdmin},
+		models.User{ID: 2, Name: "Maria", Email: "maria@example.com", Role: models.RoleUser, Attributes: map[string]interface{}{"phone": "+55 11 91234-5678"}},
+	)
+	handler := NewUserHandler(users, nil, nil, nil, nil, nil)
+
+	tests := []struct {
+		name       string
+		role       string
+		apiKey     *models.APIKey
+		query      string
+		wantStatus int
+	}{
+		{"user lists", models.RoleUser, nil, "", http.StatusOK},
+		{"admin lists", models.RoleAdmin, nil, "", http.StatusOK},
+		{"user filters by attribute", models.RoleUser, nil, "?attr.phone=%2B55", http.StatusForbidden},
+		{"editor filters by attribute", models.RoleEditor, nil, "?attr.phone=%2B55", http.StatusForbidden},
+		{"API key filters by attribute", "", &models.APIKey{Scopes: []string{auth.PermUsersRead}}, "?attr.phone=%2B55", http.StatusForbidden},
+		{"admin filters by attribute", models.RoleAdmin, nil, "?attr.phone=%2B55", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.Use(func(c *gin.Context) {
+				c.Set("userRole", tt.role)
+				if tt.apiKey != nil {
+					c.Set("apiKey", tt.apiKey)
+				}
+			})
+			router.GET("/users", handler.GetUsers)
+
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/users"+tt.query, nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+
+			var body struct {
+				Data []map[string]interface{} `json:"data"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("decoding response: %v", err)
+			}
+			if len(body.Data) != 2 {
+				t.Fatalf("got %d users, want 2", len(body.Data))
+			}
+			for _, user := range body.Data {
+				if _, found := user["attributes"]; found {
+					t.Errorf("user %v is listed with its attributes", user["id"])
+				}
+			}
+		})
+	}
+}
+
+func TestUserCursorRoundTrip(t *testing.T) {
+	createdAt := time.Date(2024, 3, 1, 12, 30, 0, 123456789, time.UTC)
+	maria := models.User{ID: 2, Name: "Maria", Email: "maria@example.com", CreatedAt: createdAt}
+
+	tests := []struct {
+		sort      string
+		wantValue string
+	}{
+		{"name", "Maria"},
+		{"-name", "Maria"},
+		{"email", "maria@example.com"},
+		{"created_at", "2024-03-01T12:30:00.123456789Z"},
+		{"-created_at", "2024-03-01T12:30:00.123456789Z"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.sort, func(t *testing.T) {
+			encoded, err := encodeUserCursor(userCursor(tt.sort, maria))
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := decodeUserCursor(encoded)
+			if err != nil {
+				t.Fatalf("decodeUserCursor(%q): %v", encoded, err)
+			}
+			want := models.UserCursor{Sort: tt.sort, Value: tt.wantValue, ID: 2}
+			if *decoded != want {
+				t.Errorf("got cursor %+v, want %+v", *decoded, want)
+			}
+		})
+	}
+}
+
+func TestParseUserListQueryCursor(t *testing.T) {
+	cursorFor := func(sort, value string) string {
+		encoded, err := encodeUserCursor(&models.UserCursor{Sort: sort, Value: value, ID: 7})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return encoded
+	}
+
+	tests := []struct {
+		name    string
+		query   string
+		wantErr bool
+	}{
+		{"default sort", "cursor=" + cursorFor("name", "Maria"), false},
+		{"descending sort", "sort=-email&cursor=" + cursorFor("-email", "maria@example.com"), false},
+		{"created_at sort", "sort=created_at&cursor=" + cursorFor("created_at", "2024-03-01T12:30:00Z"), false},
+		{"made for another sort", "sort=email&cursor=" + cursorFor("name", "Maria"), true},
+		{"made for the other direction", "sort=-name&cursor=" + cursorFor("name", "Maria"), true},
+		{"created_at value not a time", "sort=created_at&cursor=" + cursorFor("created_at", "Maria"), true},
+		{"not base64", "cursor=%21%21%21", true},
+		{"not JSON", "cursor=bm90IGpzb24", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodGet, "/users?"+tt.query, nil)
+
+			query, err := parseUserListQuery(c)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected an error, got cursor %+v", query.After)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseUserListQuery: %v", err)
+			}
+			if query.After == nil || query.After.ID != 7 {
+				t.Errorf("got cursor %+v, want the one for user 7", query.After)
+			}
+		})
+	}
+}
+
+func TestUpdateUserRoleSignsUserOut(t *testing.T) {
+	setupTestAuth()
+	newUsers := func() *fakeUserRepo {
+		return newFakeUserRepo(
+			models.User{ID: 1, Name: "Admin", Email: "admin@example.com", Role: models.RoleAdmin},
+			models.User{ID: 2, Name: "Maria", Email: "maria@example.com", Role: models.RoleAdmin},
+		)
+	}
+
+	tests := []struct {
+		name       string
+		users      repository.UserRepository
+		wantStatus int
+	}{
+		{"revoked", newUsers(), http.StatusOK},
+		{"revocation fails", unrevocableUserRepo{newUsers()}, http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sessions := &fakeSessionRepo{}
+			refreshTokens := &fakeRefreshTokenRepo{}
+			revocations := auth.NewRevocationStore(&fakeRevocationRepo{}, tt.users, sessions, 0)
+			tokens := auth.NewTokenIssuer(tt.users, refreshTokens, sessions, revocations)
+			auth.SetRevocationStore(revocations)
+			t.Cleanup(func() { auth.SetRevocationStore(nil) })
+
+			maria, _ := tt.users.GetUserByID(context.Background(), 2)
+			pair, err := tokens.Issue(context.Background(), maria, auth.ClientInfo{})
+			if err != nil {
+				t.Fatalf("Issue: %v", err)
+			}
+
+			router := gin.New()
+			router.Use(func(c *gin.Context) { c.Set("userID", 1) })
+			router.PUT("/users/:id/role", NewUserHandler(tt.users, nil, revocations, tokens, nil, nil).UpdateUserRole)
+
+			body, _ := json.Marshal(models.UserRoleInput{Role: models.RoleUser})
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/users/2/role", bytes.NewReader(body)))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+
+			if _, err := auth.ValidateToken(pair.AccessToken); err == nil {
+				t.Error("the admin access token still works after the demotion")
+			}
+			if refreshTokens.tokens[0].RevokedAt == nil {
+				t.Error("the refresh token issued as admin was not revoked")
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Eduardo-Barreto/web-ponderada/backend/auth"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/config"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/mailer"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/models"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/repository"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/utils"
+	"github.com/gin-gonic/gin"
+)
+
+type VerificationHandler struct {
+	UserRepo  repository.UserRepository
+	Mailer    mailer.Mailer
+	Throttler *auth.LoginThrottler
+}
+
+func NewVerificationHandler(userRepo repository.UserRepository, m mailer.Mailer, throttler *auth.LoginThrottler) *VerificationHandler {
+	return &VerificationHandler{UserRepo: userRepo, Mailer: m, Throttler: throttler}
+}
+
+// VerifyEmail marks the user's email as verified using the signed link token
+func (h *VerificationHandler) VerifyEmail(c *gin.Context) {
+	tokenStr := c.Query("token")
+	if tokenStr == "" {
+		utils.SendError(c, http.StatusBadRequest, "Verification token is required")
+		return
+	}
+
+	claims, err := auth.ValidateEmailVerificationToken(tokenStr)
+	if err != nil {
+		utils.SendError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx := context.Background()
+	err = h.UserRepo.MarkEmailVerified(ctx, claims.UserID, claims.Email)
+	if err != nil {
+		if err.Error() == "user not found" {
+			// The account is gone or its email changed since the link was sent
+			utils.SendError(c, http.StatusBadRequest, "invalid verification link")
+		} else {
+			log.Printf("Error verifying email for user %d: %v", claims.UserID, err)
+			utils.SendError(c, http.StatusInternalServerError, "Failed to verify email")
+		}
+		return
+	}
+
+	// The configured bootstrap email becomes the first admin once its owner
+	// has proven it, and only while there is no admin yet
+	if bootstrap := config.AppConfig.BootstrapAdminEmail; bootstrap != "" && strings.EqualFold(claims.Email, bootstrap) {
+		promoted, err := h.UserRepo.PromoteBootstrapAdmin(ctx, claims.UserID)
+		if err != nil {
+			// The link stays valid, so opening it again retries the promotion
+			log.Printf("Error promoting bootstrap admin %d: %v", claims.UserID, err)
+			utils.SendError(c, http.StatusInternalServerError, "Failed to verify email")
+			return
+		}
+		if promoted {
+			log.Printf("Promoted user %d to admin (BOOTSTRAP_ADMIN_EMAIL)", claims.UserID)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Email verified successfully"})
+}
+
+// ResendVerification sends a new verification link to an unverified account.
+// The response does not reveal whether the address is registered.
+func (h *VerificationHandler) ResendVerification(c *gin.Context) {
+	var input models.ResendVerificationInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.SendError(c, http.StatusBadRequest, "Invalid input: "+err.Error())
+		return
+	}
+
+	wait, err := h.Throttler.AllowVerificationEmail(context.Background(), input.Email)
+	if err != nil {
+		log.Printf("Error checking verification email rate limit: %v", err)
+		utils.SendError(c, http.StatusInternalServerError, "Failed to process request")
+		return
+	}
+	if wait > 0 {
+		utils.SendRateLimited(c, wait, "Too many verification links requested for this email, try again later")
+		return
+	}
+
+	user, err := h.UserRepo.GetUserByEmail(context.Background(), input.Email)
+	if err != nil {
+		log.Printf("Error fetching user by email for verification: %v", err)
+		utils.SendError(c, http.StatusInternalServerError, "Failed to process request")
+		return
+	}
+
+	if user != nil && user.EmailVerifiedAt == nil {
+		if err := sendVerificationEmail(h.Mailer, user); err != nil {
+			log.Printf("Error preparing verification email for user %d: %v", user.ID, err)
+			utils.SendError(c, http.StatusInternalServerError, "Failed to process request")
+			return
+		}
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "If that email is registered and not yet verified, a verification link has been sent"})
+}
+
+// sendVerificationEmail emails a signed verification link for user.Email
+func sendVerificationEmail(m mailer.Mailer, user *models.User) error {
+	token, err := auth.GenerateEmailVerificationToken(user)
+	if err != nil {
+		return err
+	}
+
+	link := config.AppConfig.FrontendURL + "/verify-email.html?token=" + url.QueryEscape(token)
+	mailer.SendAsync(m, mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nPlease confirm this email address by opening the link below within %s:\n\n%s\n",
+			user.Name, config.AppConfig.EmailVerificationTTL, link),
+	})
+	return nil
+}
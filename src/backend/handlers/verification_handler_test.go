@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/Eduardo-Barreto/web-ponderada/backend/auth"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/config"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/models"
+	"github.com/gin-gonic/gin"
+)
+
+func TestVerifyEmailBootstrapsFirstAdmin(t *testing.T) {
+	deletedAt := time.Now()
+
+	tests := []struct {
+		name      string
+		bootstrap string
+		email     string
+		others    []models.User
+		wantRole  string
+	}{
+		{"bootstrap email", "Owner@Example.com", "owner@example.com", nil, models.RoleAdmin},
+		{"another email", "owner@example.com", "maria@example.com", nil, models.RoleUser},
+		{"no bootstrap email configured", "", "owner@example.com", nil, models.RoleUser},
+		{"an admin already exists", "owner@example.com", "owner@example.com",
+			[]models.User{{ID: 1, Email: "admin@example.com", Role: models.RoleAdmin}}, models.RoleUser},
+		{"the admin was deleted", "owner@example.com", "owner@example.com",
+			[]models.User{{ID: 1, Email: "owner@example.com", Role: models.RoleAdmin, DeletedAt: &deletedAt}}, models.RoleUser},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestAuth()
+			config.AppConfig.EmailVerificationTTL = time.Hour
+			config.AppConfig.BootstrapAdminEmail = tt.bootstrap
+
+			user := models.User{ID: 2, Name: "Owner", Email: tt.email, Role: models.RoleUser}
+			users := newFakeUserRepo(append(tt.others, user)...)
+			handler := NewVerificationHandler(users, nil, nil)
+			router := gin.New()
+			router.GET("/auth/verify", handler.VerifyEmail)
+
+			token, err := auth.GenerateEmailVerificationToken(&user)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/auth/verify?token="+url.QueryEscape(token), nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("got status %d: %s", rec.Code, rec.Body)
+			}
+
+			if got := users.users[2]; got.EmailVerifiedAt == nil || got.Role != tt.wantRole {
+				t.Errorf("got role %q (verified %v), want %q", got.Role, got.EmailVerifiedAt != nil, tt.wantRole)
+			}
+		})
+	}
+}
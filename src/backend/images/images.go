@@ -0,0 +1,231 @@
+// Package images turns uploaded profile pictures into square variants of
+// standard sizes, so clients never download a full-size photo for an avatar.
+package images
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif" // Registers the GIF decoder
+	"image/jpeg"
+	"image/png"
+	"io"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Eduardo-Barreto/web-ponderada/backend/models"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp" // Registers the WebP decoder
+)
+
+// Larger images are refused before decoding, since decoding allocates ~4 bytes per pixel
+const maxSourcePixels = 40_000_000
+
+const jpegQuality = 85
+
+var (
+	ErrUnsupportedImage = errors.New("file is not a supported image (JPEG, PNG, GIF or WebP)")
+	ErrImageTooLarge    = fmt.Errorf("image is larger than %d megapixels", maxSourcePixels/1_000_000)
+)
+
+// Variant is one encoded size of a picture
+type Variant struct {
+	Size int
+	Data []byte
+}
+
+// ProfilePicVariants crops the picture to a centred square and scales it to
+// each of the sizes (in pixels per side). Camera rotation recorded in EXIF is
+// applied and all metadata is dropped. Variants are JPEG unless the picture
+// has transparency, in which case they are PNG; the extension is returned.
+func ProfilePicVariants(r io.ReadSeeker, sizes []int) (string, []Variant, error) {
+	cfg, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return "", nil, ErrUnsupportedImage
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return "", nil, ErrUnsupportedImage
+	}
+	if cfg.Width*cfg.Height > maxSourcePixels {
+		return "", nil, ErrImageTooLarge
+	}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return "", nil, fmt.Errorf("failed to rewind image: %w", err)
+	}
+	src, format, err := image.Decode(r)
+	if err != nil {
+		return "", nil, ErrUnsupportedImage
+	}
+	orientation := 1
+	if format == "jpeg" {
+		if _, err := r.Seek(0, io.SeekStart); err != nil {
+			return "", nil, fmt.Errorf("failed to rewind image: %w", err)
+		}
+		orientation = jpegOrientation(r)
+	}
+
+	// Centred square; rotating afterwards gives the same result as before
+	bounds := src.Bounds()
+	side := min(bounds.Dx(), bounds.Dy())
+	x0 := bounds.Min.X + (bounds.Dx()-side)/2
+	y0 := bounds.Min.Y + (bounds.Dy()-side)/2
+	crop := image.Rect(x0, y0, x0+side, y0+side)
+
+	ext := ".jpg"
+	if o, ok := src.(interface{ Opaque() bool }); ok && !o.Opaque() {
+		ext = ".png"
+	}
+
+	variants := make([]Variant, 0, len(sizes))
+	for _, size := range sizes {
+		dst := image.NewRGBA(image.Rect(0, 0, size, size))
+		draw.CatmullRom.Scale(dst, dst.Bounds(), src, crop, draw.Src, nil)
+		oriented := orient(dst, orientation)
+
+		var buf bytes.Buffer
+		if ext == ".png" {
+			err = png.Encode(&buf, oriented)
+		} else {
+			err = jpeg.Encode(&buf, oriented, &jpeg.Options{Quality: jpegQuality})
+		}
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to encode %dpx variant: %w", size, err)
+		}
+		variants = append(variants, Variant{Size: size, Data: buf.Bytes()})
+	}
+	return ext, variants, nil
+}
+
+// variantSuffix matches the "_<size>" that VariantPath adds before the extension
+var variantSuffix = regexp.MustCompile(`_[0-9]+$`)
+
+// VariantPath returns where the given size of a picture is stored. filename may
+// name the picture itself or any of its variants: "users/abc.jpg" and
+// "users/abc_512.jpg" both give "users/abc_64.jpg" for size 64.
+func VariantPath(filename string, size int) string {
+	ext := path.Ext(filename)
+	stem := variantSuffix.ReplaceAllString(strings.TrimSuffix(filename, ext), "")
+	return stem + "_" + strconv.Itoa(size) + ext
+}
+
+// NearestSize picks the smallest of the sizes (sorted ascending) that is at
+// least want, or the largest one if none is
+func NearestSize(sizes []int, want int) int {
+	for _, size := range sizes {
+		if size >= want {
+			return size
+		}
+	}
+	return sizes[len(sizes)-1]
+}
+
+// ProfilePicFiles lists every stored file of a user's profile picture
+func ProfilePicFiles(user *models.User) []string {
+	var files []string
+	if user.ProfilePic != "" {
+		files = append(files, user.ProfilePic)
+	}
+	for _, filename := range user.ProfilePicVariants {
+		if filename != user.ProfilePic {
+			files = append(files, filename)
+		}
+	}
+	return files
+}
+
+// jpegOrientation reads the EXIF orientation tag (1-8) of a JPEG, returning
+// 1 (as stored) when there is none
+func jpegOrientation(r io.Reader) int {
+	var marker [4]byte
+	if _, err := io.ReadFull(r, marker[:2]); err != nil || marker[0] != 0xFF || marker[1] != 0xD8 {
+		return 1
+	}
+	for {
+		if _, err := io.ReadFull(r, marker[:]); err != nil || marker[0] != 0xFF {
+			return 1
+		}
+		length := int(binary.BigEndian.Uint16(marker[2:])) - 2
+		// EXIF lives in APP1, before the image data starts at SOS
+		if marker[1] == 0xDA || length < 0 {
+			return 1
+		}
+		segment := make([]byte, length)
+		if _, err := io.ReadFull(r, segment); err != nil {
+			return 1
+		}
+		if marker[1] == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifOrientation(segment[6:])
+		}
+	}
+}
+
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < entries; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			if value := int(order.Uint16(tiff[entry+8:])); value >= 1 && value <= 8 {
+				return value
+			}
+			return 1
+		}
+	}
+	return 1
+}
+
+// orient applies an EXIF orientation to a square image
+func orient(img *image.RGBA, orientation int) *image.RGBA {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+	n := img.Bounds().Dx() - 1
+	out := image.NewRGBA(img.Bounds())
+	for y := 0; y <= n; y++ {
+		for x := 0; x <= n; x++ {
+			// Where the stored pixel (x, y) ends up once displayed
+			var dx, dy int
+			switch orientation {
+			case 2: // Mirrored
+				dx, dy = n-x, y
+			case 3: // Rotated 180°
+				dx, dy = n-x, n-y
+			case 4: // Flipped vertically
+				dx, dy = x, n-y
+			case 5: // Transposed
+				dx, dy = y, x
+			case 6: // Rotated 90° clockwise to display
+				dx, dy = n-y, x
+			case 7: // Transversed
+				dx, dy = n-y, n-x
+			case 8: // Rotated 90° counter-clockwise to display
+				dx, dy = y, n-x
+			}
+			out.SetRGBA(dx, dy, img.RGBAAt(x, y))
+		}
+	}
+	return out
+}
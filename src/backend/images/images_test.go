@@ -0,0 +1,288 @@
+package images
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func TestVariantPath(t *testing.T) {
+	tests := []struct {
+		filename string
+		size     int
+		want     string
+	}{
+		{"users/abc.jpg", 64, "users/abc_64.jpg"},
+		{"users/abc_512.jpg", 64, "users/abc_64.jpg"},
+		{"users/abc_64.png", 512, "users/abc_512.png"},
+		{"users/abc_def.jpg", 64, "users/abc_def_64.jpg"}, // Only a numeric suffix is a size
+		{"users/2024_01/abc.jpg", 64, "users/2024_01/abc_64.jpg"},
+		{"abc", 128, "abc_128"},
+	}
+	for _, tt := range tests {
+		if got := VariantPath(tt.filename, tt.size); got != tt.want {
+			t.Errorf("VariantPath(%q, %d) = %q, want %q", tt.filename, tt.size, got, tt.want)
+		}
+	}
+}
+
+func TestNearestSize(t *testing.T) {
+	sizes := []int{64, 128, 256, 512}
+	tests := []struct {
+		want int
+		got  int
+	}{
+		{1, 64},
+		{64, 64},
+		{65, 128},
+		{200, 256},
+		{512, 512},
+		{4096, 512},
+	}
+	for _, tt := range tests {
+		if got := NearestSize(sizes, tt.want); got != tt.got {
+			t.Errorf("NearestSize(%v, %d) = %d, want %d", sizes, tt.want, got, tt.got)
+		}
+	}
+}
+
+var (
+	red   = color.RGBA{255, 0, 0, 255}
+	green = color.RGBA{0, 255, 0, 255}
+	blue  = color.RGBA{0, 0, 255, 255}
+)
+
+// stripes returns a w×h image split into equal vertical stripes of the colours
+func stripes(w, h int, colours ...color.RGBA) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetRGBA(x, y, colours[x*len(colours)/w])
+		}
+	}
+	return img
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// near reports whether c is within a JPEG's error of want
+func near(c color.Color, want color.RGBA) bool {
+	r, g, b, _ := c.RGBA()
+	diff := func(got uint32, want uint8) bool {
+		d := int(got>>8) - int(want)
+		return d > -40 && d < 40
+	}
+	return diff(r, want.R) && diff(g, want.G) && diff(b, want.B)
+}
+
+func TestProfilePicVariantsCropsCentredSquare(t *testing.T) {
+	tests := []struct {
+		name string
+		img  *image.RGBA
+	}{
+		{"landscape", stripes(300, 100, red, green, blue)},
+		{"portrait", func() *image.RGBA {
+			// Red, green and blue bands from top to bottom
+			img := image.NewRGBA(image.Rect(0, 0, 100, 300))
+			for y := 0; y < 300; y++ {
+				for x := 0; x < 100; x++ {
+					img.SetRGBA(x, y, []color.RGBA{red, green, blue}[y/100])
+				}
+			}
+			return img
+		}()},
+	}
+	sizes := []int{32, 64}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ext, variants, err := ProfilePicVariants(bytes.NewReader(encodePNG(t, tt.img)), sizes)
+			if err != nil {
+				t.Fatalf("ProfilePicVariants: %v", err)
+			}
+			if ext != ".jpg" {
+				t.Errorf("got extension %q for an opaque picture, want .jpg", ext)
+			}
+			if len(variants) != len(sizes) {
+				t.Fatalf("got %d variants, want %d", len(variants), len(sizes))
+			}
+			for i, variant := range variants {
+				img, err := jpeg.Decode(bytes.NewReader(variant.Data))
+				if err != nil {
+					t.Fatalf("%dpx variant is not a JPEG: %v", variant.Size, err)
+				}
+				size := sizes[i]
+				if variant.Size != size || img.Bounds().Dx() != size || img.Bounds().Dy() != size {
+					t.Errorf("variant %d is %dpx with bounds %v, want %d×%d", i, variant.Size, img.Bounds(), size, size)
+				}
+				// Only the middle band survives the crop
+				for _, p := range []image.Point{{2, 2}, {size / 2, size / 2}, {size - 3, size - 3}} {
+					if c := img.At(p.X, p.Y); !near(c, green) {
+						t.Errorf("%dpx variant: pixel %v is %v, want green", size, p, c)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestProfilePicVariantsKeepsTransparency(t *testing.T) {
+	img := stripes(40, 40, red)
+	img.SetRGBA(0, 0, color.RGBA{})
+	ext, variants, err := ProfilePicVariants(bytes.NewReader(encodePNG(t, img)), []int{16})
+	if err != nil {
+		t.Fatalf("ProfilePicVariants: %v", err)
+	}
+	if ext != ".png" {
+		t.Errorf("got extension %q for a transparent picture, want .png", ext)
+	}
+	if _, err := png.Decode(bytes.NewReader(variants[0].Data)); err != nil {
+		t.Errorf("variant is not a PNG: %v", err)
+	}
+}
+
+// pngHeader returns the start of a PNG claiming the given dimensions
+func pngHeader(width, height uint32) []byte {
+	ihdr := make([]byte, 17)
+	copy(ihdr, "IHDR")
+	binary.BigEndian.PutUint32(ihdr[4:], width)
+	binary.BigEndian.PutUint32(ihdr[8:], height)
+	ihdr[12], ihdr[13] = 8, 6 // 8-bit RGBA
+
+	var buf bytes.Buffer
+	buf.WriteString("\x89PNG\r\n\x1a\n")
+	binary.Write(&buf, binary.BigEndian, uint32(13))
+	buf.Write(ihdr)
+	binary.Write(&buf, binary.BigEndian, crc32.ChecksumIEEE(ihdr))
+	return buf.Bytes()
+}
+
+func TestProfilePicVariantsRejects(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr error
+	}{
+		{"not an image", []byte("plain text, not a picture"), ErrUnsupportedImage},
+		{"empty", nil, ErrUnsupportedImage},
+		{"too many pixels", pngHeader(10000, 5000), ErrImageTooLarge},
+		{"truncated", pngHeader(100, 100), ErrUnsupportedImage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := ProfilePicVariants(bytes.NewReader(tt.data), []int{64}); !errors.Is(err, tt.wantErr) {
+				t.Errorf("got error %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// exifJPEG encodes img as a JPEG carrying an EXIF orientation tag
+func exifJPEG(t *testing.T, img image.Image, orientation uint16) []byte {
+	t.Helper()
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Big-endian TIFF header and an IFD with a single orientation entry
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08\x00\x01\x01\x12\x00\x03\x00\x00\x00\x01\x00\x00\x00\x00\x00\x00\x00\x00")
+	binary.BigEndian.PutUint16(tiff[18:], orientation)
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+
+	var out bytes.Buffer
+	out.Write(encoded.Bytes()[:2]) // SOI
+	out.Write([]byte{0xFF, 0xE1})
+	binary.Write(&out, binary.BigEndian, uint16(len(segment)+2))
+	out.Write(segment)
+	out.Write(encoded.Bytes()[2:])
+	return out.Bytes()
+}
+
+func TestProfilePicVariantsAppliesEXIFOrientation(t *testing.T) {
+	// Red left half, blue right half as stored
+	src := stripes(64, 64, red, blue)
+	tests := []struct {
+		orientation         uint16
+		wantLeft, wantRight color.RGBA
+		wantTop, wantBottom color.RGBA
+	}{
+		{1, red, blue, color.RGBA{}, color.RGBA{}},
+		{2, blue, red, color.RGBA{}, color.RGBA{}},
+		{3, blue, red, color.RGBA{}, color.RGBA{}},
+		{6, color.RGBA{}, color.RGBA{}, red, blue},
+		{8, color.RGBA{}, color.RGBA{}, blue, red},
+	}
+	for _, tt := range tests {
+		_, variants, err := ProfilePicVariants(bytes.NewReader(exifJPEG(t, src, tt.orientation)), []int{32})
+		if err != nil {
+			t.Fatalf("orientation %d: %v", tt.orientation, err)
+		}
+		img, err := jpeg.Decode(bytes.NewReader(variants[0].Data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		checks := []struct {
+			at   image.Point
+			want color.RGBA
+		}{
+			{image.Pt(4, 16), tt.wantLeft},
+			{image.Pt(27, 16), tt.wantRight},
+			{image.Pt(16, 4), tt.wantTop},
+			{image.Pt(16, 27), tt.wantBottom},
+		}
+		for _, check := range checks {
+			if check.want.A == 0 {
+				continue // Not constrained for this orientation
+			}
+			if c := img.At(check.at.X, check.at.Y); !near(c, check.want) {
+				t.Errorf("orientation %d: pixel %v is %v, want %v", tt.orientation, check.at, c, check.want)
+			}
+		}
+	}
+}
+
+func TestExifOrientation(t *testing.T) {
+	entry := func(order binary.ByteOrder, magic string, tag, value uint16) []byte {
+		tiff := make([]byte, 26)
+		copy(tiff, magic)
+		order.PutUint16(tiff[2:], 42)
+		order.PutUint32(tiff[4:], 8)
+		order.PutUint16(tiff[8:], 1)
+		order.PutUint16(tiff[10:], tag)
+		order.PutUint16(tiff[12:], 3) // SHORT
+		order.PutUint32(tiff[14:], 1)
+		order.PutUint16(tiff[18:], value)
+		return tiff
+	}
+	tests := []struct {
+		name string
+		tiff []byte
+		want int
+	}{
+		{"big-endian", entry(binary.BigEndian, "MM", 0x0112, 6), 6},
+		{"little-endian", entry(binary.LittleEndian, "II", 0x0112, 8), 8},
+		{"other tag only", entry(binary.BigEndian, "MM", 0x010F, 6), 1},
+		{"out of range", entry(binary.BigEndian, "MM", 0x0112, 9), 1},
+		{"unknown byte order", entry(binary.BigEndian, "XX", 0x0112, 6), 1},
+		{"truncated entry", entry(binary.BigEndian, "MM", 0x0112, 6)[:16], 1},
+		{"too short", []byte("MM\x00"), 1},
+	}
+	for _, tt := range tests {
+		if got := exifOrientation(tt.tiff); got != tt.want {
+			t.Errorf("%s: exifOrientation = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
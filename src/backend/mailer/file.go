@@ -0,0 +1,37 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type fileMailer struct {
+	outboxDir string
+	from      string
+}
+
+// NewFileMailer creates a mailer that writes each message as an .eml file
+// into outboxDir instead of sending it. Meant for local development and tests.
+func NewFileMailer(outboxDir, from string) Mailer {
+	if err := os.MkdirAll(outboxDir, 0755); err != nil {
+		log.Fatalf("Failed to create mail outbox directory: %v", err)
+	}
+	return &fileMailer{outboxDir: outboxDir, from: from}
+}
+
+func (m *fileMailer) Send(ctx context.Context, msg Message) error {
+	// Timestamp prefix keeps the outbox sorted by send order
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405.000000000"), uuid.New().String())
+	path := filepath.Join(m.outboxDir, name)
+	if err := os.WriteFile(path, buildMessage(m.from, msg), 0644); err != nil {
+		return fmt.Errorf("failed to write email to outbox: %w", err)
+	}
+	log.Printf("Email %q to %s written to %s", msg.Subject, msg.To, path)
+	return nil
+}
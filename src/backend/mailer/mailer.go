@@ -0,0 +1,51 @@
+package mailer
+
+import (
+	"context"
+	"log"
+
+	"github.com/Eduardo-Barreto/web-ponderada/backend/config"
+)
+
+// Message is a plain-text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer defines how the application sends email (SMTP, local outbox, etc.)
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// New returns the mailer selected by MAILER_DRIVER ("smtp" or "file")
+func New() Mailer {
+	switch config.AppConfig.MailerDriver {
+	case "smtp":
+		log.Printf("Sending email through SMTP server %s:%d", config.AppConfig.SMTPHost, config.AppConfig.SMTPPort)
+		return NewSMTPMailer(
+			config.AppConfig.SMTPHost,
+			config.AppConfig.SMTPPort,
+			config.AppConfig.SMTPUsername,
+			config.AppConfig.SMTPPassword,
+			config.AppConfig.MailFrom,
+		)
+	case "file":
+		log.Printf("Writing outgoing email to %s", config.AppConfig.MailOutboxDir)
+		return NewFileMailer(config.AppConfig.MailOutboxDir, config.AppConfig.MailFrom)
+	default:
+		log.Fatalf("Unknown MAILER_DRIVER %q (expected \"smtp\" or \"file\")", config.AppConfig.MailerDriver)
+		return nil
+	}
+}
+
+// SendAsync sends in the background and only logs failures, so request
+// latency does not reveal whether an email was actually sent.
+func SendAsync(m Mailer, msg Message) {
+	go func() {
+		if err := m.Send(context.Background(), msg); err != nil {
+			log.Printf("Error sending email %q to %s: %v", msg.Subject, msg.To, err)
+		}
+	}()
+}
@@ -0,0 +1,54 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type smtpMailer struct {
+	addr string
+	host string
+	auth smtp.Auth
+	from string
+}
+
+// NewSMTPMailer creates a mailer that delivers through an SMTP server.
+// Authentication is skipped when username is empty (e.g. a local relay).
+func NewSMTPMailer(host string, port int, username, password, from string) Mailer {
+	var auth smtp.Auth
+	if username != "" {
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+	return &smtpMailer{
+		addr: net.JoinHostPort(host, strconv.Itoa(port)),
+		host: host,
+		auth: auth,
+		from: from,
+	}
+}
+
+func (m *smtpMailer) Send(ctx context.Context, msg Message) error {
+	if err := smtp.SendMail(m.addr, m.auth, m.from, []string{msg.To}, buildMessage(m.from, msg)); err != nil {
+		return fmt.Errorf("failed to send email via smtp: %w", err)
+	}
+	return nil
+}
+
+// buildMessage renders an RFC 5322 message with a plain-text body
+func buildMessage(from string, msg Message) []byte {
+	var b strings.Builder
+	b.WriteString("From: " + from + "\r\n")
+	b.WriteString("To: " + msg.To + "\r\n")
+	b.WriteString("Subject: " + msg.Subject + "\r\n")
+	b.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
@@ -12,6 +13,10 @@ import (
 	"github.com/Eduardo-Barreto/web-ponderada/backend/auth"
 	"github.com/Eduardo-Barreto/web-ponderada/backend/config"
 	"github.com/Eduardo-Barreto/web-ponderada/backend/database"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/exports"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/images"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/mailer"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/models"
 	"github.com/Eduardo-Barreto/web-ponderada/backend/repository"
 	"github.com/Eduardo-Barreto/web-ponderada/backend/routes"
 	"github.com/Eduardo-Barreto/web-ponderada/backend/storage"
@@ -27,15 +32,71 @@ func main() {
 	// 3. Connect to Database
 	database.ConnectDB()
 	defer database.CloseDB() // Ensure pool is closed on exit
+	if err := database.Migrate(context.Background()); err != nil {
+		log.Fatalf("Failed to migrate database: %v", err)
+	}
 
 	// 4. Initialize Repositories
 	userRepo := repository.NewPostgresUserRepository(database.Pool)
 	productRepo := repository.NewPostgresProductRepository(database.Pool)
+	refreshTokenRepo := repository.NewPostgresRefreshTokenRepository(database.Pool)
+	revocationRepo := repository.NewPostgresTokenRevocationRepository(database.Pool)
+	userTokenRepo := repository.NewPostgresUserTokenRepository(database.Pool)
+	loginAttemptRepo := repository.NewPostgresLoginAttemptRepository(database.Pool)
+	sessionRepo := repository.NewPostgresSessionRepository(database.Pool)
+	recoveryCodeRepo := repository.NewPostgresRecoveryCodeRepository(database.Pool)
+	apiKeyRepo := repository.NewPostgresAPIKeyRepository(database.Pool)
+	identityRepo := repository.NewPostgresIdentityRepository(database.Pool)
+	auditRepo := repository.NewPostgresImpersonationAuditRepository(database.Pool)
+	webAuthnRepo := repository.NewPostgresWebAuthnRepository(database.Pool)
+	dataExportRepo := repository.NewPostgresDataExportRepository(database.Pool)
+	attributeSchemaRepo := repository.NewPostgresAttributeSchemaRepository(database.Pool)
 	// Use local storage implementation
 	fileRepo := storage.NewLocalStorage() // Create local storage instance
 
+	// Make ValidateToken honour logouts and "log out everywhere"
+	revocationStore := auth.NewRevocationStore(revocationRepo, userRepo, sessionRepo, config.AppConfig.RevocationCacheTTL)
+	auth.SetRevocationStore(revocationStore)
+	go pruneRevocations(revocationStore)
+
+	// Let AuthMiddleware accept service account API keys
+	auth.SetAPIKeyRepository(apiKeyRepo)
+
+	// Every request made with an impersonation token is audited
+	auth.SetImpersonationAuditRepository(auditRepo)
+
+	// Outgoing email (SMTP or local outbox, see MAILER_DRIVER)
+	mail := mailer.New()
+
+	// Personal data exports, built in the background and removed once expired
+	exportService := exports.NewService(userRepo, dataExportRepo, sessionRepo, auditRepo, identityRepo, webAuthnRepo, fileRepo, mail)
+	go pruneDataExports(exportService)
+
+	// Permanently remove soft-deleted users once their retention period is over
+	go purgeDeletedUsers(userRepo, fileRepo, exportService)
+
+	// Anonymise accounts whose owners closed them and did not come back
+	go anonymizeClosedAccounts(userRepo, fileRepo, exportService)
+
 	// 5. Setup Router
-	router := routes.SetupRouter(userRepo, productRepo, fileRepo) // Pass fileRepo
+	router := routes.SetupRouter(routes.Dependencies{
+		UserRepo:            userRepo,
+		ProductRepo:         productRepo,
+		FileRepo:            fileRepo,
+		RefreshTokenRepo:    refreshTokenRepo,
+		UserTokenRepo:       userTokenRepo,
+		LoginAttemptRepo:    loginAttemptRepo,
+		RecoveryCodeRepo:    recoveryCodeRepo,
+		SessionRepo:         sessionRepo,
+		APIKeyRepo:          apiKeyRepo,
+		IdentityRepo:        identityRepo,
+		WebAuthnRepo:        webAuthnRepo,
+		AuditRepo:           auditRepo,
+		AttributeSchemaRepo: attributeSchemaRepo,
+		RevocationStore:     revocationStore,
+		DataExports:         exportService,
+		Mailer:              mail,
+	})
 
 	// 6. Start Server with Graceful Shutdown
 	server := &http.Server{
@@ -74,3 +135,110 @@ func main() {
 
 	log.Println("Server exiting")
 }
+
+// pruneRevocations periodically drops denylist entries for tokens that have expired anyway
+func pruneRevocations(store *auth.RevocationStore) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for range ticker.C {
+		if removed, err := store.Prune(context.Background()); err != nil {
+			log.Printf("Error pruning revoked tokens: %v", err)
+		} else if removed > 0 {
+			log.Printf("Pruned %d expired revoked tokens", removed)
+		}
+	}
+}
+
+// purgeDeletedUsers periodically removes users deleted more than
+// DELETED_USER_RETENTION ago, together with their files
+func purgeDeletedUsers(userRepo repository.UserRepository, fileRepo repository.StorageRepository, exportService *exports.Service) {
+	ticker := time.NewTicker(config.AppConfig.UserPurgeInterval)
+	defer ticker.Stop()
+	for ; ; <-ticker.C {
+		ctx := context.Background()
+		users, err := userRepo.GetDeletedUsers(ctx, time.Now().Add(-config.AppConfig.DeletedUserRetention))
+		if err != nil {
+			log.Printf("Error listing users to purge: %v", err)
+			continue
+		}
+
+		purged := 0
+		for _, user := range users {
+			// Files go first: if that fails the user is kept and retried on the next run
+			if err := deleteProfilePic(ctx, fileRepo, &user); err != nil {
+				log.Printf("Error deleting profile picture of purged user %d: %v", user.ID, err)
+				continue
+			}
+			if err := exportService.DeleteUserExports(ctx, user.ID); err != nil {
+				log.Printf("Error deleting data exports of purged user %d: %v", user.ID, err)
+				continue
+			}
+			if err := userRepo.PurgeUser(ctx, user.ID); err != nil {
+				log.Printf("Error purging user %d: %v", user.ID, err)
+				continue
+			}
+			purged++
+		}
+		if purged > 0 {
+			log.Printf("Purged %d deleted users", purged)
+		}
+	}
+}
+
+// deleteProfilePic removes every stored size of a user's profile picture
+func deleteProfilePic(ctx context.Context, fileRepo repository.StorageRepository, user *models.User) error {
+	for _, filename := range images.ProfilePicFiles(user) {
+		if err := fileRepo.DeleteFile(ctx, filename); err != nil {
+			return fmt.Errorf("failed to delete %s: %w", filename, err)
+		}
+	}
+	return nil
+}
+
+// pruneDataExports periodically deletes expired data exports and their zips
+func pruneDataExports(service *exports.Service) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for range ticker.C {
+		if removed, err := service.Prune(context.Background()); err != nil {
+			log.Printf("Error pruning data exports: %v", err)
+		} else if removed > 0 {
+			log.Printf("Pruned %d expired data exports", removed)
+		}
+	}
+}
+
+// anonymizeClosedAccounts periodically scrubs accounts whose self-service
+// closure grace period is over
+func anonymizeClosedAccounts(userRepo repository.UserRepository, fileRepo repository.StorageRepository, exportService *exports.Service) {
+	ticker := time.NewTicker(config.AppConfig.UserPurgeInterval)
+	defer ticker.Stop()
+	for ; ; <-ticker.C {
+		ctx := context.Background()
+		users, err := userRepo.GetUsersDueForDeletion(ctx, time.Now())
+		if err != nil {
+			log.Printf("Error listing accounts due for deletion: %v", err)
+			continue
+		}
+
+		for _, user := range users {
+			// Files go first, as anonymising forgets the picture's file names and
+			// takes the user off this list: if deleting fails, the next run retries.
+			// Anonymised rows are kept, so their exports do not go with them either.
+			if err := deleteProfilePic(ctx, fileRepo, &user); err != nil {
+				log.Printf("Error deleting profile picture of closed account %d: %v", user.ID, err)
+				continue
+			}
+			if err := exportService.DeleteUserExports(ctx, user.ID); err != nil {
+				log.Printf("Error deleting data exports of closed account %d: %v", user.ID, err)
+				continue
+			}
+			// Fails without changes if the user logged in since the listing
+			if err := userRepo.AnonymizeUser(ctx, user.ID); err != nil {
+				log.Printf("Error anonymizing user %d: %v", user.ID, err)
+				continue
+			}
+			log.Printf("User %d anonymized after account closure", user.ID)
+		}
+	}
+}
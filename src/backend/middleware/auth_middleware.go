@@ -1,22 +1,33 @@
 package middleware
 
 import (
+	"context"
 	"net/http"
 	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/Eduardo-Barreto/web-ponderada/backend/auth"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/config"
 	"github.com/Eduardo-Barreto/web-ponderada/backend/utils"
 )
 
-// AuthMiddleware checks for a valid JWT in the Authorization header
+// AuthMiddleware checks for a valid JWT or service account API key in the
+// Authorization header, or for an access token cookie in cookie sessions
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
-			utils.SendError(c, http.StatusUnauthorized, "Authorization header required")
-			c.Abort()
-			return
+			tokenString, ok := accessTokenCookie(c)
+			if !ok {
+				c.Abort()
+				return
+			}
+			if tokenString == "" {
+				utils.SendError(c, http.StatusUnauthorized, "Authorization header required")
+				c.Abort()
+				return
+			}
+			authHeader = "Bearer " + tokenString
 		}
 
 		// Check if the header format is "Bearer <token>"
@@ -28,6 +39,21 @@ func AuthMiddleware() gin.HandlerFunc {
 		}
 
 		tokenString := parts[1]
+
+		// Service accounts authenticate with an API key and act through its
+		// scopes only; there is no userID, so user-specific handlers refuse them
+		if auth.IsAPIKey(tokenString) {
+			apiKey, err := auth.ValidateAPIKey(context.Background(), tokenString)
+			if err != nil {
+				utils.SendError(c, http.StatusUnauthorized, err.Error())
+				c.Abort()
+				return
+			}
+			c.Set("apiKey", apiKey)
+			c.Next()
+			return
+		}
+
 		claims, err := auth.ValidateToken(tokenString)
 		if err != nil {
 			utils.SendError(c, http.StatusUnauthorized, err.Error()) // Use error message from ValidateToken
@@ -38,7 +64,56 @@ func AuthMiddleware() gin.HandlerFunc {
 		// Add claims (like user ID) to the context for handlers to use
 		c.Set("userID", claims.UserID)
         c.Set("userEmail", claims.Email) // Can be useful
+		c.Set("userRole", claims.Role)
+		c.Set("mfa", claims.MFA)
+		c.Set("tokenClaims", claims)      // Needed by logout to revoke this exact token
+
+		if claims.IsImpersonation() {
+			c.Set("actorID", claims.ActorID)
+			auditImpersonatedRequest(c, claims)
+			return
+		}
 
 		c.Next() // Proceed to the next handler
 	}
 }
+
+// RequireUserToken rejects requests authenticated with an API key, for
+// routes that only make sense for a logged-in user. Must run after AuthMiddleware.
+func RequireUserToken() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, isAPIKey := c.Get("apiKey"); isAPIKey {
+			utils.SendError(c, http.StatusForbidden, "This action is not available to API keys")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// accessTokenCookie returns the access token cookie of a cookie session, or
+// "" if there is none. Browsers attach cookies to cross-site requests too,
+// so unsafe methods must echo the CSRF cookie in a header, which other sites
+// cannot read (double submit). ok is false after responding with an error.
+func accessTokenCookie(c *gin.Context) (token string, ok bool) {
+	if !config.AppConfig.SessionCookies {
+		return "", true
+	}
+	token, err := c.Cookie(auth.AccessTokenCookie)
+	if err != nil || token == "" {
+		return "", true
+	}
+
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+	default:
+		csrfCookie, _ := c.Cookie(auth.CSRFCookie)
+		if !auth.CSRFTokenMatches(csrfCookie, c.GetHeader(auth.CSRFHeader)) {
+			utils.SendError(c, http.StatusForbidden, "Missing or invalid CSRF token")
+			return "", false
+		}
+	}
+
+	c.Set("cookieAuth", true)
+	return token, true
+}
@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"context"
+	"log"
+	"net/http"
+
+	"github.com/Eduardo-Barreto/web-ponderada/backend/auth"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/models"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// auditImpersonatedRequest runs the rest of the chain for a request made
+// with an impersonation token, recording it in the audit log first. If it
+// cannot be recorded, the request is refused.
+func auditImpersonatedRequest(c *gin.Context, claims *auth.Claims) {
+	entry := &models.ImpersonationAuditEntry{
+		ActorID:   claims.ActorID,
+		SubjectID: claims.UserID,
+		TokenID:   claims.ID,
+		Method:    c.Request.Method,
+		Path:      c.Request.URL.RequestURI(),
+		IP:        c.ClientIP(),
+	}
+	if err := auth.RecordImpersonation(context.Background(), entry); err != nil {
+		log.Printf("Error auditing request by admin %d as user %d: %v", claims.ActorID, claims.UserID, err)
+		utils.SendError(c, http.StatusServiceUnavailable, "Impersonated requests cannot be audited right now")
+		c.Abort()
+		return
+	}
+
+	c.Next()
+
+	if err := auth.CompleteImpersonation(context.Background(), entry, c.Writer.Status()); err != nil {
+		log.Printf("Error recording status of audited request %d: %v", entry.ID, err)
+	}
+}
+
+// DenyImpersonation refuses requests made with impersonation tokens, for
+// actions support staff must never take on a user's behalf (passwords, the
+// login email, second factors, deleting the account). Must run after AuthMiddleware.
+func DenyImpersonation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, impersonating := c.Get("actorID"); impersonating {
+			utils.SendError(c, http.StatusForbidden, "This action is not available while impersonating a user")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
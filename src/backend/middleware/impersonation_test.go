@@ -0,0 +1,39 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestDenyImpersonation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name       string
+		actorID    int // 0 for a token of the user themselves
+		wantStatus int
+	}{
+		{"own token", 0, http.StatusOK},
+		{"impersonation token", 1, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.Use(func(c *gin.Context) {
+				c.Set("userID", 2)
+				if tt.actorID != 0 {
+					c.Set("actorID", tt.actorID)
+				}
+			})
+			router.PATCH("/users/me", DenyImpersonation(), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodPatch, "/users/me", nil))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("got status %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/Eduardo-Barreto/web-ponderada/backend/auth"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/config"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/models"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// RequireRole only lets through users with one of the given roles.
+// API keys have no role and are always rejected.
+// Must run after AuthMiddleware.
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !adminMFASatisfied(c) {
+			return
+		}
+
+		role := c.GetString("userRole")
+		for _, allowed := range roles {
+			if role == allowed {
+				c.Next()
+				return
+			}
+		}
+
+		utils.SendError(c, http.StatusForbidden, "You do not have permission to perform this action")
+		c.Abort()
+	}
+}
+
+// RequirePermission only lets through users whose role grants the permission,
+// or API keys that were given it as a scope.
+// Must run after AuthMiddleware.
+func RequirePermission(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !adminMFASatisfied(c) {
+			return
+		}
+
+		if !HasPermission(c, permission) {
+			utils.SendError(c, http.StatusForbidden, "You do not have permission to perform this action")
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// HasPermission reports whether the caller's role or API key grants the
+// permission, for handlers that only restrict part of what a route does
+func HasPermission(c *gin.Context, permission string) bool {
+	if apiKey, isAPIKey := c.Get("apiKey"); isAPIKey {
+		return auth.APIKeyHasScope(apiKey.(*models.APIKey), permission)
+	}
+	return auth.RoleHasPermission(c.GetString("userRole"), permission)
+}
+
+// adminMFASatisfied blocks admins from using their privileges without 2FA
+// when REQUIRE_ADMIN_MFA is set. Sends the response and aborts if not.
+func adminMFASatisfied(c *gin.Context) bool {
+	if config.AppConfig.RequireAdminMFA && c.GetString("userRole") == models.RoleAdmin && !c.GetBool("mfa") {
+		utils.SendError(c, http.StatusForbidden, "Two-factor authentication must be enabled for admin accounts")
+		c.Abort()
+		return false
+	}
+	return true
+}
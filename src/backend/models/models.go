@@ -1,30 +1,95 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 )
 
+// User roles, from least to most privileged
+const (
+	RoleUser   = "user"
+	RoleEditor = "editor"
+	RoleAdmin  = "admin"
+)
+
 type User struct {
-	ID         int       `json:"id"`
-	Name       string    `json:"name" binding:"required"`
-	Email      string    `json:"email" binding:"required,email"`
-	Password   string    `json:"-" db:"password_hash"` // Alterado para corresponder ao campo password_hash do banco
-	ProfilePic string    `json:"profile_pic"` // Stores filename or path/URL
-	CreatedAt  time.Time `json:"created_at"`
-	UpdatedAt  time.Time `json:"updated_at"`
+	ID                  int                    `json:"id"`
+	Name                string                 `json:"name" binding:"required"`
+	Email               string                 `json:"email" binding:"required,email"`
+	Password            string                 `json:"-" db:"password_hash"` // Alterado para corresponder ao campo password_hash do banco
+	ProfilePic          string                 `json:"profile_pic"`          // Stores filename or path/URL
+	ProfilePicVariants  map[string]string      `json:"profile_pic_variants"` // Square resized copies, keyed by size in pixels
+	Role                string                 `json:"role"`
+	EmailVerifiedAt     *time.Time             `json:"email_verified_at"`
+	TokenVersion        int                    `json:"-"` // Bumped to invalidate every token issued before
+	TOTPSecret          string                 `json:"-"`
+	TOTPEnabledAt       *time.Time             `json:"totp_enabled_at,omitempty"`
+	DeletionScheduledAt *time.Time             `json:"deletion_scheduled_at,omitempty"` // Closure requested by the user; logging in cancels it
+	DeletedAt           *time.Time             `json:"deleted_at,omitempty"`            // Set while soft-deleted, until purged
+	Attributes          map[string]interface{} `json:"attributes,omitempty"`            // Profile fields described by the attribute schema
+	CreatedAt           time.Time              `json:"created_at"`
+	UpdatedAt           time.Time              `json:"updated_at"`
+}
+
+// Fields GET /users can be sorted by; prefix with "-" for descending order
+var UserSortFields = []string{"name", "email", "created_at"}
+
+// UserListQuery selects one page of users. Pages are keyset-paginated: After
+// is the position of the last user on the previous page, so deep pages cost
+// the same as the first and concurrent inserts never shift results.
+type UserListQuery struct {
+	Search        string // Case-insensitive substring of the name or email
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	Verified      *bool             // Filter on whether the email was verified
+	Attributes    map[string]string // Attribute values to match, compared as text
+	Sort          string
+	Descending    bool
+	Limit         int
+	After         *UserCursor
+}
+
+// UserCursor is the sort value and ID of the last user on a page. Clients
+// receive it as an opaque string.
+type UserCursor struct {
+	Sort  string `json:"s"` // Sort it was made for, including the "-"
+	Value string `json:"v"`
+	ID    int    `json:"id"`
 }
 
 // Input struct for user registration (doesn't include hashed password)
 type UserRegisterInput struct {
 	Name     string `json:"name" binding:"required"`
 	Email    string `json:"email" binding:"required,email"`
-	Password string `json:"password" binding:"required,min=8"` // Add validation
+	Password string `json:"password" binding:"required"` // Checked against auth.ValidatePasswordPolicy
 }
 
 // Input struct for user update
 type UserUpdateInput struct {
-	Name *string `json:"name"` // Use pointers to distinguish between empty and not provided
+	Name  *string `json:"name"`                            // Use pointers to distinguish between empty and not provided
 	Email *string `json:"email" binding:"omitempty,email"` // Optional email update
+	// Merged into the stored attributes: listed keys are replaced, a null
+	// value removes the key and keys not listed are left alone
+	Attributes map[string]interface{} `json:"attributes"`
+}
+
+// AttributeSchema is a version of the JSON Schema that user attributes are
+// validated against. The newest version is the one in force.
+type AttributeSchema struct {
+	ID        int             `json:"id"` // 0 for the built-in default
+	Schema    json.RawMessage `json:"schema"`
+	CreatedBy *int            `json:"created_by"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// Input struct for replacing the attribute schema (admin only)
+type AttributeSchemaInput struct {
+	Schema json.RawMessage `json:"schema" binding:"required"`
+}
+
+// Input struct for changing a user's role (admin only)
+type UserRoleInput struct {
+	Role string `json:"role" binding:"required,oneof=user editor admin"`
 }
 
 // Input struct for login
@@ -33,6 +98,228 @@ type LoginInput struct {
 	Password string `json:"password" binding:"required"`
 }
 
+// RefreshToken is a stored (hashed) refresh token belonging to a token family
+type RefreshToken struct {
+	ID        int        `json:"id"`
+	UserID    int        `json:"user_id"`
+	FamilyID  string     `json:"family_id"`
+	TokenHash string     `json:"-"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// Session is one login on one device. Its ID is also the family ID of its
+// refresh tokens and the "sid" claim of its access tokens.
+type Session struct {
+	ID         string     `json:"id"`
+	UserID     int        `json:"-"`
+	UserAgent  string     `json:"user_agent"`
+	IP         string     `json:"ip"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastSeenAt time.Time  `json:"last_seen_at"` // Updated at login and on every token refresh
+	RevokedAt  *time.Time `json:"-"`
+	Current    bool       `json:"current"` // The session making the request
+}
+
+// Input struct for refreshing an access token
+type RefreshInput struct {
+	RefreshToken string `json:"refresh_token"` // Taken from the cookie when empty in cookie sessions
+}
+
+// Input struct for logout; the refresh token is optional
+type LogoutInput struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// Purposes for single-use user tokens
+const (
+	TokenPurposePasswordReset = "password_reset"
+	TokenPurposeMagicLink     = "magic_link"
+)
+
+// UserToken is a single-use, expiring token emailed to a user
+type UserToken struct {
+	ID        int
+	UserID    int
+	Purpose   string
+	TokenHash string
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+// Input struct for requesting a password reset email
+type ForgotPasswordInput struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// Input struct for requesting a magic login link
+type MagicLinkInput struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// Input struct for logging in with the token from a magic link
+type MagicLinkLoginInput struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// Input struct for resending the verification email
+type ResendVerificationInput struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// Input struct for completing a password reset
+type ResetPasswordInput struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required"` // Checked against auth.ValidatePasswordPolicy
+}
+
+// Input struct for changing the password of the logged-in user
+type ChangePasswordInput struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required"`
+}
+
+// LoginAttempt tracks consecutive failed logins for one account or client IP
+type LoginAttempt struct {
+	Key           string
+	Failures      int
+	LastFailureAt time.Time
+	LockedUntil   *time.Time
+}
+
+// Input struct for confirming 2FA enrolment or regenerating recovery codes
+type TOTPCodeInput struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// Input struct for disabling 2FA; requires both factors
+type DisableTOTPInput struct {
+	Password string `json:"password" binding:"required"`
+	Code     string `json:"code" binding:"required"`
+}
+
+// Input struct for the second login step; one of code or recovery_code is required
+type MFAVerifyInput struct {
+	MFAToken     string `json:"mfa_token" binding:"required"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// UserIdentity links a user to their account at an OpenID Connect provider
+type UserIdentity struct {
+	ID          int
+	UserID      int
+	Provider    string
+	Subject     string // The provider's stable user ID ("sub" claim)
+	Email       string // Email reported by the provider at the last login
+	LastLoginAt *time.Time
+	CreatedAt   time.Time
+}
+
+// WebAuthnCredential is a passkey registered by a user
+type WebAuthnCredential struct {
+	ID              int        `json:"id"`
+	UserID          int        `json:"-"`
+	Name            string     `json:"name"`
+	CredentialID    []byte     `json:"-"`
+	PublicKey       []byte     `json:"-"`
+	AttestationType string     `json:"-"`
+	AAGUID          []byte     `json:"-"`
+	SignCount       uint32     `json:"-"`
+	Transports      []string   `json:"transports"`
+	BackupEligible  bool       `json:"backup_eligible"` // Synced between the user's devices
+	BackupState     bool       `json:"backup_state"`
+	LastUsedAt      *time.Time `json:"last_used_at"`
+	CreatedAt       time.Time  `json:"created_at"`
+}
+
+// Kinds of WebAuthn ceremonies
+const (
+	WebAuthnCeremonyRegistration = "registration"
+	WebAuthnCeremonyLogin        = "login"
+)
+
+// WebAuthnCeremony keeps the server side of a registration or login between
+// its begin and finish calls
+type WebAuthnCeremony struct {
+	TokenHash   string
+	UserID      *int // nil for logins, where the user is only known at the end
+	Kind        string
+	SessionData []byte // JSON-encoded webauthn.SessionData
+	ExpiresAt   time.Time
+}
+
+// Input struct for finishing a passkey registration
+type PasskeyRegistrationInput struct {
+	Ceremony   string          `json:"ceremony" binding:"required"`
+	Name       string          `json:"name" binding:"max=100"`
+	Credential json.RawMessage `json:"credential" binding:"required"` // PublicKeyCredential from navigator.credentials.create()
+}
+
+// Input struct for finishing a passkey login
+type PasskeyLoginInput struct {
+	Ceremony   string          `json:"ceremony" binding:"required"`
+	Credential json.RawMessage `json:"credential" binding:"required"` // PublicKeyCredential from navigator.credentials.get()
+}
+
+// APIKey authenticates a service account; only the key's hash is stored
+type APIKey struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"` // First characters of the key, shown to tell keys apart
+	KeyHash    string     `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	CreatedBy  *int       `json:"created_by"` // Admin who created the key; NULL once they are deleted
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// Input struct for creating an API key (admin only)
+type APIKeyInput struct {
+	Name      string     `json:"name" binding:"required,max=100"`
+	Scopes    []string   `json:"scopes" binding:"required,min=1"`
+	ExpiresAt *time.Time `json:"expires_at"` // Optional; keys never expire by default
+}
+
+// ImpersonationAuditEntry records a request an admin made as another user
+type ImpersonationAuditEntry struct {
+	ID         int64     `json:"id"`
+	ActorID    int       `json:"actor_id"`
+	SubjectID  int       `json:"subject_id"`
+	TokenID    string    `json:"token_id"` // jti of the impersonation token
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	StatusCode *int      `json:"status_code"` // nil while the request is in progress
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Data export states
+const (
+	DataExportPending = "pending"
+	DataExportReady   = "ready"
+	DataExportFailed  = "failed"
+)
+
+// DataExport is a zip of everything stored about a user, built in the
+// background and downloaded through a link that expires with the export
+type DataExport struct {
+	ID          string     `json:"id"`
+	UserID      int        `json:"-"`
+	Status      string     `json:"status"`
+	Filename    string     `json:"-"` // Relative to DATA_EXPORT_DIR, set once ready
+	TokenHash   string     `json:"-"` // SHA-256 of the download token
+	Error       string     `json:"-"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	CompletedAt *time.Time `json:"completed_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
 type Product struct {
 	ID          int       `json:"id"`
 	Description string    `json:"description" binding:"required"`
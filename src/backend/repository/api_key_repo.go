@@ -0,0 +1,101 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Eduardo-Barreto/web-ponderada/backend/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const apiKeyColumns = "id, name, prefix, key_hash, scopes, created_by, expires_at, last_used_at, revoked_at, created_at"
+
+type postgresAPIKeyRepository struct {
+	db *pgxpool.Pool
+}
+
+// NewPostgresAPIKeyRepository creates a new instance of APIKeyRepository
+func NewPostgresAPIKeyRepository(db *pgxpool.Pool) APIKeyRepository {
+	return &postgresAPIKeyRepository{db: db}
+}
+
+func scanAPIKey(row pgx.Row) (*models.APIKey, error) {
+	key := &models.APIKey{}
+	err := row.Scan(&key.ID, &key.Name, &key.Prefix, &key.KeyHash, &key.Scopes, &key.CreatedBy,
+		&key.ExpiresAt, &key.LastUsedAt, &key.RevokedAt, &key.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (r *postgresAPIKeyRepository) CreateAPIKey(ctx context.Context, key *models.APIKey) (int, error) {
+	query := `INSERT INTO api_keys (name, prefix, key_hash, scopes, created_by, expires_at, created_at)
+	          VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`
+	now := time.Now()
+	err := r.db.QueryRow(ctx, query, key.Name, key.Prefix, key.KeyHash, key.Scopes, key.CreatedBy, key.ExpiresAt, now).Scan(&key.ID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create api key: %w", err)
+	}
+	key.CreatedAt = now
+	return key.ID, nil
+}
+
+func (r *postgresAPIKeyRepository) GetAPIKeyByHash(ctx context.Context, keyHash string) (*models.APIKey, error) {
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE key_hash = $1`
+	key, err := scanAPIKey(r.db.QueryRow(ctx, query, keyHash))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, errors.New("api key not found")
+		}
+		return nil, fmt.Errorf("failed to get api key: %w", err)
+	}
+	return key, nil
+}
+
+func (r *postgresAPIKeyRepository) GetAllAPIKeys(ctx context.Context) ([]models.APIKey, error) {
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys ORDER BY created_at DESC`
+	rows, err := r.db.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query api keys: %w", err)
+	}
+	defer rows.Close()
+
+	keys := []models.APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan api key row: %w", err)
+		}
+		keys = append(keys, *key)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating api key rows: %w", err)
+	}
+
+	return keys, nil
+}
+
+func (r *postgresAPIKeyRepository) RevokeAPIKey(ctx context.Context, id int) error {
+	query := `UPDATE api_keys SET revoked_at = $1 WHERE id = $2 AND revoked_at IS NULL`
+	cmdTag, err := r.db.Exec(ctx, query, time.Now(), id)
+	if err != nil {
+		return fmt.Errorf("failed to revoke api key: %w", err)
+	}
+	if cmdTag.RowsAffected() == 0 {
+		return errors.New("api key not found")
+	}
+	return nil
+}
+
+func (r *postgresAPIKeyRepository) TouchAPIKey(ctx context.Context, id int, usedAt time.Time) error {
+	query := `UPDATE api_keys SET last_used_at = $1 WHERE id = $2`
+	if _, err := r.db.Exec(ctx, query, usedAt, id); err != nil {
+		return fmt.Errorf("failed to update api key last use: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Eduardo-Barreto/web-ponderada/backend/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type postgresAttributeSchemaRepository struct {
+	db *pgxpool.Pool
+}
+
+// NewPostgresAttributeSchemaRepository creates a new instance of AttributeSchemaRepository
+func NewPostgresAttributeSchemaRepository(db *pgxpool.Pool) AttributeSchemaRepository {
+	return &postgresAttributeSchemaRepository{db: db}
+}
+
+func (r *postgresAttributeSchemaRepository) GetCurrentSchema(ctx context.Context) (*models.AttributeSchema, error) {
+	query := `SELECT id, schema, created_by, created_at FROM user_attribute_schemas ORDER BY id DESC LIMIT 1`
+	schema := &models.AttributeSchema{}
+	err := r.db.QueryRow(ctx, query).Scan(&schema.ID, &schema.Schema, &schema.CreatedBy, &schema.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get attribute schema: %w", err)
+	}
+	return schema, nil
+}
+
+func (r *postgresAttributeSchemaRepository) CreateSchema(ctx context.Context, schema *models.AttributeSchema) (int, error) {
+	// Earlier versions are kept as a record of what was enforced when
+	query := `INSERT INTO user_attribute_schemas (schema, created_by, created_at) VALUES ($1, $2, $3) RETURNING id`
+	now := time.Now()
+	if err := r.db.QueryRow(ctx, query, schema.Schema, schema.CreatedBy, now).Scan(&schema.ID); err != nil {
+		return 0, fmt.Errorf("failed to create attribute schema: %w", err)
+	}
+	schema.CreatedAt = now
+	return schema.ID, nil
+}
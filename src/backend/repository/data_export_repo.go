@@ -0,0 +1,163 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Eduardo-Barreto/web-ponderada/backend/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type postgresDataExportRepository struct {
+	db *pgxpool.Pool
+}
+
+// NewPostgresDataExportRepository creates a new instance of DataExportRepository
+func NewPostgresDataExportRepository(db *pgxpool.Pool) DataExportRepository {
+	return &postgresDataExportRepository{db: db}
+}
+
+const dataExportColumns = `id, user_id, status, filename, token_hash, error, expires_at, completed_at, created_at`
+
+func scanDataExport(row pgx.Row) (*models.DataExport, error) {
+	export := &models.DataExport{}
+	err := row.Scan(&export.ID, &export.UserID, &export.Status, &export.Filename, &export.TokenHash, &export.Error,
+		&export.ExpiresAt, &export.CompletedAt, &export.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return export, nil
+}
+
+func (r *postgresDataExportRepository) CreateExport(ctx context.Context, export *models.DataExport) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx) // No-op after commit
+
+	// Builds interrupted by a restart stay pending; once expired they must not
+	// keep blocking new requests through idx_data_exports_user_pending
+	now := time.Now()
+	query := `UPDATE data_exports SET status = 'failed', error = 'interrupted', completed_at = $1
+	          WHERE user_id = $2 AND status = 'pending' AND expires_at <= $1`
+	if _, err := tx.Exec(ctx, query, now, export.UserID); err != nil {
+		return fmt.Errorf("failed to expire interrupted data exports: %w", err)
+	}
+
+	// Fails with a unique violation while another export of the user is pending
+	query = `INSERT INTO data_exports (id, user_id, status, token_hash, expires_at, created_at)
+	         VALUES ($1, $2, $3, $4, $5, $6)`
+	if _, err := tx.Exec(ctx, query, export.ID, export.UserID, export.Status, export.TokenHash, export.ExpiresAt, now); err != nil {
+		return fmt.Errorf("failed to create data export: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit data export: %w", err)
+	}
+	export.CreatedAt = now
+	return nil
+}
+
+func (r *postgresDataExportRepository) GetExport(ctx context.Context, userID int, id string) (*models.DataExport, error) {
+	query := `SELECT ` + dataExportColumns + ` FROM data_exports WHERE id = $1 AND user_id = $2`
+	export, err := scanDataExport(r.db.QueryRow(ctx, query, id, userID))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, errors.New("export not found")
+		}
+		return nil, fmt.Errorf("failed to get data export: %w", err)
+	}
+	return export, nil
+}
+
+func (r *postgresDataExportRepository) GetReadyExportByTokenHash(ctx context.Context, tokenHash string) (*models.DataExport, error) {
+	query := `SELECT ` + dataExportColumns + ` FROM data_exports
+	          WHERE token_hash = $1 AND status = 'ready' AND expires_at > $2`
+	export, err := scanDataExport(r.db.QueryRow(ctx, query, tokenHash, time.Now()))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, errors.New("export not ready or expired")
+		}
+		return nil, fmt.Errorf("failed to get data export: %w", err)
+	}
+	return export, nil
+}
+
+func (r *postgresDataExportRepository) GetUserExports(ctx context.Context, userID int) ([]models.DataExport, error) {
+	query := `SELECT ` + dataExportColumns + ` FROM data_exports WHERE user_id = $1 ORDER BY created_at`
+	rows, err := r.db.Query(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query data exports: %w", err)
+	}
+	defer rows.Close()
+
+	exports := []models.DataExport{}
+	for rows.Next() {
+		export, err := scanDataExport(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan data export row: %w", err)
+		}
+		exports = append(exports, *export)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating data export rows: %w", err)
+	}
+
+	return exports, nil
+}
+
+func (r *postgresDataExportRepository) DeleteUserExports(ctx context.Context, userID int) error {
+	if _, err := r.db.Exec(ctx, `DELETE FROM data_exports WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("failed to delete user data exports: %w", err)
+	}
+	return nil
+}
+
+func (r *postgresDataExportRepository) CompleteExport(ctx context.Context, id, filename string) error {
+	query := `UPDATE data_exports SET status = 'ready', filename = $1, completed_at = $2 WHERE id = $3 AND status = 'pending'`
+	cmdTag, err := r.db.Exec(ctx, query, filename, time.Now(), id)
+	if err != nil {
+		return fmt.Errorf("failed to complete data export: %w", err)
+	}
+	if cmdTag.RowsAffected() == 0 {
+		return errors.New("export not found")
+	}
+	return nil
+}
+
+func (r *postgresDataExportRepository) FailExport(ctx context.Context, id, reason string) error {
+	query := `UPDATE data_exports SET status = 'failed', error = $1, completed_at = $2 WHERE id = $3`
+	if _, err := r.db.Exec(ctx, query, reason, time.Now(), id); err != nil {
+		return fmt.Errorf("failed to mark data export as failed: %w", err)
+	}
+	return nil
+}
+
+func (r *postgresDataExportRepository) DeleteExpiredExports(ctx context.Context) ([]models.DataExport, error) {
+	query := `DELETE FROM data_exports WHERE expires_at <= $1 RETURNING ` + dataExportColumns
+	rows, err := r.db.Query(ctx, query, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete expired data exports: %w", err)
+	}
+	defer rows.Close()
+
+	exports := []models.DataExport{}
+	for rows.Next() {
+		export, err := scanDataExport(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan data export row: %w", err)
+		}
+		exports = append(exports, *export)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating data export rows: %w", err)
+	}
+
+	return exports, nil
+}
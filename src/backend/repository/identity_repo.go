@@ -0,0 +1,84 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Eduardo-Barreto/web-ponderada/backend/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type postgresIdentityRepository struct {
+	db *pgxpool.Pool
+}
+
+// NewPostgresIdentityRepository creates a new instance of IdentityRepository
+func NewPostgresIdentityRepository(db *pgxpool.Pool) IdentityRepository {
+	return &postgresIdentityRepository{db: db}
+}
+
+func (r *postgresIdentityRepository) GetIdentity(ctx context.Context, provider, subject string) (*models.UserIdentity, error) {
+	query := `SELECT id, user_id, provider, subject, email, last_login_at, created_at
+	          FROM user_identities WHERE provider = $1 AND subject = $2`
+	identity := &models.UserIdentity{}
+	err := r.db.QueryRow(ctx, query, provider, subject).Scan(
+		&identity.ID, &identity.UserID, &identity.Provider, &identity.Subject, &identity.Email, &identity.LastLoginAt, &identity.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil // Not linked yet
+		}
+		return nil, fmt.Errorf("failed to get identity: %w", err)
+	}
+	return identity, nil
+}
+
+func (r *postgresIdentityRepository) CreateIdentity(ctx context.Context, identity *models.UserIdentity) (int, error) {
+	query := `INSERT INTO user_identities (user_id, provider, subject, email, last_login_at, created_at)
+	          VALUES ($1, $2, $3, $4, $5, $5) RETURNING id`
+	now := time.Now()
+	err := r.db.QueryRow(ctx, query, identity.UserID, identity.Provider, identity.Subject, identity.Email, now).Scan(&identity.ID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create identity: %w", err)
+	}
+	identity.LastLoginAt = &now
+	identity.CreatedAt = now
+	return identity.ID, nil
+}
+
+func (r *postgresIdentityRepository) TouchIdentity(ctx context.Context, id int, email string) error {
+	query := `UPDATE user_identities SET last_login_at = $1, email = $2 WHERE id = $3`
+	if _, err := r.db.Exec(ctx, query, time.Now(), email, id); err != nil {
+		return fmt.Errorf("failed to update identity: %w", err)
+	}
+	return nil
+}
+
+func (r *postgresIdentityRepository) GetIdentitiesByUser(ctx context.Context, userID int) ([]models.UserIdentity, error) {
+	query := `SELECT id, user_id, provider, subject, email, last_login_at, created_at
+	          FROM user_identities WHERE user_id = $1 ORDER BY created_at`
+	rows, err := r.db.Query(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query identities: %w", err)
+	}
+	defer rows.Close()
+
+	identities := []models.UserIdentity{}
+	for rows.Next() {
+		var identity models.UserIdentity
+		if err := rows.Scan(&identity.ID, &identity.UserID, &identity.Provider, &identity.Subject, &identity.Email,
+			&identity.LastLoginAt, &identity.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan identity row: %w", err)
+		}
+		identities = append(identities, identity)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating identity rows: %w", err)
+	}
+
+	return identities, nil
+}
@@ -0,0 +1,94 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Eduardo-Barreto/web-ponderada/backend/models"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type postgresImpersonationAuditRepository struct {
+	db *pgxpool.Pool
+}
+
+// NewPostgresImpersonationAuditRepository creates a new instance of ImpersonationAuditRepository
+func NewPostgresImpersonationAuditRepository(db *pgxpool.Pool) ImpersonationAuditRepository {
+	return &postgresImpersonationAuditRepository{db: db}
+}
+
+func (r *postgresImpersonationAuditRepository) CreateAuditEntry(ctx context.Context, entry *models.ImpersonationAuditEntry) (int64, error) {
+	query := `INSERT INTO impersonation_audit_log (actor_id, subject_id, token_id, method, path, status_code, ip, created_at)
+	          VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id`
+	now := time.Now()
+	err := r.db.QueryRow(ctx, query, entry.ActorID, entry.SubjectID, entry.TokenID, entry.Method, entry.Path,
+		entry.StatusCode, entry.IP, now).Scan(&entry.ID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create audit entry: %w", err)
+	}
+	entry.CreatedAt = now
+	return entry.ID, nil
+}
+
+func (r *postgresImpersonationAuditRepository) SetAuditEntryStatus(ctx context.Context, id int64, statusCode int) error {
+	query := `UPDATE impersonation_audit_log SET status_code = $1 WHERE id = $2`
+	if _, err := r.db.Exec(ctx, query, statusCode, id); err != nil {
+		return fmt.Errorf("failed to update audit entry: %w", err)
+	}
+	return nil
+}
+
+func (r *postgresImpersonationAuditRepository) GetAuditEntries(ctx context.Context, subjectID int, limit int) ([]models.ImpersonationAuditEntry, error) {
+	query := `SELECT id, actor_id, subject_id, token_id, method, path, status_code, ip, created_at
+	          FROM impersonation_audit_log WHERE $1 = 0 OR subject_id = $1
+	          ORDER BY created_at DESC, id DESC LIMIT $2`
+	rows, err := r.db.Query(ctx, query, subjectID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audit entries: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []models.ImpersonationAuditEntry{}
+	for rows.Next() {
+		var entry models.ImpersonationAuditEntry
+		if err := rows.Scan(&entry.ID, &entry.ActorID, &entry.SubjectID, &entry.TokenID, &entry.Method, &entry.Path,
+			&entry.StatusCode, &entry.IP, &entry.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan audit entry row: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating audit entry rows: %w", err)
+	}
+
+	return entries, nil
+}
+
+func (r *postgresImpersonationAuditRepository) GetAuditEntriesForUser(ctx context.Context, userID int) ([]models.ImpersonationAuditEntry, error) {
+	query := `SELECT id, actor_id, subject_id, token_id, method, path, status_code, ip, created_at
+	          FROM impersonation_audit_log WHERE actor_id = $1 OR subject_id = $1
+	          ORDER BY created_at, id`
+	rows, err := r.db.Query(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audit entries: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []models.ImpersonationAuditEntry{}
+	for rows.Next() {
+		var entry models.ImpersonationAuditEntry
+		if err := rows.Scan(&entry.ID, &entry.ActorID, &entry.SubjectID, &entry.TokenID, &entry.Method, &entry.Path,
+			&entry.StatusCode, &entry.IP, &entry.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan audit entry row: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating audit entry rows: %w", err)
+	}
+
+	return entries, nil
+}
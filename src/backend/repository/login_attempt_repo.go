@@ -0,0 +1,66 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Eduardo-Barreto/web-ponderada/backend/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type postgresLoginAttemptRepository struct {
+	db *pgxpool.Pool
+}
+
+// NewPostgresLoginAttemptRepository creates a new instance of LoginAttemptRepository
+func NewPostgresLoginAttemptRepository(db *pgxpool.Pool) LoginAttemptRepository {
+	return &postgresLoginAttemptRepository{db: db}
+}
+
+func (r *postgresLoginAttemptRepository) GetLoginAttempt(ctx context.Context, key string) (*models.LoginAttempt, error) {
+	query := `SELECT key, failures, last_failure_at, locked_until FROM login_attempts WHERE key = $1`
+	attempt := &models.LoginAttempt{}
+	err := r.db.QueryRow(ctx, query, key).Scan(&attempt.Key, &attempt.Failures, &attempt.LastFailureAt, &attempt.LockedUntil)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil // No failures recorded is not an error
+		}
+		return nil, fmt.Errorf("failed to get login attempt: %w", err)
+	}
+	return attempt, nil
+}
+
+func (r *postgresLoginAttemptRepository) RecordLoginFailure(ctx context.Context, key string, windowStart time.Time) (*models.LoginAttempt, error) {
+	// Counting restarts at 1 when the previous failure is older than the window
+	query := `INSERT INTO login_attempts (key, failures, last_failure_at)
+	          VALUES ($1, 1, $2)
+	          ON CONFLICT (key) DO UPDATE SET
+	              failures = CASE WHEN login_attempts.last_failure_at < $3 THEN 1 ELSE login_attempts.failures + 1 END,
+	              last_failure_at = EXCLUDED.last_failure_at
+	          RETURNING key, failures, last_failure_at, locked_until`
+	attempt := &models.LoginAttempt{}
+	err := r.db.QueryRow(ctx, query, key, time.Now(), windowStart).Scan(&attempt.Key, &attempt.Failures, &attempt.LastFailureAt, &attempt.LockedUntil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record login failure: %w", err)
+	}
+	return attempt, nil
+}
+
+func (r *postgresLoginAttemptRepository) SetLoginLockout(ctx context.Context, key string, lockedUntil time.Time) error {
+	query := `UPDATE login_attempts SET locked_until = $1 WHERE key = $2`
+	if _, err := r.db.Exec(ctx, query, lockedUntil, key); err != nil {
+		return fmt.Errorf("failed to set login lockout: %w", err)
+	}
+	return nil
+}
+
+func (r *postgresLoginAttemptRepository) ResetLoginAttempts(ctx context.Context, key string) error {
+	query := `DELETE FROM login_attempts WHERE key = $1`
+	if _, err := r.db.Exec(ctx, query, key); err != nil {
+		return fmt.Errorf("failed to reset login attempts: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type postgresRecoveryCodeRepository struct {
+	db *pgxpool.Pool
+}
+
+// NewPostgresRecoveryCodeRepository creates a new instance of RecoveryCodeRepository
+func NewPostgresRecoveryCodeRepository(db *pgxpool.Pool) RecoveryCodeRepository {
+	return &postgresRecoveryCodeRepository{db: db}
+}
+
+func (r *postgresRecoveryCodeRepository) ReplaceRecoveryCodes(ctx context.Context, userID int, codeHashes []string) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx) // No-op after commit
+
+	if _, err := tx.Exec(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("failed to delete old recovery codes: %w", err)
+	}
+
+	now := time.Now()
+	for _, hash := range codeHashes {
+		query := `INSERT INTO mfa_recovery_codes (user_id, code_hash, created_at) VALUES ($1, $2, $3)`
+		if _, err := tx.Exec(ctx, query, userID, hash, now); err != nil {
+			return fmt.Errorf("failed to store recovery code: %w", err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit recovery codes: %w", err)
+	}
+	return nil
+}
+
+func (r *postgresRecoveryCodeRepository) ConsumeRecoveryCode(ctx context.Context, userID int, codeHash string) (bool, error) {
+	query := `UPDATE mfa_recovery_codes SET used_at = $1 WHERE user_id = $2 AND code_hash = $3 AND used_at IS NULL`
+	cmdTag, err := r.db.Exec(ctx, query, time.Now(), userID, codeHash)
+	if err != nil {
+		return false, fmt.Errorf("failed to consume recovery code: %w", err)
+	}
+	return cmdTag.RowsAffected() > 0, nil
+}
+
+func (r *postgresRecoveryCodeRepository) DeleteRecoveryCodes(ctx context.Context, userID int) error {
+	if _, err := r.db.Exec(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+	return nil
+}
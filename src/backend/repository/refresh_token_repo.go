@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Eduardo-Barreto/web-ponderada/backend/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type postgresRefreshTokenRepository struct {
+	db *pgxpool.Pool
+}
+
+// NewPostgresRefreshTokenRepository creates a new instance of RefreshTokenRepository
+func NewPostgresRefreshTokenRepository(db *pgxpool.Pool) RefreshTokenRepository {
+	return &postgresRefreshTokenRepository{db: db}
+}
+
+func (r *postgresRefreshTokenRepository) CreateRefreshToken(ctx context.Context, token *models.RefreshToken) (int, error) {
+	query := `INSERT INTO refresh_tokens (user_id, family_id, token_hash, expires_at, created_at)
+	          VALUES ($1, $2, $3, $4, $5) RETURNING id`
+	now := time.Now()
+	err := r.db.QueryRow(ctx, query, token.UserID, token.FamilyID, token.TokenHash, token.ExpiresAt, now).Scan(&token.ID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create refresh token: %w", err)
+	}
+	token.CreatedAt = now
+	return token.ID, nil
+}
+
+func (r *postgresRefreshTokenRepository) GetRefreshTokenByHash(ctx context.Context, tokenHash string) (*models.RefreshToken, error) {
+	query := `SELECT id, user_id, family_id, token_hash, expires_at, used_at, revoked_at, created_at
+	          FROM refresh_tokens WHERE token_hash = $1`
+	token := &models.RefreshToken{}
+	err := r.db.QueryRow(ctx, query, tokenHash).Scan(
+		&token.ID, &token.UserID, &token.FamilyID, &token.TokenHash, &token.ExpiresAt, &token.UsedAt, &token.RevokedAt, &token.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, errors.New("refresh token not found")
+		}
+		return nil, fmt.Errorf("failed to get refresh token: %w", err)
+	}
+	return token, nil
+}
+
+func (r *postgresRefreshTokenRepository) MarkRefreshTokenUsed(ctx context.Context, id int) (bool, error) {
+	// The IS NULL guards make this a compare-and-swap, so two concurrent
+	// rotations of the same token cannot both succeed.
+	query := `UPDATE refresh_tokens SET used_at = $1 WHERE id = $2 AND used_at IS NULL AND revoked_at IS NULL`
+	cmdTag, err := r.db.Exec(ctx, query, time.Now(), id)
+	if err != nil {
+		return false, fmt.Errorf("failed to mark refresh token as used: %w", err)
+	}
+	return cmdTag.RowsAffected() == 1, nil
+}
+
+func (r *postgresRefreshTokenRepository) RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+	query := `UPDATE refresh_tokens SET revoked_at = $1 WHERE family_id = $2 AND revoked_at IS NULL`
+	if _, err := r.db.Exec(ctx, query, time.Now(), familyID); err != nil {
+		return fmt.Errorf("failed to revoke refresh token family: %w", err)
+	}
+	return nil
+}
+
+func (r *postgresRefreshTokenRepository) RevokeUserRefreshTokens(ctx context.Context, userID int) error {
+	query := `UPDATE refresh_tokens SET revoked_at = $1 WHERE user_id = $2 AND revoked_at IS NULL`
+	if _, err := r.db.Exec(ctx, query, time.Now(), userID); err != nil {
+		return fmt.Errorf("failed to revoke user refresh tokens: %w", err)
+	}
+	return nil
+}
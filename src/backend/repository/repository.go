@@ -2,7 +2,9 @@ package repository
 
 import (
 	"context"
+	"io"
 	"mime/multipart"
+	"time"
 
 	"github.com/Eduardo-Barreto/web-ponderada/backend/models"
 )
@@ -12,10 +14,28 @@ type UserRepository interface {
 	CreateUser(ctx context.Context, user *models.User) (int, error)
 	GetUserByEmail(ctx context.Context, email string) (*models.User, error)
 	GetUserByID(ctx context.Context, id int) (*models.User, error)
-	GetAllUsers(ctx context.Context) ([]models.User, error)
+	GetUsers(ctx context.Context, query *models.UserListQuery) ([]models.User, error)
 	UpdateUser(ctx context.Context, id int, updateData *models.UserUpdateInput) error
-	UpdateUserProfilePic(ctx context.Context, id int, filename string) error
-	DeleteUser(ctx context.Context, id int) error
+	UpdateUserProfilePic(ctx context.Context, id int, filename string, variants map[string]string) error
+	UpdateUserRole(ctx context.Context, id int, role string) error
+	PromoteBootstrapAdmin(ctx context.Context, id int) (bool, error) // false if any admin exists, even a deleted one
+	UpdateUserPassword(ctx context.Context, id int, passwordHash string) error
+	UpgradePasswordHash(ctx context.Context, id int, oldHash, newHash string) error // No-op if the password changed meanwhile
+	MarkEmailVerified(ctx context.Context, id int, email string) error
+	SetTOTPSecret(ctx context.Context, id int, secret string) error // pending until EnableTOTP
+	EnableTOTP(ctx context.Context, id int) error
+	DisableTOTP(ctx context.Context, id int) error
+	UseTOTPStep(ctx context.Context, id int, step int64) (bool, error) // false if this or a later step was already used
+	DeleteUser(ctx context.Context, id int) error // Soft delete, see RestoreUser and PurgeUser
+	GetDeletedUsers(ctx context.Context, deletedBefore time.Time) ([]models.User, error) // Oldest deletion first
+	RestoreUser(ctx context.Context, id int) error
+	PurgeUser(ctx context.Context, id int) error // Permanently removes a soft-deleted user
+	ScheduleUserDeletion(ctx context.Context, id int, at time.Time) error
+	CancelUserDeletion(ctx context.Context, id int) (bool, error) // false if none was scheduled
+	GetUsersDueForDeletion(ctx context.Context, now time.Time) ([]models.User, error)
+	AnonymizeUser(ctx context.Context, id int) error
+	GetUserTokenVersion(ctx context.Context, id int) (int, error)
+	IncrementUserTokenVersion(ctx context.Context, id int) (int, error) // returns the new version
 }
 
 // ProductRepository defines methods for product data access
@@ -27,9 +47,113 @@ type ProductRepository interface {
 	DeleteProduct(ctx context.Context, id int) error
 }
 
+// RefreshTokenRepository defines methods for refresh token persistence
+type RefreshTokenRepository interface {
+	CreateRefreshToken(ctx context.Context, token *models.RefreshToken) (int, error)
+	GetRefreshTokenByHash(ctx context.Context, tokenHash string) (*models.RefreshToken, error)
+	MarkRefreshTokenUsed(ctx context.Context, id int) (bool, error) // false if it was already used or revoked
+	RevokeRefreshTokenFamily(ctx context.Context, familyID string) error
+	RevokeUserRefreshTokens(ctx context.Context, userID int) error
+}
+
+// SessionRepository defines methods for login sessions
+type SessionRepository interface {
+	CreateSession(ctx context.Context, session *models.Session) error
+	GetActiveSessions(ctx context.Context, userID int, seenSince time.Time) ([]models.Session, error)
+	GetUserSessions(ctx context.Context, userID int) ([]models.Session, error) // All of them, revoked too
+	IsSessionRevoked(ctx context.Context, id string) (bool, error) // true for unknown sessions too
+	TouchSession(ctx context.Context, id, ip string, seenAt time.Time) error
+	RevokeSession(ctx context.Context, userID int, id string) error
+	RevokeUserSessions(ctx context.Context, userID int) error
+}
+
+// TokenRevocationRepository defines methods for the access token (jti) denylist
+type TokenRevocationRepository interface {
+	RevokeToken(ctx context.Context, jti string, userID int, expiresAt time.Time) error
+	IsTokenRevoked(ctx context.Context, jti string) (bool, error)
+	DeleteExpiredRevocations(ctx context.Context) (int64, error)
+}
+
+// UserTokenRepository defines methods for single-use tokens sent to users
+type UserTokenRepository interface {
+	CreateUserToken(ctx context.Context, token *models.UserToken) (int, error)
+	GetUserToken(ctx context.Context, purpose, tokenHash string) (*models.UserToken, error)     // fails if used or expired, without consuming it
+	ConsumeUserToken(ctx context.Context, purpose, tokenHash string) (*models.UserToken, error) // marks it used; fails if used or expired
+	InvalidateUserTokens(ctx context.Context, userID int, purpose string) error
+}
+
+// RecoveryCodeRepository defines methods for 2FA recovery codes
+type RecoveryCodeRepository interface {
+	ReplaceRecoveryCodes(ctx context.Context, userID int, codeHashes []string) error
+	ConsumeRecoveryCode(ctx context.Context, userID int, codeHash string) (bool, error) // false if unknown or already used
+	DeleteRecoveryCodes(ctx context.Context, userID int) error
+}
+
+// IdentityRepository defines methods for links to external (OIDC) identities
+type IdentityRepository interface {
+	GetIdentity(ctx context.Context, provider, subject string) (*models.UserIdentity, error) // nil if not linked
+	CreateIdentity(ctx context.Context, identity *models.UserIdentity) (int, error)
+	TouchIdentity(ctx context.Context, id int, email string) error // Records a login
+	GetIdentitiesByUser(ctx context.Context, userID int) ([]models.UserIdentity, error)
+}
+
+// WebAuthnRepository defines methods for passkeys and their pending ceremonies
+type WebAuthnRepository interface {
+	CreateCredential(ctx context.Context, credential *models.WebAuthnCredential) (int, error)
+	GetCredentialsByUser(ctx context.Context, userID int) ([]models.WebAuthnCredential, error)
+	UpdateCredentialUsage(ctx context.Context, id int, signCount uint32, backupState bool, usedAt time.Time) error
+	DeleteCredential(ctx context.Context, userID, id int) error
+	CreateCeremony(ctx context.Context, ceremony *models.WebAuthnCeremony) error
+	ConsumeCeremony(ctx context.Context, kind, tokenHash string) (*models.WebAuthnCeremony, error) // deletes it; fails if unknown or expired
+}
+
+// APIKeyRepository defines methods for service account API keys
+type APIKeyRepository interface {
+	CreateAPIKey(ctx context.Context, key *models.APIKey) (int, error)
+	GetAPIKeyByHash(ctx context.Context, keyHash string) (*models.APIKey, error)
+	GetAllAPIKeys(ctx context.Context) ([]models.APIKey, error)
+	RevokeAPIKey(ctx context.Context, id int) error
+	TouchAPIKey(ctx context.Context, id int, usedAt time.Time) error // Records the last-used timestamp
+}
+
+// ImpersonationAuditRepository defines methods for the impersonation audit log
+type ImpersonationAuditRepository interface {
+	CreateAuditEntry(ctx context.Context, entry *models.ImpersonationAuditEntry) (int64, error)
+	SetAuditEntryStatus(ctx context.Context, id int64, statusCode int) error
+	GetAuditEntries(ctx context.Context, subjectID int, limit int) ([]models.ImpersonationAuditEntry, error) // subjectID 0 for all users
+	GetAuditEntriesForUser(ctx context.Context, userID int) ([]models.ImpersonationAuditEntry, error) // As actor or subject
+}
+
+// DataExportRepository defines methods for personal data exports
+type DataExportRepository interface {
+	CreateExport(ctx context.Context, export *models.DataExport) error
+	GetExport(ctx context.Context, userID int, id string) (*models.DataExport, error)
+	GetReadyExportByTokenHash(ctx context.Context, tokenHash string) (*models.DataExport, error) // fails if not ready or expired
+	GetUserExports(ctx context.Context, userID int) ([]models.DataExport, error)
+	DeleteUserExports(ctx context.Context, userID int) error
+	CompleteExport(ctx context.Context, id, filename string) error
+	FailExport(ctx context.Context, id, reason string) error
+	DeleteExpiredExports(ctx context.Context) ([]models.DataExport, error) // returns them so their files can be removed
+}
+
+// AttributeSchemaRepository defines methods for the versioned user attribute schema
+type AttributeSchemaRepository interface {
+	GetCurrentSchema(ctx context.Context) (*models.AttributeSchema, error) // nil if no admin has set one
+	CreateSchema(ctx context.Context, schema *models.AttributeSchema) (int, error) // becomes the current version
+}
+
+// LoginAttemptRepository defines methods for failed login tracking
+type LoginAttemptRepository interface {
+	GetLoginAttempt(ctx context.Context, key string) (*models.LoginAttempt, error) // nil if no failures recorded
+	RecordLoginFailure(ctx context.Context, key string, windowStart time.Time) (*models.LoginAttempt, error)
+	SetLoginLockout(ctx context.Context, key string, lockedUntil time.Time) error
+	ResetLoginAttempts(ctx context.Context, key string) error
+}
+
 // StorageRepository defines methods for file storage (could be local, S3, etc.)
 type StorageRepository interface {
 	SaveFile(ctx context.Context, file *multipart.FileHeader, destination string) (string, error) // returns generated filename
+	SaveContent(ctx context.Context, filename string, content io.Reader) error // stores at the given relative path
 	GetFilePath(filename string) string
 	DeleteFile(ctx context.Context, filename string) error
 }
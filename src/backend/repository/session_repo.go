@@ -0,0 +1,122 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Eduardo-Barreto/web-ponderada/backend/models"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type postgresSessionRepository struct {
+	db *pgxpool.Pool
+}
+
+// NewPostgresSessionRepository creates a new instance of SessionRepository
+func NewPostgresSessionRepository(db *pgxpool.Pool) SessionRepository {
+	return &postgresSessionRepository{db: db}
+}
+
+func (r *postgresSessionRepository) CreateSession(ctx context.Context, session *models.Session) error {
+	query := `INSERT INTO sessions (id, user_id, user_agent, ip, created_at, last_seen_at)
+	          VALUES ($1, $2, $3, $4, $5, $5)`
+	now := time.Now()
+	if _, err := r.db.Exec(ctx, query, session.ID, session.UserID, session.UserAgent, session.IP, now); err != nil {
+		return fmt.Errorf("failed to create session: %w", err)
+	}
+	session.CreatedAt = now
+	session.LastSeenAt = now
+	return nil
+}
+
+func (r *postgresSessionRepository) GetActiveSessions(ctx context.Context, userID int, seenSince time.Time) ([]models.Session, error) {
+	query := `SELECT id, user_id, user_agent, ip, created_at, last_seen_at FROM sessions
+	          WHERE user_id = $1 AND revoked_at IS NULL AND last_seen_at > $2
+	          ORDER BY last_seen_at DESC`
+	rows, err := r.db.Query(ctx, query, userID, seenSince)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query sessions: %w", err)
+	}
+	defer rows.Close()
+
+	sessions := []models.Session{}
+	for rows.Next() {
+		var session models.Session
+		err := rows.Scan(&session.ID, &session.UserID, &session.UserAgent, &session.IP, &session.CreatedAt, &session.LastSeenAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan session row: %w", err)
+		}
+		sessions = append(sessions, session)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating session rows: %w", err)
+	}
+
+	return sessions, nil
+}
+
+func (r *postgresSessionRepository) GetUserSessions(ctx context.Context, userID int) ([]models.Session, error) {
+	query := `SELECT id, user_id, user_agent, ip, created_at, last_seen_at, revoked_at FROM sessions
+	          WHERE user_id = $1 ORDER BY created_at DESC`
+	rows, err := r.db.Query(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query sessions: %w", err)
+	}
+	defer rows.Close()
+
+	sessions := []models.Session{}
+	for rows.Next() {
+		var session models.Session
+		err := rows.Scan(&session.ID, &session.UserID, &session.UserAgent, &session.IP, &session.CreatedAt, &session.LastSeenAt, &session.RevokedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan session row: %w", err)
+		}
+		sessions = append(sessions, session)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating session rows: %w", err)
+	}
+
+	return sessions, nil
+}
+
+func (r *postgresSessionRepository) IsSessionRevoked(ctx context.Context, id string) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM sessions WHERE id = $1 AND revoked_at IS NULL)`
+	var active bool
+	if err := r.db.QueryRow(ctx, query, id).Scan(&active); err != nil {
+		return false, fmt.Errorf("failed to check session: %w", err)
+	}
+	return !active, nil
+}
+
+func (r *postgresSessionRepository) TouchSession(ctx context.Context, id, ip string, seenAt time.Time) error {
+	query := `UPDATE sessions SET last_seen_at = $1, ip = $2 WHERE id = $3`
+	if _, err := r.db.Exec(ctx, query, seenAt, ip, id); err != nil {
+		return fmt.Errorf("failed to update session: %w", err)
+	}
+	return nil
+}
+
+func (r *postgresSessionRepository) RevokeSession(ctx context.Context, userID int, id string) error {
+	query := `UPDATE sessions SET revoked_at = $1 WHERE id = $2 AND user_id = $3 AND revoked_at IS NULL`
+	cmdTag, err := r.db.Exec(ctx, query, time.Now(), id, userID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+	if cmdTag.RowsAffected() == 0 {
+		return errors.New("session not found")
+	}
+	return nil
+}
+
+func (r *postgresSessionRepository) RevokeUserSessions(ctx context.Context, userID int) error {
+	query := `UPDATE sessions SET revoked_at = $1 WHERE user_id = $2 AND revoked_at IS NULL`
+	if _, err := r.db.Exec(ctx, query, time.Now(), userID); err != nil {
+		return fmt.Errorf("failed to revoke user sessions: %w", err)
+	}
+	return nil
+}
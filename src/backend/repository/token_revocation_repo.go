@@ -0,0 +1,45 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type postgresTokenRevocationRepository struct {
+	db *pgxpool.Pool
+}
+
+// NewPostgresTokenRevocationRepository creates a new instance of TokenRevocationRepository
+func NewPostgresTokenRevocationRepository(db *pgxpool.Pool) TokenRevocationRepository {
+	return &postgresTokenRevocationRepository{db: db}
+}
+
+func (r *postgresTokenRevocationRepository) RevokeToken(ctx context.Context, jti string, userID int, expiresAt time.Time) error {
+	query := `INSERT INTO revoked_tokens (jti, user_id, expires_at, revoked_at)
+	          VALUES ($1, $2, $3, $4) ON CONFLICT (jti) DO NOTHING`
+	if _, err := r.db.Exec(ctx, query, jti, userID, expiresAt, time.Now()); err != nil {
+		return fmt.Errorf("failed to revoke token: %w", err)
+	}
+	return nil
+}
+
+func (r *postgresTokenRevocationRepository) IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1)`
+	var revoked bool
+	if err := r.db.QueryRow(ctx, query, jti).Scan(&revoked); err != nil {
+		return false, fmt.Errorf("failed to check token revocation: %w", err)
+	}
+	return revoked, nil
+}
+
+func (r *postgresTokenRevocationRepository) DeleteExpiredRevocations(ctx context.Context) (int64, error) {
+	query := `DELETE FROM revoked_tokens WHERE expires_at < $1`
+	cmdTag, err := r.db.Exec(ctx, query, time.Now())
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired revocations: %w", err)
+	}
+	return cmdTag.RowsAffected(), nil
+}
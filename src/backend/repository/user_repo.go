@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/jackc/pgx/v5"
@@ -20,11 +22,32 @@ func NewPostgresUserRepository(db *pgxpool.Pool) UserRepository {
 	return &postgresUserRepository{db: db}
 }
 
+// userColumns is the column list read by scanUser, kept in one place so
+// single-user lookups stay in sync as the users table grows.
+//
+// Soft-deleted users (deleted_at set) are invisible to every method except
+// the ones that list, restore or purge them.
+const userColumns = `id, name, email, password_hash, profile_pic, profile_pic_variants, email_verified_at, role, token_version, totp_secret, totp_enabled_at, deletion_scheduled_at, deleted_at, attributes, created_at, updated_at`
+
+func scanUser(row pgx.Row) (*models.User, error) {
+	user := &models.User{}
+	err := row.Scan(
+		&user.ID, &user.Name, &user.Email, &user.Password, &user.ProfilePic, &user.ProfilePicVariants, &user.EmailVerifiedAt, &user.Role, &user.TokenVersion, &user.TOTPSecret, &user.TOTPEnabledAt, &user.DeletionScheduledAt, &user.DeletedAt, &user.Attributes, &user.CreatedAt, &user.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
 func (r *postgresUserRepository) CreateUser(ctx context.Context, user *models.User) (int, error) {
-	query := `INSERT INTO users (name, email, password_hash, profile_pic, created_at, updated_at)
-	          VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`
+	if user.Role == "" {
+		user.Role = models.RoleUser
+	}
+	query := `INSERT INTO users (name, email, password_hash, profile_pic, role, created_at, updated_at)
+	          VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`
 	now := time.Now()
-	err := r.db.QueryRow(ctx, query, user.Name, user.Email, user.Password, user.ProfilePic, now, now).Scan(&user.ID)
+	err := r.db.QueryRow(ctx, query, user.Name, user.Email, user.Password, user.ProfilePic, user.Role, now, now).Scan(&user.ID)
 	if err != nil {
 		// TODO: Check for unique constraint violation on email
 		return 0, fmt.Errorf("failed to create user: %w", err)
@@ -33,12 +56,8 @@ func (r *postgresUserRepository) CreateUser(ctx context.Context, user *models.Us
 }
 
 func (r *postgresUserRepository) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
-	query := `SELECT id, name, email, password_hash, profile_pic, created_at, updated_at
-	          FROM users WHERE email = $1`
-	user := &models.User{}
-	err := r.db.QueryRow(ctx, query, email).Scan(
-		&user.ID, &user.Name, &user.Email, &user.Password, &user.ProfilePic, &user.CreatedAt, &user.UpdatedAt,
-	)
+	query := `SELECT ` + userColumns + ` FROM users WHERE email = $1 AND deleted_at IS NULL`
+	user, err := scanUser(r.db.QueryRow(ctx, query, email))
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, nil // User not found is not necessarily an error here
@@ -49,12 +68,8 @@ func (r *postgresUserRepository) GetUserByEmail(ctx context.Context, email strin
 }
 
 func (r *postgresUserRepository) GetUserByID(ctx context.Context, id int) (*models.User, error) {
-	query := `SELECT id, name, email, password_hash, profile_pic, created_at, updated_at
-	          FROM users WHERE id = $1`
-	user := &models.User{}
-	err := r.db.QueryRow(ctx, query, id).Scan(
-		&user.ID, &user.Name, &user.Email, &user.Password, &user.ProfilePic, &user.CreatedAt, &user.UpdatedAt,
-	)
+	query := `SELECT ` + userColumns + ` FROM users WHERE id = $1 AND deleted_at IS NULL`
+	user, err := scanUser(r.db.QueryRow(ctx, query, id))
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, errors.New("user not found") // More explicit error for GetByID
@@ -64,9 +79,74 @@ func (r *postgresUserRepository) GetUserByID(ctx context.Context, id int) (*mode
 	return user, nil
 }
 
-func (r *postgresUserRepository) GetAllUsers(ctx context.Context) ([]models.User, error) {
-	query := `SELECT id, name, email, profile_pic, created_at, updated_at FROM users ORDER BY name ASC`
-	rows, err := r.db.Query(ctx, query)
+// userSortColumns maps the sort fields in models.UserSortFields to columns,
+// so user input never reaches the ORDER BY clause
+var userSortColumns = map[string]string{
+	"name":       "name",
+	"email":      "email",
+	"created_at": "created_at",
+}
+
+// likeEscaper makes user input match literally inside a LIKE pattern
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func (r *postgresUserRepository) GetUsers(ctx context.Context, q *models.UserListQuery) ([]models.User, error) {
+	column, ok := userSortColumns[q.Sort]
+	if !ok {
+		return nil, fmt.Errorf("invalid sort field %q", q.Sort)
+	}
+	direction, comparison := "ASC", ">"
+	if q.Descending {
+		direction, comparison = "DESC", "<"
+	}
+
+	conditions := []string{"deleted_at IS NULL"}
+	var args []interface{}
+	addArg := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if q.Search != "" {
+		pattern := addArg("%" + likeEscaper.Replace(q.Search) + "%")
+		conditions = append(conditions, fmt.Sprintf("(name ILIKE %s OR email ILIKE %s)", pattern, pattern))
+	}
+	if q.CreatedAfter != nil {
+		conditions = append(conditions, "created_at >= "+addArg(*q.CreatedAfter))
+	}
+	if q.CreatedBefore != nil {
+		conditions = append(conditions, "created_at < "+addArg(*q.CreatedBefore))
+	}
+	if q.Verified != nil {
+		if *q.Verified {
+			conditions = append(conditions, "email_verified_at IS NOT NULL")
+		} else {
+			conditions = append(conditions, "email_verified_at IS NULL")
+		}
+	}
+	// Sorted so the same filters always build the same query
+	keys := make([]string, 0, len(q.Attributes))
+	for key := range q.Attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		conditions = append(conditions, fmt.Sprintf("attributes ->> %s = %s", addArg(key), addArg(q.Attributes[key])))
+	}
+	if q.After != nil {
+		// The ID breaks ties, so users sharing a sort value are never skipped or repeated
+		value := addArg(q.After.Value)
+		if column == "created_at" {
+			value += "::timestamptz"
+		}
+		conditions = append(conditions, fmt.Sprintf("(%s, id) %s (%s, %s)", column, comparison, value, addArg(q.After.ID)))
+	}
+
+	query := `SELECT id, name, email, profile_pic, profile_pic_variants, email_verified_at, role, attributes, created_at, updated_at FROM users WHERE ` +
+		strings.Join(conditions, " AND ")
+	query += fmt.Sprintf(" ORDER BY %s %s, id %s LIMIT %s", column, direction, direction, addArg(q.Limit))
+
+	rows, err := r.db.Query(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query users: %w", err)
 	}
@@ -75,7 +155,7 @@ func (r *postgresUserRepository) GetAllUsers(ctx context.Context) ([]models.User
 	users := []models.User{}
 	for rows.Next() {
 		var user models.User
-		err := rows.Scan(&user.ID, &user.Name, &user.Email, &user.ProfilePic, &user.CreatedAt, &user.UpdatedAt)
+		err := rows.Scan(&user.ID, &user.Name, &user.Email, &user.ProfilePic, &user.ProfilePicVariants, &user.EmailVerifiedAt, &user.Role, &user.Attributes, &user.CreatedAt, &user.UpdatedAt)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan user row: %w", err)
 		}
@@ -101,17 +181,32 @@ func (r *postgresUserRepository) UpdateUser(ctx context.Context, id int, updateD
 		argID++
 	}
 	if updateData.Email != nil {
-		query += fmt.Sprintf(", email = $%d", argID)
+		// A changed address has to be verified again
+		query += fmt.Sprintf(", email_verified_at = CASE WHEN email = $%d THEN email_verified_at END, email = $%d", argID, argID)
 		args = append(args, *updateData.Email)
 		argID++
 	}
+	if updateData.Attributes != nil {
+		// Merged in the statement itself, so concurrent updates to other keys are not lost
+		set, removed := map[string]interface{}{}, []string{}
+		for key, value := range updateData.Attributes {
+			if value == nil {
+				removed = append(removed, key)
+			} else {
+				set[key] = value
+			}
+		}
+		query += fmt.Sprintf(", attributes = (attributes - $%d::text[]) || $%d::jsonb", argID, argID+1)
+		args = append(args, removed, set)
+		argID += 2
+	}
 
 	// Only proceed if there's something to update
 	if len(args) == 1 {
 		return errors.New("no update fields provided")
 	}
 
-	query += fmt.Sprintf(" WHERE id = $%d", argID)
+	query += fmt.Sprintf(" WHERE id = $%d AND deleted_at IS NULL", argID)
 	args = append(args, id)
 
 	cmdTag, err := r.db.Exec(ctx, query, args...)
@@ -126,9 +221,12 @@ func (r *postgresUserRepository) UpdateUser(ctx context.Context, id int, updateD
 	return nil
 }
 
-func (r *postgresUserRepository) UpdateUserProfilePic(ctx context.Context, id int, filename string) error {
-	query := `UPDATE users SET profile_pic = $1, updated_at = $2 WHERE id = $3`
-	cmdTag, err := r.db.Exec(ctx, query, filename, time.Now(), id)
+func (r *postgresUserRepository) UpdateUserProfilePic(ctx context.Context, id int, filename string, variants map[string]string) error {
+	if variants == nil {
+		variants = map[string]string{} // The column is NOT NULL
+	}
+	query := `UPDATE users SET profile_pic = $1, profile_pic_variants = $2, updated_at = $3 WHERE id = $4 AND deleted_at IS NULL`
+	cmdTag, err := r.db.Exec(ctx, query, filename, variants, time.Now(), id)
 	if err != nil {
 		return fmt.Errorf("failed to update profile picture: %w", err)
 	}
@@ -138,10 +236,119 @@ func (r *postgresUserRepository) UpdateUserProfilePic(ctx context.Context, id in
 	return nil
 }
 
+func (r *postgresUserRepository) UpdateUserRole(ctx context.Context, id int, role string) error {
+	query := `UPDATE users SET role = $1, updated_at = $2 WHERE id = $3 AND deleted_at IS NULL`
+	cmdTag, err := r.db.Exec(ctx, query, role, time.Now(), id)
+	if err != nil {
+		return fmt.Errorf("failed to update user role: %w", err)
+	}
+	if cmdTag.RowsAffected() == 0 {
+		return errors.New("user not found")
+	}
+	return nil
+}
+
+// PromoteBootstrapAdmin makes a verified user the first admin. Deleted
+// admins count, so deleting the admin and re-registering the bootstrap
+// email does not mint another one.
+func (r *postgresUserRepository) PromoteBootstrapAdmin(ctx context.Context, id int) (bool, error) {
+	query := `UPDATE users SET role = $1, updated_at = $2
+		WHERE id = $3 AND email_verified_at IS NOT NULL AND deleted_at IS NULL
+		AND NOT EXISTS (SELECT 1 FROM users WHERE role = $1)`
+	cmdTag, err := r.db.Exec(ctx, query, models.RoleAdmin, time.Now(), id)
+	if err != nil {
+		return false, fmt.Errorf("failed to promote bootstrap admin: %w", err)
+	}
+	return cmdTag.RowsAffected() > 0, nil
+}
+
+// UpgradePasswordHash replaces a hash of the same password with a stronger
+// one, unless the password was changed in the meantime
+func (r *postgresUserRepository) UpgradePasswordHash(ctx context.Context, id int, oldHash, newHash string) error {
+	query := `UPDATE users SET password_hash = $1 WHERE id = $2 AND password_hash = $3 AND deleted_at IS NULL`
+	if _, err := r.db.Exec(ctx, query, newHash, id, oldHash); err != nil {
+		return fmt.Errorf("failed to upgrade password hash: %w", err)
+	}
+	return nil
+}
+
+func (r *postgresUserRepository) UpdateUserPassword(ctx context.Context, id int, passwordHash string) error {
+	query := `UPDATE users SET password_hash = $1, updated_at = $2 WHERE id = $3 AND deleted_at IS NULL`
+	cmdTag, err := r.db.Exec(ctx, query, passwordHash, time.Now(), id)
+	if err != nil {
+		return fmt.Errorf("failed to update password: %w", err)
+	}
+	if cmdTag.RowsAffected() == 0 {
+		return errors.New("user not found")
+	}
+	return nil
+}
+
+func (r *postgresUserRepository) MarkEmailVerified(ctx context.Context, id int, email string) error {
+	// Matching on email too means a link sent to an old address stops working once it changes
+	query := `UPDATE users SET email_verified_at = COALESCE(email_verified_at, $1), updated_at = $1 WHERE id = $2 AND email = $3 AND deleted_at IS NULL`
+	cmdTag, err := r.db.Exec(ctx, query, time.Now(), id, email)
+	if err != nil {
+		return fmt.Errorf("failed to mark email as verified: %w", err)
+	}
+	if cmdTag.RowsAffected() == 0 {
+		return errors.New("user not found")
+	}
+	return nil
+}
+
+func (r *postgresUserRepository) SetTOTPSecret(ctx context.Context, id int, secret string) error {
+	// Starting a new enrolment never touches an already enabled secret
+	query := `UPDATE users SET totp_secret = $1, totp_last_step = 0, updated_at = $2 WHERE id = $3 AND totp_enabled_at IS NULL AND deleted_at IS NULL`
+	cmdTag, err := r.db.Exec(ctx, query, secret, time.Now(), id)
+	if err != nil {
+		return fmt.Errorf("failed to set totp secret: %w", err)
+	}
+	if cmdTag.RowsAffected() == 0 {
+		return errors.New("user not found or totp already enabled")
+	}
+	return nil
+}
+
+func (r *postgresUserRepository) UseTOTPStep(ctx context.Context, id int, step int64) (bool, error) {
+	// Compare-and-set, so two requests racing with the same code cannot both win
+	query := `UPDATE users SET totp_last_step = $1 WHERE id = $2 AND totp_last_step < $1 AND deleted_at IS NULL`
+	cmdTag, err := r.db.Exec(ctx, query, step, id)
+	if err != nil {
+		return false, fmt.Errorf("failed to record totp step: %w", err)
+	}
+	return cmdTag.RowsAffected() == 1, nil
+}
+
+func (r *postgresUserRepository) EnableTOTP(ctx context.Context, id int) error {
+	query := `UPDATE users SET totp_enabled_at = $1, updated_at = $1 WHERE id = $2 AND totp_secret <> '' AND deleted_at IS NULL`
+	cmdTag, err := r.db.Exec(ctx, query, time.Now(), id)
+	if err != nil {
+		return fmt.Errorf("failed to enable totp: %w", err)
+	}
+	if cmdTag.RowsAffected() == 0 {
+		return errors.New("user not found")
+	}
+	return nil
+}
+
+func (r *postgresUserRepository) DisableTOTP(ctx context.Context, id int) error {
+	query := `UPDATE users SET totp_secret = '', totp_enabled_at = NULL, updated_at = $1 WHERE id = $2 AND deleted_at IS NULL`
+	cmdTag, err := r.db.Exec(ctx, query, time.Now(), id)
+	if err != nil {
+		return fmt.Errorf("failed to disable totp: %w", err)
+	}
+	if cmdTag.RowsAffected() == 0 {
+		return errors.New("user not found")
+	}
+	return nil
+}
 
+// DeleteUser soft-deletes a user. Bumping the token version in the same
+// statement means a later restore does not bring old tokens back to life.
 func (r *postgresUserRepository) DeleteUser(ctx context.Context, id int) error {
-	query := `DELETE FROM users WHERE id = $1`
-	cmdTag, err := r.db.Exec(ctx, query, id)
+	query := `UPDATE users SET deleted_at = $1, token_version = token_version + 1 WHERE id = $2 AND deleted_at IS NULL`
+	cmdTag, err := r.db.Exec(ctx, query, time.Now(), id)
 	if err != nil {
 		return fmt.Errorf("failed to delete user: %w", err)
 	}
@@ -150,3 +357,166 @@ func (r *postgresUserRepository) DeleteUser(ctx context.Context, id int) error {
 	}
 	return nil
 }
+
+func (r *postgresUserRepository) GetDeletedUsers(ctx context.Context, deletedBefore time.Time) ([]models.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users
+	          WHERE deleted_at IS NOT NULL AND deleted_at < $1 AND anonymized_at IS NULL ORDER BY deleted_at, id`
+	rows, err := r.db.Query(ctx, query, deletedBefore)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query deleted users: %w", err)
+	}
+	defer rows.Close()
+
+	users := []models.User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan deleted user row: %w", err)
+		}
+		users = append(users, *user)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating deleted user rows: %w", err)
+	}
+
+	return users, nil
+}
+
+func (r *postgresUserRepository) RestoreUser(ctx context.Context, id int) error {
+	// Fails with a unique violation if the email was taken again in the meantime
+	query := `UPDATE users SET deleted_at = NULL WHERE id = $1 AND deleted_at IS NOT NULL AND anonymized_at IS NULL`
+	cmdTag, err := r.db.Exec(ctx, query, id)
+	if err != nil {
+		return fmt.Errorf("failed to restore user: %w", err)
+	}
+	if cmdTag.RowsAffected() == 0 {
+		return errors.New("deleted user not found")
+	}
+	return nil
+}
+
+func (r *postgresUserRepository) PurgeUser(ctx context.Context, id int) error {
+	// Only soft-deleted users can be purged; related rows go with ON DELETE CASCADE.
+	// Anonymised accounts are kept for the records that reference them.
+	query := `DELETE FROM users WHERE id = $1 AND deleted_at IS NOT NULL AND anonymized_at IS NULL`
+	cmdTag, err := r.db.Exec(ctx, query, id)
+	if err != nil {
+		return fmt.Errorf("failed to purge user: %w", err)
+	}
+	if cmdTag.RowsAffected() == 0 {
+		return errors.New("deleted user not found")
+	}
+	return nil
+}
+
+func (r *postgresUserRepository) ScheduleUserDeletion(ctx context.Context, id int, at time.Time) error {
+	// A second request does not push back a closure that is already scheduled
+	query := `UPDATE users SET deletion_scheduled_at = COALESCE(deletion_scheduled_at, $1) WHERE id = $2 AND deleted_at IS NULL`
+	cmdTag, err := r.db.Exec(ctx, query, at, id)
+	if err != nil {
+		return fmt.Errorf("failed to schedule user deletion: %w", err)
+	}
+	if cmdTag.RowsAffected() == 0 {
+		return errors.New("user not found")
+	}
+	return nil
+}
+
+func (r *postgresUserRepository) CancelUserDeletion(ctx context.Context, id int) (bool, error) {
+	query := `UPDATE users SET deletion_scheduled_at = NULL WHERE id = $1 AND deletion_scheduled_at IS NOT NULL AND deleted_at IS NULL`
+	cmdTag, err := r.db.Exec(ctx, query, id)
+	if err != nil {
+		return false, fmt.Errorf("failed to cancel user deletion: %w", err)
+	}
+	return cmdTag.RowsAffected() > 0, nil
+}
+
+func (r *postgresUserRepository) GetUsersDueForDeletion(ctx context.Context, now time.Time) ([]models.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users
+	          WHERE deletion_scheduled_at <= $1 AND deleted_at IS NULL ORDER BY deletion_scheduled_at, id`
+	rows, err := r.db.Query(ctx, query, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query users due for deletion: %w", err)
+	}
+	defer rows.Close()
+
+	users := []models.User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan user row: %w", err)
+		}
+		users = append(users, *user)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating user rows: %w", err)
+	}
+
+	return users, nil
+}
+
+// AnonymizeUser scrubs the personal data of a closed account, keeping the
+// row so records that reference it stay intact. The row is also
+// soft-deleted, so it can no longer log in or be found, but it is never
+// purged or restored. Fails if the closure was cancelled in the meantime.
+func (r *postgresUserRepository) AnonymizeUser(ctx context.Context, id int) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx) // No-op after commit
+
+	// The placeholder email keeps the unique index happy and can never receive mail
+	now := time.Now()
+	query := `UPDATE users SET name = 'Deleted user', email = 'deleted-' || id || '@invalid', password_hash = '',
+	              profile_pic = '', profile_pic_variants = '{}', email_verified_at = NULL, totp_secret = '', totp_enabled_at = NULL,
+	              attributes = '{}', token_version = token_version + 1, anonymized_at = $1, deleted_at = $1
+	          WHERE id = $2 AND deletion_scheduled_at <= $1 AND deleted_at IS NULL`
+	cmdTag, err := tx.Exec(ctx, query, now, id)
+	if err != nil {
+		return fmt.Errorf("failed to anonymize user: %w", err)
+	}
+	if cmdTag.RowsAffected() == 0 {
+		return errors.New("user not found or deletion cancelled")
+	}
+
+	// Ways back into the account and data that identifies the person
+	for _, table := range []string{"sessions", "refresh_tokens", "user_tokens", "mfa_recovery_codes", "user_identities", "webauthn_credentials"} {
+		if _, err := tx.Exec(ctx, `DELETE FROM `+table+` WHERE user_id = $1`, id); err != nil {
+			return fmt.Errorf("failed to delete %s of anonymized user: %w", table, err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit user anonymization: %w", err)
+	}
+	return nil
+}
+
+func (r *postgresUserRepository) GetUserTokenVersion(ctx context.Context, id int) (int, error) {
+	query := `SELECT token_version FROM users WHERE id = $1 AND deleted_at IS NULL`
+	var version int
+	err := r.db.QueryRow(ctx, query, id).Scan(&version)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, errors.New("user not found")
+		}
+		return 0, fmt.Errorf("failed to get token version: %w", err)
+	}
+	return version, nil
+}
+
+func (r *postgresUserRepository) IncrementUserTokenVersion(ctx context.Context, id int) (int, error) {
+	query := `UPDATE users SET token_version = token_version + 1, updated_at = $1 WHERE id = $2 AND deleted_at IS NULL RETURNING token_version`
+	var version int
+	err := r.db.QueryRow(ctx, query, time.Now(), id).Scan(&version)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, errors.New("user not found")
+		}
+		return 0, fmt.Errorf("failed to increment token version: %w", err)
+	}
+	return version, nil
+}
@@ -0,0 +1,75 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Eduardo-Barreto/web-ponderada/backend/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type postgresUserTokenRepository struct {
+	db *pgxpool.Pool
+}
+
+// NewPostgresUserTokenRepository creates a new instance of UserTokenRepository
+func NewPostgresUserTokenRepository(db *pgxpool.Pool) UserTokenRepository {
+	return &postgresUserTokenRepository{db: db}
+}
+
+func (r *postgresUserTokenRepository) CreateUserToken(ctx context.Context, token *models.UserToken) (int, error) {
+	query := `INSERT INTO user_tokens (user_id, purpose, token_hash, expires_at, created_at)
+	          VALUES ($1, $2, $3, $4, $5) RETURNING id`
+	now := time.Now()
+	err := r.db.QueryRow(ctx, query, token.UserID, token.Purpose, token.TokenHash, token.ExpiresAt, now).Scan(&token.ID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create user token: %w", err)
+	}
+	token.CreatedAt = now
+	return token.ID, nil
+}
+
+func (r *postgresUserTokenRepository) GetUserToken(ctx context.Context, purpose, tokenHash string) (*models.UserToken, error) {
+	query := `SELECT id, user_id, purpose, token_hash, expires_at, used_at, created_at FROM user_tokens
+	          WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > $3`
+	token := &models.UserToken{}
+	err := r.db.QueryRow(ctx, query, tokenHash, purpose, time.Now()).Scan(
+		&token.ID, &token.UserID, &token.Purpose, &token.TokenHash, &token.ExpiresAt, &token.UsedAt, &token.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, errors.New("token invalid or expired")
+		}
+		return nil, fmt.Errorf("failed to get user token: %w", err)
+	}
+	return token, nil
+}
+
+func (r *postgresUserTokenRepository) ConsumeUserToken(ctx context.Context, purpose, tokenHash string) (*models.UserToken, error) {
+	// Marking and reading in one statement keeps the token single-use under concurrency
+	query := `UPDATE user_tokens SET used_at = $1
+	          WHERE token_hash = $2 AND purpose = $3 AND used_at IS NULL AND expires_at > $1
+	          RETURNING id, user_id, purpose, token_hash, expires_at, used_at, created_at`
+	token := &models.UserToken{}
+	err := r.db.QueryRow(ctx, query, time.Now(), tokenHash, purpose).Scan(
+		&token.ID, &token.UserID, &token.Purpose, &token.TokenHash, &token.ExpiresAt, &token.UsedAt, &token.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, errors.New("token invalid or expired")
+		}
+		return nil, fmt.Errorf("failed to consume user token: %w", err)
+	}
+	return token, nil
+}
+
+func (r *postgresUserTokenRepository) InvalidateUserTokens(ctx context.Context, userID int, purpose string) error {
+	query := `UPDATE user_tokens SET used_at = $1 WHERE user_id = $2 AND purpose = $3 AND used_at IS NULL`
+	if _, err := r.db.Exec(ctx, query, time.Now(), userID, purpose); err != nil {
+		return fmt.Errorf("failed to invalidate user tokens: %w", err)
+	}
+	return nil
+}
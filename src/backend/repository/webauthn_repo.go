@@ -0,0 +1,118 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Eduardo-Barreto/web-ponderada/backend/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type postgresWebAuthnRepository struct {
+	db *pgxpool.Pool
+}
+
+// NewPostgresWebAuthnRepository creates a new instance of WebAuthnRepository
+func NewPostgresWebAuthnRepository(db *pgxpool.Pool) WebAuthnRepository {
+	return &postgresWebAuthnRepository{db: db}
+}
+
+func (r *postgresWebAuthnRepository) CreateCredential(ctx context.Context, credential *models.WebAuthnCredential) (int, error) {
+	query := `INSERT INTO webauthn_credentials (user_id, name, credential_id, public_key, attestation_type, aaguid,
+	              sign_count, transports, backup_eligible, backup_state, created_at)
+	          VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING id`
+	now := time.Now()
+	err := r.db.QueryRow(ctx, query, credential.UserID, credential.Name, credential.CredentialID, credential.PublicKey,
+		credential.AttestationType, credential.AAGUID, int64(credential.SignCount), credential.Transports,
+		credential.BackupEligible, credential.BackupState, now).Scan(&credential.ID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create webauthn credential: %w", err)
+	}
+	credential.CreatedAt = now
+	return credential.ID, nil
+}
+
+func (r *postgresWebAuthnRepository) GetCredentialsByUser(ctx context.Context, userID int) ([]models.WebAuthnCredential, error) {
+	query := `SELECT id, user_id, name, credential_id, public_key, attestation_type, aaguid, sign_count, transports,
+	                 backup_eligible, backup_state, last_used_at, created_at
+	          FROM webauthn_credentials WHERE user_id = $1 ORDER BY created_at`
+	rows, err := r.db.Query(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query webauthn credentials: %w", err)
+	}
+	defer rows.Close()
+
+	credentials := []models.WebAuthnCredential{}
+	for rows.Next() {
+		var credential models.WebAuthnCredential
+		var signCount int64
+		if err := rows.Scan(&credential.ID, &credential.UserID, &credential.Name, &credential.CredentialID,
+			&credential.PublicKey, &credential.AttestationType, &credential.AAGUID, &signCount, &credential.Transports,
+			&credential.BackupEligible, &credential.BackupState, &credential.LastUsedAt, &credential.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan webauthn credential row: %w", err)
+		}
+		credential.SignCount = uint32(signCount)
+		credentials = append(credentials, credential)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating webauthn credential rows: %w", err)
+	}
+
+	return credentials, nil
+}
+
+func (r *postgresWebAuthnRepository) UpdateCredentialUsage(ctx context.Context, id int, signCount uint32, backupState bool, usedAt time.Time) error {
+	query := `UPDATE webauthn_credentials SET sign_count = $1, backup_state = $2, last_used_at = $3 WHERE id = $4`
+	if _, err := r.db.Exec(ctx, query, int64(signCount), backupState, usedAt, id); err != nil {
+		return fmt.Errorf("failed to update webauthn credential usage: %w", err)
+	}
+	return nil
+}
+
+func (r *postgresWebAuthnRepository) DeleteCredential(ctx context.Context, userID, id int) error {
+	query := `DELETE FROM webauthn_credentials WHERE id = $1 AND user_id = $2`
+	cmdTag, err := r.db.Exec(ctx, query, id, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete webauthn credential: %w", err)
+	}
+	if cmdTag.RowsAffected() == 0 {
+		return errors.New("credential not found")
+	}
+	return nil
+}
+
+func (r *postgresWebAuthnRepository) CreateCeremony(ctx context.Context, ceremony *models.WebAuthnCeremony) error {
+	// Abandoned ceremonies are never consumed, so clear them out as new ones start
+	if _, err := r.db.Exec(ctx, `DELETE FROM webauthn_ceremonies WHERE expires_at <= $1`, time.Now()); err != nil {
+		return fmt.Errorf("failed to delete expired webauthn ceremonies: %w", err)
+	}
+
+	query := `INSERT INTO webauthn_ceremonies (token_hash, user_id, kind, session_data, expires_at)
+	          VALUES ($1, $2, $3, $4, $5)`
+	if _, err := r.db.Exec(ctx, query, ceremony.TokenHash, ceremony.UserID, ceremony.Kind, ceremony.SessionData, ceremony.ExpiresAt); err != nil {
+		return fmt.Errorf("failed to create webauthn ceremony: %w", err)
+	}
+	return nil
+}
+
+func (r *postgresWebAuthnRepository) ConsumeCeremony(ctx context.Context, kind, tokenHash string) (*models.WebAuthnCeremony, error) {
+	// Deleting and reading in one statement keeps each challenge single-use under concurrency
+	query := `DELETE FROM webauthn_ceremonies
+	          WHERE token_hash = $1 AND kind = $2 AND expires_at > $3
+	          RETURNING token_hash, user_id, kind, session_data, expires_at`
+	ceremony := &models.WebAuthnCeremony{}
+	err := r.db.QueryRow(ctx, query, tokenHash, kind, time.Now()).Scan(
+		&ceremony.TokenHash, &ceremony.UserID, &ceremony.Kind, &ceremony.SessionData, &ceremony.ExpiresAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, errors.New("ceremony invalid or expired")
+		}
+		return nil, fmt.Errorf("failed to consume webauthn ceremony: %w", err)
+	}
+	return ceremony, nil
+}
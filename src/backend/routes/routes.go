@@ -1,26 +1,67 @@
 package routes
 
 import (
+	"log"
 	"net/http" // Added missing import
 	"github.com/gin-contrib/cors" // Import CORS middleware
 	"github.com/gin-gonic/gin"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/attributes"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/auth"
+	appconfig "github.com/Eduardo-Barreto/web-ponderada/backend/config"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/exports"
 	"github.com/Eduardo-Barreto/web-ponderada/backend/handlers"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/mailer"
 	"github.com/Eduardo-Barreto/web-ponderada/backend/middleware"
+	"github.com/Eduardo-Barreto/web-ponderada/backend/models"
 	"github.com/Eduardo-Barreto/web-ponderada/backend/repository"
 )
 
+// Dependencies groups everything the router needs to build its handlers
+type Dependencies struct {
+	UserRepo            repository.UserRepository
+	ProductRepo         repository.ProductRepository
+	FileRepo            repository.StorageRepository
+	RefreshTokenRepo    repository.RefreshTokenRepository
+	SessionRepo         repository.SessionRepository
+	UserTokenRepo       repository.UserTokenRepository
+	LoginAttemptRepo    repository.LoginAttemptRepository
+	RecoveryCodeRepo    repository.RecoveryCodeRepository
+	APIKeyRepo          repository.APIKeyRepository
+	IdentityRepo        repository.IdentityRepository
+	WebAuthnRepo        repository.WebAuthnRepository
+	AuditRepo           repository.ImpersonationAuditRepository
+	AttributeSchemaRepo repository.AttributeSchemaRepository
+	RevocationStore     *auth.RevocationStore
+	DataExports         *exports.Service
+	Mailer              mailer.Mailer
+}
+
 // SetupRouter configures the Gin router with all routes
-func SetupRouter(
-	userRepo repository.UserRepository,
-	productRepo repository.ProductRepository,
-	fileRepo repository.StorageRepository,
-) *gin.Engine {
+func SetupRouter(deps Dependencies) *gin.Engine {
+
+	// Initialize Services
+	tokenIssuer := auth.NewTokenIssuer(deps.UserRepo, deps.RefreshTokenRepo, deps.SessionRepo, deps.RevocationStore)
+	loginThrottler := auth.NewLoginThrottler(deps.LoginAttemptRepo)
+	attributeService := attributes.NewService(deps.AttributeSchemaRepo)
+	passkeys, err := auth.NewPasskeyService(deps.UserRepo, deps.WebAuthnRepo)
+	if err != nil {
+		log.Fatalf("Invalid passkey settings: %v", err)
+	}
 
 	// Initialize Handlers
-	authHandler := handlers.NewAuthHandler(userRepo)
-	userHandler := handlers.NewUserHandler(userRepo, fileRepo) // Pass fileRepo
-	productHandler := handlers.NewProductHandler(productRepo, fileRepo)
-	imageHandler := handlers.NewImageHandler(fileRepo)
+	authHandler := handlers.NewAuthHandler(deps.UserRepo, tokenIssuer, deps.RevocationStore, deps.Mailer, loginThrottler, passkeys)
+	oidcHandler := handlers.NewOIDCHandler(deps.UserRepo, deps.IdentityRepo, tokenIssuer, auth.NewOIDCClient(appconfig.AppConfig.OIDCProviders))
+	mfaHandler := handlers.NewMFAHandler(deps.UserRepo, deps.RecoveryCodeRepo, tokenIssuer, deps.RevocationStore, loginThrottler)
+	verificationHandler := handlers.NewVerificationHandler(deps.UserRepo, deps.Mailer, loginThrottler)
+	magicLinkHandler := handlers.NewMagicLinkHandler(deps.UserRepo, deps.UserTokenRepo, tokenIssuer, deps.Mailer, loginThrottler)
+	passwordHandler := handlers.NewPasswordHandler(deps.UserRepo, deps.UserTokenRepo, tokenIssuer, deps.Mailer, loginThrottler)
+	userHandler := handlers.NewUserHandler(deps.UserRepo, deps.FileRepo, deps.RevocationStore, tokenIssuer, deps.Mailer, attributeService) // Pass fileRepo
+	productHandler := handlers.NewProductHandler(deps.ProductRepo, deps.FileRepo)
+	imageHandler := handlers.NewImageHandler(deps.FileRepo)
+	sessionHandler := handlers.NewSessionHandler(deps.SessionRepo, tokenIssuer)
+	adminHandler := handlers.NewAdminHandler(deps.UserRepo, loginThrottler, deps.AuditRepo)
+	apiKeyHandler := handlers.NewAPIKeyHandler(deps.APIKeyRepo)
+	exportHandler := handlers.NewExportHandler(deps.DataExports)
 
 	// Gin Router
 	// router := gin.Default() // Includes logger and recovery middleware
@@ -28,14 +69,24 @@ func SetupRouter(
 	router.Use(gin.Logger())
 	router.Use(gin.Recovery())
 
+	// Only trust X-Forwarded-For from known proxies, otherwise clients could
+	// spoof their IP and dodge per-IP login throttling
+	if err := router.SetTrustedProxies(appconfig.AppConfig.TrustedProxies); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
+
 	// CORS Middleware Configuration
-	// WARNING: For development, allow all origins. Restrict in production!
+	// WARNING: Without CORS_ALLOWED_ORIGINS any origin is allowed. Restrict in production!
 	config := cors.DefaultConfig()
-	config.AllowOrigins = []string{"*"} // Replace with your frontend URL in production
-	// config.AllowOrigins = []string{"http://localhost:3000", "https://your-frontend-domain.com"}
+	config.AllowOrigins = []string{"*"}
+	if origins := appconfig.AppConfig.CORSAllowedOrigins; len(origins) > 0 {
+		// Cookie sessions need credentialed requests, which only listed origins may make
+		config.AllowOrigins = origins
+		config.AllowCredentials = true
+	}
 	config.AllowMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
-	config.AllowHeaders = []string{"Origin", "Content-Length", "Content-Type", "Authorization"}
-	// config.AllowCredentials = true // If you need cookies or sessions
+	config.AllowHeaders = []string{"Origin", "Content-Length", "Content-Type", "Authorization", auth.AuthModeHeader, auth.CSRFHeader}
+	config.ExposeHeaders = []string{"Link"} // Next page of paginated lists
 	router.Use(cors.New(config))
 
 	// Public Routes (Authentication)
@@ -43,8 +94,40 @@ func SetupRouter(
 	{
 		authRoutes.POST("/register", authHandler.Register)
 		authRoutes.POST("/login", authHandler.Login)
+		authRoutes.POST("/refresh", authHandler.Refresh)
+		authRoutes.GET("/verify", verificationHandler.VerifyEmail)
+		authRoutes.POST("/verify/resend", verificationHandler.ResendVerification)
+		authRoutes.POST("/password/forgot", passwordHandler.ForgotPassword)
+		authRoutes.POST("/password/reset", passwordHandler.ResetPassword)
+		authRoutes.POST("/magic-link", magicLinkHandler.RequestMagicLink)
+		authRoutes.POST("/magic-link/login", magicLinkHandler.LoginWithMagicLink)
+		authRoutes.POST("/passkeys/login/begin", authHandler.BeginPasskeyLogin)
+		authRoutes.POST("/passkeys/login/finish", authHandler.FinishPasskeyLogin)
+
+		// Require a valid token to know what to revoke
+		authRoutes.POST("/logout", middleware.AuthMiddleware(), middleware.RequireUserToken(), authHandler.Logout)
+		authRoutes.POST("/logout-all", middleware.AuthMiddleware(), middleware.RequireUserToken(), middleware.DenyImpersonation(), authHandler.LogoutAll)
+
+		// Two-factor authentication: second login step is public, management needs a token
+		authRoutes.POST("/mfa/verify", mfaHandler.VerifyMFA)
+		mfaRoutes := authRoutes.Group("/mfa")
+		mfaRoutes.Use(middleware.AuthMiddleware(), middleware.RequireUserToken(), middleware.DenyImpersonation())
+		{
+			mfaRoutes.POST("/totp/enroll", mfaHandler.EnrollTOTP)
+			mfaRoutes.POST("/totp/confirm", mfaHandler.ConfirmTOTP)
+			mfaRoutes.POST("/totp/disable", mfaHandler.DisableTOTP)
+			mfaRoutes.POST("/recovery-codes", mfaHandler.RegenerateRecoveryCodes)
+		}
+
+		// Single sign-on through OpenID Connect providers (browser redirects)
+		authRoutes.GET("/oidc", oidcHandler.GetProviders)
+		authRoutes.GET("/oidc/:provider", oidcHandler.Login)
+		authRoutes.GET("/oidc/:provider/callback", oidcHandler.Callback)
 	}
 
+	// Public keys for services that verify our tokens independently
+	router.GET("/.well-known/jwks.json", authHandler.JWKS)
+
 	// Public route to serve images
     // Note: Captures everything after /images/ as 'filepath' parameter
 	router.GET("/api/v1/images/*filepath", imageHandler.ServeImage)
@@ -52,15 +135,36 @@ func SetupRouter(
 	// API v1 Group
 	apiV1 := router.Group("/api/v1")
 
+	// Data export downloads; the token in the emailed link is the credential
+	apiV1.GET("/exports/download", exportHandler.DownloadExport) // GET /api/v1/exports/download?token=
+
 	// --- User Routes (Protected) ---
 	userRoutes := apiV1.Group("/users")
 	userRoutes.Use(middleware.AuthMiddleware()) // Apply auth middleware to this group
 	{
-		userRoutes.GET("", userHandler.GetUsers)              // GET /api/v1/users
-		userRoutes.GET("/:id", userHandler.GetUser)           // GET /api/v1/users/:id
-		userRoutes.PUT("/:id", userHandler.UpdateUser)        // PUT /api/v1/users/:id (for name/email)
-		userRoutes.POST("/:id/profile-pic", userHandler.UploadProfilePic) // POST /api/v1/users/:id/profile-pic
-		userRoutes.DELETE("/:id", userHandler.DeleteUser)     // DELETE /api/v1/users/:id
+		userRoutes.PUT("/me/password", middleware.RequireUserToken(), middleware.DenyImpersonation(), passwordHandler.ChangePassword) // PUT /api/v1/users/me/password
+		userRoutes.GET("/me/sessions", middleware.RequireUserToken(), sessionHandler.GetSessions)        // GET /api/v1/users/me/sessions
+		userRoutes.DELETE("/me/sessions/:id", middleware.RequireUserToken(), sessionHandler.DeleteSession) // DELETE /api/v1/users/me/sessions/:id
+		userRoutes.GET("/me/passkeys", middleware.RequireUserToken(), authHandler.GetPasskeys) // GET /api/v1/users/me/passkeys
+		userRoutes.POST("/me/passkeys/register/begin", middleware.RequireUserToken(), middleware.DenyImpersonation(), authHandler.BeginPasskeyRegistration)
+		userRoutes.POST("/me/passkeys/register/finish", middleware.RequireUserToken(), middleware.DenyImpersonation(), authHandler.FinishPasskeyRegistration)
+		userRoutes.DELETE("/me/passkeys/:id", middleware.RequireUserToken(), middleware.DenyImpersonation(), authHandler.DeletePasskey) // DELETE /api/v1/users/me/passkeys/:id
+		userRoutes.GET("/me", middleware.RequireUserToken(), userHandler.GetMe)                 // GET /api/v1/users/me
+		userRoutes.PATCH("/me", middleware.RequireUserToken(), middleware.DenyImpersonation(), userHandler.UpdateMe) // PATCH /api/v1/users/me (name/email; the email is a login credential)
+		userRoutes.POST("/me/profile-pic", middleware.RequireUserToken(), userHandler.UploadMyProfilePic) // POST /api/v1/users/me/profile-pic
+		userRoutes.DELETE("/me", middleware.RequireUserToken(), middleware.DenyImpersonation(), userHandler.DeleteMe) // DELETE /api/v1/users/me (closes it after a grace period)
+		userRoutes.POST("/me/export", middleware.RequireUserToken(), middleware.DenyImpersonation(), exportHandler.RequestExport) // POST /api/v1/users/me/export
+		userRoutes.GET("/me/export/:id", middleware.RequireUserToken(), exportHandler.GetExport) // GET /api/v1/users/me/export/:id
+		userRoutes.GET("", middleware.RequirePermission(auth.PermUsersRead), userHandler.GetUsers)    // GET /api/v1/users?limit=&cursor=&q=&sort=&attr.<key>=
+		userRoutes.GET("/attribute-schema", userHandler.GetAttributeSchema) // GET /api/v1/users/attribute-schema
+		userRoutes.PUT("/attribute-schema", middleware.RequireRole(models.RoleAdmin), userHandler.SetAttributeSchema) // PUT /api/v1/users/attribute-schema
+
+		// ID-based routes act on any account, so they are reserved for admins
+		userRoutes.GET("/:id", middleware.RequirePermission(auth.PermUsersManage), userHandler.GetUser) // GET /api/v1/users/:id
+		userRoutes.PUT("/:id", middleware.RequirePermission(auth.PermUsersManage), userHandler.UpdateUser) // PUT /api/v1/users/:id (for name/email)
+		userRoutes.POST("/:id/profile-pic", middleware.RequirePermission(auth.PermUsersManage), userHandler.UploadProfilePic) // POST /api/v1/users/:id/profile-pic
+		userRoutes.DELETE("/:id", middleware.RequirePermission(auth.PermUsersManage), middleware.DenyImpersonation(), userHandler.DeleteUser) // DELETE /api/v1/users/:id
+		userRoutes.PUT("/:id/role", middleware.RequireRole(models.RoleAdmin), userHandler.UpdateUserRole) // PUT /api/v1/users/:id/role
 	}
 
 	// --- Product Routes ---
@@ -70,9 +174,9 @@ func SetupRouter(
 		productRoutes.GET("", productHandler.GetProducts)      // GET /api/v1/products
 		productRoutes.GET("/:id", productHandler.GetProduct)   // GET /api/v1/products/:id
 
-		// Protected actions (Create, Update, Delete)
+		// Protected actions (Create, Update, Delete) - admins and editors only
 		protectedProductRoutes := productRoutes.Group("")
-		protectedProductRoutes.Use(middleware.AuthMiddleware())
+		protectedProductRoutes.Use(middleware.AuthMiddleware(), middleware.RequirePermission(auth.PermProductsWrite))
 		{
 			protectedProductRoutes.POST("", productHandler.CreateProduct) // POST /api/v1/products
 			protectedProductRoutes.PUT("/:id", productHandler.UpdateProduct) // PUT /api/v1/products/:id (Note: PUT/POST for multipart forms)
@@ -80,6 +184,20 @@ func SetupRouter(
 		}
 	}
 
+	// --- Admin Routes ---
+	adminRoutes := apiV1.Group("/admin")
+	adminRoutes.Use(middleware.AuthMiddleware(), middleware.RequireRole(models.RoleAdmin))
+	{
+		adminRoutes.POST("/users/:id/unlock", adminHandler.UnlockUser) // POST /api/v1/admin/users/:id/unlock
+		adminRoutes.GET("/users/deleted", adminHandler.GetDeletedUsers)    // GET /api/v1/admin/users/deleted
+		adminRoutes.POST("/users/:id/restore", adminHandler.RestoreUser)  // POST /api/v1/admin/users/:id/restore
+		adminRoutes.GET("/api-keys", apiKeyHandler.GetAPIKeys)         // GET /api/v1/admin/api-keys
+		adminRoutes.POST("/api-keys", apiKeyHandler.CreateAPIKey)      // POST /api/v1/admin/api-keys
+		adminRoutes.DELETE("/api-keys/:id", apiKeyHandler.RevokeAPIKey) // DELETE /api/v1/admin/api-keys/:id (revoke)
+		adminRoutes.POST("/impersonate/:userId", adminHandler.Impersonate) // POST /api/v1/admin/impersonate/:userId
+		adminRoutes.GET("/impersonation-log", adminHandler.GetImpersonationLog) // GET /api/v1/admin/impersonation-log
+	}
+
     // Health Check Route
     router.GET("/health", func(c *gin.Context) {
         // TODO: Add DB ping check here for more thorough health check
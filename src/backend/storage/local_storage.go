@@ -82,6 +82,34 @@ func (s *localStorage) SaveFile(ctx context.Context, fileHeader *multipart.FileH
 	return relativePath, nil
 }
 
+// SaveContent writes content to the given path, relative to the upload directory
+func (s *localStorage) SaveContent(ctx context.Context, filename string, content io.Reader) error {
+	fullPath := s.GetFilePath(filename)
+	if fullPath == "" {
+		return fmt.Errorf("invalid filename provided: %s", filename)
+	}
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		log.Printf("Error creating directory for %s: %v", fullPath, err)
+		return fmt.Errorf("could not create storage directory")
+	}
+
+	dst, err := os.OpenFile(fullPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Printf("Error creating destination file %s: %v", fullPath, err)
+		return fmt.Errorf("failed to save file")
+	}
+	defer dst.Close()
+
+	if _, err := io.Copy(dst, content); err != nil {
+		log.Printf("Error writing file content to %s: %v", fullPath, err)
+		os.Remove(fullPath)
+		return fmt.Errorf("failed to write file content")
+	}
+
+	log.Printf("Successfully saved file: %s", fullPath)
+	return nil
+}
+
 // GetFilePath returns the absolute path on the server for a given filename
 func (s *localStorage) GetFilePath(filename string) string {
     // Basic check to prevent path traversal
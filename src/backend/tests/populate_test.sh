@@ -9,6 +9,11 @@ NC='\033[0m' # No Color
 # URL base
 BASE_URL="http://localhost:8000"
 
+# Precisa ser igual a BOOTSTRAP_ADMIN_EMAIL do backend, que vem sem valor padrão
+ADMIN_EMAIL="${ADMIN_EMAIL:-admin@example.com}"
+# Container do backend, com MAILER_DRIVER=file, de onde o link de verificação é lido
+BACKEND_CONTAINER="${BACKEND_CONTAINER:-backend_app}"
+
 # Create a dummy file for image uploads
 DUMMY_IMAGE="dummy_image.png"
 echo "This is a dummy image file for testing." > $DUMMY_IMAGE
@@ -99,11 +104,20 @@ test_json_route "GET" "/health" "" "" "Health Check"
 
 echo -e "\n${YELLOW}===== Criando Usuários =====${NC}" >&2
 # Criando primeiro usuário (admin)
-admin_response=$(test_json_route "POST" "/api/v1/auth/register" '{"name":"Admin User","email":"admin@example.com","password":"password123"}' "" "Register Admin User")
+admin_response=$(test_json_route "POST" "/api/v1/auth/register" "{\"name\":\"Admin User\",\"email\":\"$ADMIN_EMAIL\",\"password\":\"password123\"}" "" "Register Admin User")
 admin_id=$(echo "$admin_response" | jq -r '.user.id // empty')
 
+# O papel de admin só é concedido depois que o email é confirmado
+sleep 1 # O email é enviado em segundo plano
+verification_token=$(docker exec "$BACKEND_CONTAINER" sh -c "grep -l '^To: $ADMIN_EMAIL' /app/outbox/*.eml | sort | tail -n1 | xargs grep -o 'token=[^[:space:]]*'" | tail -n1 | cut -d= -f2)
+if [ -z "$verification_token" ]; then
+  print_message "$RED" "❌" "Verification email for $ADMIN_EMAIL not found in the outbox"
+  exit 1
+fi
+test_json_route "GET" "/api/v1/auth/verify?token=$verification_token" "" "" "Verify Admin Email" > /dev/null
+
 # Login como admin
-login_response=$(test_json_route "POST" "/api/v1/auth/login" '{"email":"admin@example.com","password":"password123"}' "" "Login Admin User")
+login_response=$(test_json_route "POST" "/api/v1/auth/login" "{\"email\":\"$ADMIN_EMAIL\",\"password\":\"password123\"}" "" "Login Admin User")
 login_status=$?
 admin_token=""
 
@@ -119,12 +133,23 @@ else
 fi
 
 
+echo -e "\n${YELLOW}===== Criando API Key (Service Account) =====${NC}" >&2
+# Produtos são criados com uma API key em vez do token do admin
+api_key_response=$(test_json_route "POST" "/api/v1/admin/api-keys" '{"name":"populate_test","scopes":["products:read","products:write"]}' "$admin_token" "Create API Key")
+api_key=$(echo "$api_key_response" | jq -r '.key // empty')
+api_key_id=$(echo "$api_key_response" | jq -r '.api_key.id // empty')
+
+if [ -z "$api_key" ]; then
+  print_message "$RED" "❌" "Failed to get API key"
+  exit 1
+fi
+
 echo -e "\n${YELLOW}===== Criando Produtos (Multipart Form) =====${NC}" >&2
 # --- Create Product 1 ---
 print_message "$YELLOW" "Testing:" "Create Product 1"
 prod1_full_response=$(curl -s -w '\n%{http_code}' \
   -X POST \
-  -H "Authorization: Bearer $admin_token" \
+  -H "Authorization: Bearer $api_key" \
   -F "description=Latest smartphone with amazing features" \
   -F "value=999.99" \
   -F "quantity=50" \
@@ -139,7 +164,7 @@ product1_id=$(echo "$prod1_body" | jq -r '.id // empty') # Attempt extract even
 print_message "$YELLOW" "Testing:" "Create Product 2"
 prod2_full_response=$(curl -s -w '\n%{http_code}' \
   -X POST \
-  -H "Authorization: Bearer $admin_token" \
+  -H "Authorization: Bearer $api_key" \
   -F "description=High-performance laptop for professionals" \
   -F "value=1499.99" \
   -F "quantity=25" \
@@ -154,7 +179,7 @@ product2_id=$(echo "$prod2_body" | jq -r '.id // empty')
 print_message "$YELLOW" "Testing:" "Create Product 3"
 prod3_full_response=$(curl -s -w '\n%{http_code}' \
   -X POST \
-  -H "Authorization: Bearer $admin_token" \
+  -H "Authorization: Bearer $api_key" \
   -F "description=Premium sound quality with noise cancellation" \
   -F "value=199.99" \
   -F "quantity=100" \
@@ -185,7 +210,7 @@ if [ ! -z "$product1_id" ]; then
   print_message "$YELLOW" "Testing:" "Update Product 1"
   update_full_response=$(curl -s -w '\n%{http_code}' \
     -X PUT \
-    -H "Authorization: Bearer $admin_token" \
+    -H "Authorization: Bearer $api_key" \
     -F "description=Latest smartphone with amazing features (Updated)" \
     -F "value=899.99" \
     -F "quantity=45" \
@@ -204,6 +229,12 @@ echo -e "\n${YELLOW}===== Listando Usuários (Requer autenticação) =====${NC}"
 test_json_route "GET" "/api/v1/users" "" "$admin_token" "List All Users"
 
 
+echo -e "\n${YELLOW}===== Revogando API Key =====${NC}" >&2
+if [ ! -z "$api_key_id" ]; then
+  test_json_route "DELETE" "/api/v1/admin/api-keys/$api_key_id" "" "$admin_token" "Revoke API Key"
+fi
+
+
 echo -e "\n${YELLOW}===== Testes Concluídos =====${NC}" >&2
 
 # Note: Dummy file is removed by trap EXIT
@@ -1,6 +1,11 @@
 package utils
 
 import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
 	"github.com/gin-gonic/gin"
 )
 
@@ -9,6 +14,21 @@ func SendError(c *gin.Context, statusCode int, message string) {
 	c.JSON(statusCode, gin.H{"error": message})
 }
 
+// SendValidationError sends a 400 response with the problems found in each input field
+func SendValidationError(c *gin.Context, message string, fields map[string]interface{}) {
+	c.JSON(http.StatusBadRequest, gin.H{"error": message, "fields": fields})
+}
+
+// SendRateLimited sends a 429 response with a Retry-After header (in whole seconds)
+func SendRateLimited(c *gin.Context, retryAfter time.Duration, message string) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": message, "retry_after": seconds})
+}
+
 // SendSuccess sends a JSON success response (can be customized)
 func SendSuccess(c *gin.Context, statusCode int, data interface{}) {
 	if data != nil {
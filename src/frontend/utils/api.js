@@ -1,5 +1,10 @@
 const API_BASE_URL = 'http://localhost:8000/api/v1';
 
+// Pede ao backend que guarde os tokens em cookies HttpOnly, fora do alcance de
+// scripts (requer SESSION_COOKIES=true e esta origem em CORS_ALLOWED_ORIGINS).
+// Se o backend não suportar, os tokens voltam no corpo e são guardados como antes.
+const USE_COOKIE_SESSION = false;
+
 // Utility function to show loading state
 function showLoading(element) {
     element.innerHTML = '<div class="loading">Carregando...</div>';
@@ -26,39 +31,167 @@ export function showMessage(container, message, type = 'error') {
     }
 }
 
-// Utility function to handle API requests
-async function fetchAPI(endpoint, options = {}) {
+// Stores the token pair returned by login/refresh
+export function saveTokens(data) {
+    // Sessão em cookies: só o token CSRF fica acessível ao JavaScript
+    if (data.token_type === 'Cookie') {
+        localStorage.removeItem('token');
+        localStorage.removeItem('refreshToken');
+        localStorage.setItem('csrfToken', data.csrf_token);
+        return;
+    }
+
+    localStorage.setItem('token', data.token);
+    if (data.refresh_token) {
+        localStorage.setItem('refreshToken', data.refresh_token);
+    }
+}
+
+// Clears any stored tokens
+export function clearTokens() {
+    localStorage.removeItem('token');
+    localStorage.removeItem('refreshToken');
+    localStorage.removeItem('csrfToken');
+}
+
+// Whether the user is logged in, with a bearer token or a cookie session
+export function isLoggedIn() {
+    return Boolean(localStorage.getItem('token') || localStorage.getItem('csrfToken'));
+}
+
+// Headers and fetch options that authenticate a request
+function authOptions() {
     const token = localStorage.getItem('token');
-    const headers = {
-        'Content-Type': 'application/json',
-        ...options.headers,
-    };
+    const csrfToken = localStorage.getItem('csrfToken');
+    const headers = {};
 
     if (token) {
         headers['Authorization'] = `Bearer ${token}`;
+    } else if (csrfToken) {
+        headers['X-CSRF-Token'] = csrfToken;
     }
+    if (USE_COOKIE_SESSION) {
+        headers['X-Auth-Mode'] = 'cookie';
+    }
+
+    // Cookies só são enviados para outra origem com credentials: 'include'
+    return USE_COOKIE_SESSION || csrfToken ? { headers, credentials: 'include' } : { headers };
+}
+
+// Exchanges the stored refresh token (or refresh cookie) for a new token pair
+async function refreshTokens() {
+    const refreshToken = localStorage.getItem('refreshToken');
+    if (!refreshToken && !localStorage.getItem('csrfToken')) {
+        return false;
+    }
+
+    const { headers, ...options } = authOptions();
+    delete headers['Authorization'];
+    const response = await fetch(`${API_BASE_URL}/auth/refresh`, {
+        ...options,
+        method: 'POST',
+        headers: {
+            'Content-Type': 'application/json',
+            ...headers,
+        },
+        body: JSON.stringify(refreshToken ? { refresh_token: refreshToken } : {}),
+    });
+
+    if (!response.ok) {
+        clearTokens();
+        return false;
+    }
+
+    saveTokens(await response.json());
+    return true;
+}
+
+// Utility function to handle API requests
+async function fetchAPI(endpoint, options = {}, retry = true) {
+    const auth = authOptions();
+    const headers = {
+        'Content-Type': 'application/json',
+        ...auth.headers,
+        ...options.headers,
+    };
 
     const response = await fetch(`${API_BASE_URL}${endpoint}`, {
+        ...auth,
         ...options,
         headers,
     });
 
+    // Access token expired: try once with a refreshed one
+    if (response.status === 401 && retry && await refreshTokens()) {
+        return fetchAPI(endpoint, options, false);
+    }
+
     if (!response.ok) {
         const error = await response.json();
+        // Erros de validação (ex.: política de senha) listam os problemas de cada campo
+        if (error.fields) {
+            const details = Object.entries(error.fields).flatMap(([field, violations]) => violations.map(
+                (violation) => (typeof violation === 'string' ? `${field}: ${violation}` : violation.message),
+            ));
+            throw new Error(details.join('. '));
+        }
         throw new Error(error.message || 'Erro ao processar requisição');
     }
 
     return response.json();
 }
 
+// WebAuthn trabalha com ArrayBuffers; a API os troca em base64url
+function base64URLToBuffer(value) {
+    const base64 = value.replace(/-/g, '+').replace(/_/g, '/');
+    const binary = atob(base64.padEnd(base64.length + (4 - base64.length % 4) % 4, '='));
+    return Uint8Array.from(binary, (c) => c.charCodeAt(0)).buffer;
+}
+
+function bufferToBase64URL(buffer) {
+    const binary = String.fromCharCode(...new Uint8Array(buffer));
+    return btoa(binary).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '');
+}
+
+// Converte a resposta do navegador (create ou get) para o JSON esperado pelo backend
+function passkeyCredentialToJSON(credential) {
+    const response = {
+        clientDataJSON: bufferToBase64URL(credential.response.clientDataJSON),
+    };
+    if (credential.response.attestationObject) {
+        response.attestationObject = bufferToBase64URL(credential.response.attestationObject);
+        response.transports = credential.response.getTransports ? credential.response.getTransports() : [];
+    } else {
+        response.authenticatorData = bufferToBase64URL(credential.response.authenticatorData);
+        response.signature = bufferToBase64URL(credential.response.signature);
+        if (credential.response.userHandle) {
+            response.userHandle = bufferToBase64URL(credential.response.userHandle);
+        }
+    }
+    return {
+        id: credential.id,
+        rawId: bufferToBase64URL(credential.rawId),
+        type: credential.type,
+        response,
+    };
+}
+
+// Indica se o navegador suporta passkeys
+export function passkeysSupported() {
+    return typeof window.PublicKeyCredential !== 'undefined';
+}
+
 // Auth API functions
 export const authAPI = {
     async login(email, password) {
         try {
+            const { headers, ...options } = authOptions();
             const response = await fetch(`${API_BASE_URL}/auth/login`, {
+                ...options,
                 method: 'POST',
                 headers: {
                     'Content-Type': 'application/json',
+                    ...headers,
                 },
                 body: JSON.stringify({ email, password }),
             });
@@ -70,7 +203,8 @@ export const authAPI = {
 
             const data = await response.json();
 
-            if (!data.token) {
+            // Contas com 2FA recebem um mfa_token para o segundo passo
+            if (!data.token && !data.mfa_required && data.token_type !== 'Cookie') {
                 throw new Error('Token não encontrado na resposta');
             }
 
@@ -81,11 +215,101 @@ export const authAPI = {
         }
     },
 
+    async verifyMFA(mfaToken, code) {
+        // Códigos de recuperação têm o formato xxxxx-xxxxx
+        const body = code.includes('-')
+            ? { mfa_token: mfaToken, recovery_code: code }
+            : { mfa_token: mfaToken, code };
+        return fetchAPI('/auth/mfa/verify', {
+            method: 'POST',
+            body: JSON.stringify(body),
+        }, false);
+    },
+
+    async getOIDCProviders() {
+        return fetchAPI('/auth/oidc', { method: 'GET' }, false);
+    },
+
+    // Login via provedor OIDC é um redirecionamento do navegador, não um fetch
+    oidcLoginURL(provider) {
+        return `${API_BASE_URL}/auth/oidc/${encodeURIComponent(provider)}`;
+    },
+
     async register(name, email, password) {
         return fetchAPI('/auth/register', {
             method: 'POST',
             body: JSON.stringify({ name, email, password }),
         });
+    },
+
+    async verifyEmail(token) {
+        return fetchAPI(`/auth/verify?token=${encodeURIComponent(token)}`, {
+            method: 'GET',
+        }, false);
+    },
+
+    async resendVerification(email) {
+        return fetchAPI('/auth/verify/resend', {
+            method: 'POST',
+            body: JSON.stringify({ email }),
+        }, false);
+    },
+
+    async forgotPassword(email) {
+        return fetchAPI('/auth/password/forgot', {
+            method: 'POST',
+            body: JSON.stringify({ email }),
+        }, false);
+    },
+
+    async resetPassword(token, password) {
+        return fetchAPI('/auth/password/reset', {
+            method: 'POST',
+            body: JSON.stringify({ token, password }),
+        }, false);
+    },
+
+    async requestMagicLink(email) {
+        return fetchAPI('/auth/magic-link', {
+            method: 'POST',
+            body: JSON.stringify({ email }),
+        }, false);
+    },
+
+    async loginWithMagicLink(token) {
+        return fetchAPI('/auth/magic-link/login', {
+            method: 'POST',
+            body: JSON.stringify({ token }),
+        }, false);
+    },
+
+    // Sem email: o navegador oferece as passkeys que tiver para este site
+    async loginWithPasskey() {
+        const { ceremony, options } = await fetchAPI('/auth/passkeys/login/begin', { method: 'POST' }, false);
+        const publicKey = {
+            ...options.publicKey,
+            challenge: base64URLToBuffer(options.publicKey.challenge),
+            allowCredentials: (options.publicKey.allowCredentials || []).map((c) => ({ ...c, id: base64URLToBuffer(c.id) })),
+        };
+        const credential = await navigator.credentials.get({ publicKey });
+        return fetchAPI('/auth/passkeys/login/finish', {
+            method: 'POST',
+            body: JSON.stringify({ ceremony, credential: passkeyCredentialToJSON(credential) }),
+        }, false);
+    },
+
+    async logout() {
+        try {
+            await fetchAPI('/auth/logout', {
+                method: 'POST',
+                body: JSON.stringify({ refresh_token: localStorage.getItem('refreshToken') || '' }),
+            }, false);
+        } catch (error) {
+            // Tokens are dropped locally regardless
+            console.error('Logout error:', error);
+        } finally {
+            clearTokens();
+        }
     }
 };
 
@@ -109,11 +333,9 @@ export const productAPI = {
 
     async create(formData) {
         const response = await fetch(`${API_BASE_URL}/products`, {
+            ...authOptions(),
             method: 'POST',
             body: formData,
-            headers: {
-                'Authorization': `Bearer ${localStorage.getItem('token')}`
-            }
         });
 
         if (!response.ok) {
@@ -124,11 +346,9 @@ export const productAPI = {
 
     async update(id, formData) {
         const response = await fetch(`${API_BASE_URL}/products/${id}`, {
+            ...authOptions(),
             method: 'PUT',
             body: formData,
-            headers: {
-                'Authorization': `Bearer ${localStorage.getItem('token')}`
-            }
         });
 
         if (!response.ok) {
@@ -140,10 +360,8 @@ export const productAPI = {
 
     async delete(id) {
         const response = await fetch(`${API_BASE_URL}/products/${id}`, {
+            ...authOptions(),
             method: 'DELETE',
-            headers: {
-                'Authorization': `Bearer ${localStorage.getItem('token')}`
-            }
         });
 
         if (!response.ok) {
@@ -151,19 +369,84 @@ export const productAPI = {
         }
     },
 
-    getImage(imageName) {
-        return `${API_BASE_URL}/images/${imageName}`;
+    // size (em pixels) pede a variante quadrada mais próxima de uma foto de perfil
+    getImage(imageName, size) {
+        const url = `${API_BASE_URL}/images/${imageName}`;
+        return size ? `${url}?size=${size}` : url;
     }
 };
 
 // User API functions
 export const userAPI = {
-    async list() {
-        return fetchAPI('/users', {
+    // Uma página por vez: params aceita q, verified, created_after, created_before, sort, limit e cursor,
+    // além de filtros por atributo no formato { 'attr.job_title': 'Engenheira' }
+    async list(params = {}) {
+        const query = new URLSearchParams(
+            Object.entries(params).filter(([, value]) => value !== undefined && value !== null && value !== ''),
+        );
+        return fetchAPI(`/users?${query}`, {
+            method: 'GET',
+        });
+    },
+
+    // Dados da própria conta; as rotas com ID são só para administradores
+    async me() {
+        return fetchAPI('/users/me', {
+            method: 'GET',
+        });
+    },
+
+    async updateMe(data) {
+        return fetchAPI('/users/me', {
+            method: 'PATCH',
+            body: JSON.stringify(data),
+        });
+    },
+
+    // JSON Schema dos atributos do perfil (telefone, idioma, campos próprios da instalação...)
+    async attributeSchema() {
+        return fetchAPI('/users/attribute-schema', {
+            method: 'GET',
+        });
+    },
+
+    async uploadMyProfilePic(file) {
+        const formData = new FormData();
+        formData.append('profile_pic', file);
+        const response = await fetch(`${API_BASE_URL}/users/me/profile-pic`, {
+            ...authOptions(),
+            method: 'POST',
+            body: formData,
+        });
+
+        if (!response.ok) {
+            const error = await response.json();
+            throw new Error(error.message || 'Erro ao enviar foto de perfil');
+        }
+        return response.json();
+    },
+
+    // O arquivo é preparado em segundo plano; o link também chega por email
+    async requestExport() {
+        return fetchAPI('/users/me/export', {
+            method: 'POST',
+        });
+    },
+
+    async getExport(id) {
+        return fetchAPI(`/users/me/export/${id}`, {
             method: 'GET',
         });
     },
 
+    async deleteMe() {
+        const data = await fetchAPI('/users/me', {
+            method: 'DELETE',
+        });
+        clearTokens();
+        return data;
+    },
+
     async get(id) {
         return fetchAPI(`/users/${id}`, {
             method: 'GET',
@@ -182,6 +465,55 @@ export const userAPI = {
             method: 'DELETE',
         });
     },
+
+    async listSessions() {
+        return fetchAPI('/users/me/sessions', {
+            method: 'GET',
+        });
+    },
+
+    async revokeSession(id) {
+        return fetchAPI(`/users/me/sessions/${id}`, {
+            method: 'DELETE',
+        });
+    },
+
+    async listPasskeys() {
+        return fetchAPI('/users/me/passkeys', {
+            method: 'GET',
+        });
+    },
+
+    async registerPasskey(name) {
+        const { ceremony, options } = await fetchAPI('/users/me/passkeys/register/begin', { method: 'POST' });
+        const publicKey = {
+            ...options.publicKey,
+            challenge: base64URLToBuffer(options.publicKey.challenge),
+            user: { ...options.publicKey.user, id: base64URLToBuffer(options.publicKey.user.id) },
+            excludeCredentials: (options.publicKey.excludeCredentials || []).map((c) => ({ ...c, id: base64URLToBuffer(c.id) })),
+        };
+        const credential = await navigator.credentials.create({ publicKey });
+        return fetchAPI('/users/me/passkeys/register/finish', {
+            method: 'POST',
+            body: JSON.stringify({ ceremony, name, credential: passkeyCredentialToJSON(credential) }),
+        });
+    },
+
+    async deletePasskey(id) {
+        return fetchAPI(`/users/me/passkeys/${id}`, {
+            method: 'DELETE',
+        });
+    },
+
+    // As outras sessões são encerradas; guarda os novos tokens desta sessão
+    async changePassword(currentPassword, newPassword) {
+        const data = await fetchAPI('/users/me/password', {
+            method: 'PUT',
+            body: JSON.stringify({ current_password: currentPassword, new_password: newPassword }),
+        });
+        saveTokens(data.tokens);
+        return data;
+    },
 };
 
 // Navigation utility
@@ -189,14 +521,15 @@ export function updateNavigation() {
     const nav = document.querySelector('nav');
     if (!nav) return;
 
-    const token = localStorage.getItem('token');
     let navHTML = '';
 
-    if (token) {
+    if (isLoggedIn()) {
         navHTML = `
             <a href="index.html">Home</a>
             <a href="products.html">Gerenciar Produtos</a>
             <a href="users.html">Gerenciar Usuários</a>
+            <a href="profile.html">Meu Perfil</a>
+            <a href="sessions.html">Sessões</a>
             <button id="logoutButton">Sair</button>
         `;
     } else {
@@ -212,8 +545,8 @@ export function updateNavigation() {
     // Adiciona o event listener para o botão de logout
     const logoutButton = document.getElementById('logoutButton');
     if (logoutButton) {
-        logoutButton.addEventListener('click', () => {
-            localStorage.removeItem('token');
+        logoutButton.addEventListener('click', async () => {
+            await authAPI.logout();
             window.location.href = 'index.html';
         });
     }